@@ -0,0 +1,243 @@
+// Package client talks to the seat inventory server, so services can reserve, buy and query seats without
+// handling sockets themselves.
+//
+// A Client keeps a pool of connections and is safe to use from many goroutines. Every RESERVE and BUY is
+// sent with an idempotency key, which lets the client reconnect and retry after network errors without
+// ever applying a command twice. Commands can also be pipelined, sending many of them in one go:
+//
+//	c, err := client.New(client.Config{Address: "localhost:8099"})
+//	...
+//	p := c.Pipeline()
+//	reserved := p.Reserve("A1")
+//	seats := p.Query("A1", "A2")
+//	err = p.Exec(ctx)
+//	...
+//	err = reserved.Err()
+//	statuses := seats.Statuses()
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	Free        Status = "FREE"
+	Reserved    Status = "RESERVED"
+	Sold        Status = "SOLD"
+	Quarantined Status = "QUARANTINED"
+)
+
+// Codes the server fails commands with, see Error
+const (
+	CodeSeatFree            = "SEAT_FREE"
+	CodeSeatReserved        = "SEAT_RESERVED"
+	CodeSeatSold            = "SEAT_SOLD"
+	CodeSeatQuarantined     = "SEAT_QUARANTINED"
+	CodeInvalidMessage      = "INVALID_MESSAGE"
+	CodeUnknownCommand      = "UNKNOWN_COMMAND"
+	CodeNotPrivileged       = "NOT_PRIVILEGED"
+	CodeUnauthenticated     = "UNAUTHENTICATED"
+	CodeForbiddenTransition = "FORBIDDEN_TRANSITION"
+	CodeKeyReused           = "KEY_REUSED"
+	CodeReadOnly            = "READ_ONLY"
+	CodeNotApplied          = "NOT_APPLIED"
+	CodeOutcomeUnknown      = "OUTCOME_UNKNOWN"
+	CodeCrossPartition      = "CROSS_PARTITION"
+	CodeUnknownEvent        = "UNKNOWN_EVENT"
+	CodeEventExists         = "EVENT_EXISTS"
+	CodeEventClosed         = "EVENT_CLOSED"
+	CodeEventArchived       = "EVENT_ARCHIVED"
+)
+
+// PROTOCOL_VERSION is the one asked for with HELLO, the first with AUTH
+const PROTOCOL_VERSION = 4
+
+var ErrClosed = errors.New("client is closed")
+
+type Config struct {
+	// Address of the server, as host:port
+	Address string
+	// TLSConfig is used to connect over TLS when not nil
+	TLSConfig *tls.Config
+	// AuthToken is sent with AUTH on every connection when not empty
+	AuthToken string
+	// DialTimeout bounds how long opening a connection takes, on top of any context deadline. Defaults to 5s.
+	DialTimeout time.Duration
+	// MaxConnections is how many connections are open at most, callers wait for one to be free. Defaults to 8.
+	MaxConnections int
+	// MaxRetries is how many times a command is retried on a new connection after a network error. Defaults
+	// to 1, negative disables retries.
+	MaxRetries int
+}
+
+func (c Config) withDefaults() Config {
+	if c.DialTimeout == 0 {
+		c.DialTimeout = 5 * time.Second
+	}
+	if c.MaxConnections <= 0 {
+		c.MaxConnections = 8
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 1
+	}
+	if c.MaxRetries < 0 {
+		//Commands are still sent once
+		c.MaxRetries = 0
+	}
+	return c
+}
+
+// Client sends commands over a pool of connections, opening new ones when needed and dropping broken ones
+type Client struct {
+	config Config
+	slots  chan struct{}
+	idle   chan *conn
+	closed chan struct{}
+	close  sync.Once
+}
+
+// Reserve fails with an *Error with code CodeSeatReserved, CodeSeatSold, ... if the seat isn't FREE
+func (c *Client) Reserve(ctx context.Context, seat string) error {
+	p := c.Pipeline()
+	result := p.Reserve(seat)
+	if err := p.Exec(ctx); err != nil {
+		return err
+	}
+	return result.Err()
+}
+
+// Buy fails with an *Error with code CodeSeatFree, CodeSeatSold, ... if the seat isn't RESERVED
+func (c *Client) Buy(ctx context.Context, seat string) error {
+	p := c.Pipeline()
+	result := p.Buy(seat)
+	if err := p.Exec(ctx); err != nil {
+		return err
+	}
+	return result.Err()
+}
+
+// Query returns the status of each seat, in the same order
+func (c *Client) Query(ctx context.Context, seats ...string) ([]Status, error) {
+	p := c.Pipeline()
+	result := p.Query(seats...)
+	if err := p.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return result.Statuses(), result.Err()
+}
+
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{client: c}
+}
+
+// Close closes idle connections, connections in use are closed when they are given back
+func (c *Client) Close() error {
+	c.close.Do(func() { close(c.closed) })
+
+	for {
+		select {
+		case idle := <-c.idle:
+			idle.close()
+		default:
+			return nil
+		}
+	}
+}
+
+// send writes all requests on one connection and reads their responses, retrying on a new connection if
+// the one used breaks. Callers make sure requests are safe to send again.
+func (c *Client) send(ctx context.Context, requests []string) ([]string, error) {
+	var err error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		var connection *conn
+		connection, err = c.checkout(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var responses []string
+		responses, err = connection.roundTrip(ctx, requests)
+		c.checkin(connection, err == nil)
+		if err == nil {
+			return responses, nil
+		}
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			return nil, err
+		}
+		if _, isNetworkError := err.(net.Error); !isNetworkError && !isClosedByServer(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) checkout(ctx context.Context) (*conn, error) {
+	select {
+	case <-c.closed:
+		return nil, ErrClosed
+	default:
+	}
+
+	select {
+	case <-c.closed:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case c.slots <- struct{}{}:
+	}
+
+	select {
+	case idle := <-c.idle:
+		return idle, nil
+	default:
+	}
+
+	connection, err := dial(ctx, c.config)
+	if err != nil {
+		<-c.slots
+		return nil, err
+	}
+	return connection, nil
+}
+
+// checkin keeps healthy connections for later, broken ones and anything given back after Close is closed
+func (c *Client) checkin(connection *conn, healthy bool) {
+	defer func() { <-c.slots }()
+
+	select {
+	case <-c.closed:
+		healthy = false
+	default:
+	}
+
+	if healthy {
+		select {
+		case c.idle <- connection:
+			return
+		default:
+		}
+	}
+	connection.close()
+}
+
+// New creates a client, connections are only opened when commands are sent
+func New(config Config) (*Client, error) {
+	if config.Address == "" {
+		return nil, errors.New("the address of the server is required")
+	}
+
+	config = config.withDefaults()
+	return &Client{
+		config: config,
+		slots:  make(chan struct{}, config.MaxConnections),
+		idle:   make(chan *conn, config.MaxConnections),
+		closed: make(chan struct{}),
+	}, nil
+}
@@ -0,0 +1,369 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer speaks enough of the protocol to reserve, buy and query seats, remembering idempotency keys
+// like the real server does
+type fakeServer struct {
+	listener net.Listener
+	// closeAfter makes the server hang up after answering that many commands on a connection, when not zero
+	closeAfter int
+	// hangUps is how many connections closeAfter applies to, all of them when zero
+	hangUps int
+	// delay is how long the server waits before answering each batch of commands
+	delay time.Duration
+
+	lock        sync.Mutex
+	seats       map[string]Status
+	keys        map[string]string
+	commands    []string
+	connections int
+	open        int
+	maxOpen     int
+}
+
+func serve(t *testing.T, configure func(*fakeServer)) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error opening listener: %v", err)
+	}
+
+	server := &fakeServer{listener: listener, seats: map[string]Status{}, keys: map[string]string{}}
+	if configure != nil {
+		configure(server)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				//Listener was closed, test is over
+				return
+			}
+			go server.handle(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	s.lock.Lock()
+	s.connections++
+	closeAfter := s.closeAfter
+	if s.hangUps != 0 && s.connections > s.hangUps {
+		closeAfter = 0
+	}
+	s.open++
+	if s.open > s.maxOpen {
+		s.maxOpen = s.open
+	}
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.open--
+		s.lock.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for answered := 0; closeAfter == 0 || answered < closeAfter; answered++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if reader.Buffered() == 0 {
+			time.Sleep(s.delay)
+		}
+		fmt.Fprintf(conn, "%s\n", s.answer(strings.TrimSpace(line)))
+	}
+}
+
+func (s *fakeServer) answer(line string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	parts := strings.SplitN(line, ": ", 2)
+	command, argument := parts[0], parts[1]
+	if command != "HELLO" && command != "AUTH" {
+		s.commands = append(s.commands, line)
+	}
+
+	switch command {
+	case "HELLO":
+		return "OK 3 commands=RESERVE,BUY,QUERY errors=extended"
+	case "AUTH":
+		if argument != "s3cr3t" {
+			return "FAIL UNAUTHENTICATED no principal has the token given"
+		}
+		return "OK"
+	case "QUERY":
+		var statuses []string
+		for _, seat := range strings.Split(argument, ",") {
+			statuses = append(statuses, string(s.status(seat)))
+		}
+		return strings.Join(statuses, ",")
+	case "RESERVE", "BUY":
+		seatAndKey := strings.Split(argument, " key=")
+		if previous, seen := s.keys[seatAndKey[1]]; seen {
+			return previous
+		}
+		response := s.move(seatAndKey[0], map[string]Status{"RESERVE": Free, "BUY": Reserved}[command], map[string]Status{"RESERVE": Reserved, "BUY": Sold}[command])
+		s.keys[seatAndKey[1]] = response
+		return response
+	}
+	return "FAIL UNKNOWN_COMMAND unknown command"
+}
+
+func (s *fakeServer) status(seat string) Status {
+	if status, found := s.seats[seat]; found {
+		return status
+	}
+	return Free
+}
+
+func (s *fakeServer) move(seat string, from Status, to Status) string {
+	current := s.status(seat)
+	if current != from {
+		return fmt.Sprintf("FAIL SEAT_%s seat [%s] is [%s]", current, seat, current)
+	}
+	s.seats[seat] = to
+	return "OK"
+}
+
+func (s *fakeServer) stats() (commands int, connections int, maxOpen int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.commands), s.connections, s.maxOpen
+}
+
+func newClient(t *testing.T, server *fakeServer, config Config) *Client {
+	config.Address = server.listener.Addr().String()
+	c, err := New(config)
+	if err != nil {
+		t.Fatalf("Unexpected error creating client: %v", err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Reserves, buys and queries seats", func(t *testing.T) {
+		server := serve(t, nil)
+		defer server.listener.Close()
+		c := newClient(t, server, Config{})
+		defer c.Close()
+
+		if err := c.Reserve(ctx, "A1"); err != nil {
+			t.Fatalf("Unexpected error reserving: %v", err)
+		}
+		if err := c.Reserve(ctx, "A2"); err != nil {
+			t.Fatalf("Unexpected error reserving: %v", err)
+		}
+		if err := c.Buy(ctx, "A2"); err != nil {
+			t.Fatalf("Unexpected error buying: %v", err)
+		}
+
+		statuses, err := c.Query(ctx, "A1", "A2", "A3")
+		if err != nil {
+			t.Fatalf("Unexpected error querying: %v", err)
+		}
+		expected := []Status{Reserved, Sold, Free}
+		if fmt.Sprint(statuses) != fmt.Sprint(expected) {
+			t.Errorf("Expected %v, got %v", expected, statuses)
+		}
+	})
+
+	t.Run("Fails commands with the code the server gave", func(t *testing.T) {
+		server := serve(t, nil)
+		defer server.listener.Close()
+		c := newClient(t, server, Config{})
+		defer c.Close()
+
+		err := c.Buy(ctx, "A1")
+		failure := &Error{}
+		if !errors.As(err, &failure) || failure.Code != CodeSeatFree {
+			t.Errorf("Expected failure with [%s], got [%v]", CodeSeatFree, err)
+		}
+	})
+
+	t.Run("Authenticates every connection", func(t *testing.T) {
+		server := serve(t, nil)
+		defer server.listener.Close()
+
+		c := newClient(t, server, Config{AuthToken: "s3cr3t"})
+		defer c.Close()
+		if err := c.Reserve(ctx, "A1"); err != nil {
+			t.Errorf("Unexpected error with a valid token: %v", err)
+		}
+
+		refused := newClient(t, server, Config{AuthToken: "guess"})
+		defer refused.Close()
+		if err := refused.Reserve(ctx, "A2"); err == nil || !strings.Contains(err.Error(), CodeUnauthenticated) {
+			t.Errorf("Expected invalid token to be refused, got [%v]", err)
+		}
+	})
+
+	t.Run("Never opens more connections than allowed", func(t *testing.T) {
+		server := serve(t, func(s *fakeServer) { s.delay = 10 * time.Millisecond })
+		defer server.listener.Close()
+		c := newClient(t, server, Config{MaxConnections: 2})
+		defer c.Close()
+
+		var wait sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wait.Add(1)
+			go func(i int) {
+				defer wait.Done()
+				if err := c.Reserve(ctx, fmt.Sprintf("A%d", i)); err != nil {
+					t.Errorf("Unexpected error reserving: %v", err)
+				}
+			}(i)
+		}
+		wait.Wait()
+
+		commands, connections, maxOpen := server.stats()
+		if commands != 10 || connections > 2 || maxOpen > 2 {
+			t.Errorf("Expected 10 commands over at most 2 connections, got %d commands over %d connections, %d at once", commands, connections, maxOpen)
+		}
+	})
+
+	t.Run("Reconnects when the server hangs up", func(t *testing.T) {
+		//Every connection is closed after HELLO and one command, so each command after the first finds
+		//its pooled connection broken
+		server := serve(t, func(s *fakeServer) { s.closeAfter = 2 })
+		defer server.listener.Close()
+		c := newClient(t, server, Config{MaxConnections: 1})
+		defer c.Close()
+
+		for i := 0; i < 3; i++ {
+			if err := c.Reserve(ctx, fmt.Sprintf("A%d", i)); err != nil {
+				t.Fatalf("Unexpected error reserving after reconnecting: %v", err)
+			}
+		}
+
+		statuses, err := c.Query(ctx, "A0", "A1", "A2")
+		if err != nil || fmt.Sprint(statuses) != fmt.Sprint([]Status{Reserved, Reserved, Reserved}) {
+			t.Errorf("Expected every seat reserved, got %v and error [%v]", statuses, err)
+		}
+	})
+
+	t.Run("Sends commands once when retries are disabled", func(t *testing.T) {
+		server := serve(t, func(s *fakeServer) { s.closeAfter = 2 })
+		defer server.listener.Close()
+		c := newClient(t, server, Config{MaxConnections: 1, MaxRetries: -1})
+		defer c.Close()
+
+		if err := c.Reserve(ctx, "A0"); err != nil {
+			t.Fatalf("Unexpected error reserving: %v", err)
+		}
+		//The pooled connection was closed after the first command and isn't retried
+		if err := c.Reserve(ctx, "A1"); err == nil {
+			t.Errorf("Expected an error reserving on a connection the server hung up")
+		}
+		if commands, _, _ := server.stats(); commands != 1 {
+			t.Errorf("Expected only the first command to reach the server, [%d] did", commands)
+		}
+	})
+
+	t.Run("Gives up when the context is done", func(t *testing.T) {
+		server := serve(t, func(s *fakeServer) { s.delay = time.Second })
+		defer server.listener.Close()
+		c := newClient(t, server, Config{})
+		defer c.Close()
+
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		startedAt := time.Now()
+		err := c.Reserve(timeout, "A1")
+		if err != context.DeadlineExceeded {
+			t.Errorf("Expected deadline exceeded, got [%v]", err)
+		}
+		if took := time.Since(startedAt); took > 500*time.Millisecond {
+			t.Errorf("Expected to give up at the deadline, took %v", took)
+		}
+	})
+
+	t.Run("Refuses commands once closed", func(t *testing.T) {
+		server := serve(t, nil)
+		defer server.listener.Close()
+		c := newClient(t, server, Config{})
+		c.Close()
+
+		if err := c.Reserve(ctx, "A1"); err != ErrClosed {
+			t.Errorf("Expected [%v], got [%v]", ErrClosed, err)
+		}
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Sends every command on one connection and answers them in order", func(t *testing.T) {
+		server := serve(t, nil)
+		defer server.listener.Close()
+		c := newClient(t, server, Config{})
+		defer c.Close()
+
+		p := c.Pipeline()
+		reserved := p.Reserve("A1")
+		again := p.Reserve("A1")
+		bought := p.Buy("A1")
+		seats := p.Query("A1", "A2")
+		if err := p.Exec(ctx); err != nil {
+			t.Fatalf("Unexpected error executing pipeline: %v", err)
+		}
+
+		if reserved.Err() != nil || bought.Err() != nil {
+			t.Errorf("Expected reserve and buy to work, got [%v] and [%v]", reserved.Err(), bought.Err())
+		}
+		failure := &Error{}
+		if !errors.As(again.Err(), &failure) || failure.Code != CodeSeatReserved {
+			t.Errorf("Expected second reserve to fail with [%s], got [%v]", CodeSeatReserved, again.Err())
+		}
+		if fmt.Sprint(seats.Statuses()) != fmt.Sprint([]Status{Sold, Free}) {
+			t.Errorf("Expected [SOLD FREE], got %v", seats.Statuses())
+		}
+
+		if _, connections, _ := server.stats(); connections != 1 {
+			t.Errorf("Expected a single connection, got %d", connections)
+		}
+	})
+
+	t.Run("Resends commands with the same keys after reconnecting", func(t *testing.T) {
+		//The first connection answers HELLO and one command then hangs up, so the pipeline is sent again
+		//and the reserve that already happened must not fail the second time
+		server := serve(t, func(s *fakeServer) {
+			s.closeAfter = 2
+			s.hangUps = 1
+		})
+		defer server.listener.Close()
+		c := newClient(t, server, Config{})
+		defer c.Close()
+
+		p := c.Pipeline()
+		first := p.Reserve("A1")
+		second := p.Reserve("A2")
+		if err := p.Exec(ctx); err != nil {
+			t.Fatalf("Unexpected error executing pipeline: %v", err)
+		}
+		if first.Err() != nil || second.Err() != nil {
+			t.Errorf("Expected resent reserves to be answered like the first time, got [%v] and [%v]", first.Err(), second.Err())
+		}
+
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		if len(server.commands) != 3 || server.commands[0] != server.commands[1] {
+			t.Errorf("Expected the first reserve to be resent as it was, got %v", server.commands)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+}
+
+// roundTrip writes every request before reading any response, the server answers them in order
+func (c *conn) roundTrip(ctx context.Context, requests []string) ([]string, error) {
+	stop := c.watch(ctx)
+	defer stop()
+
+	var batch strings.Builder
+	for _, request := range requests {
+		batch.WriteString(request)
+		batch.WriteString("\n")
+	}
+	if _, err := io.WriteString(c.netConn, batch.String()); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	responses := make([]string, len(requests))
+	for i := range requests {
+		response, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		responses[i] = strings.TrimRight(response, "\r\n")
+	}
+
+	//A response that arrived as the context was cancelled may have raced the deadline, don't trust the connection
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return responses, nil
+}
+
+// watch makes reads and writes give up at the context deadline, or as soon as it is cancelled
+func (c *conn) watch(ctx context.Context) func() {
+	deadline, _ := ctx.Deadline()
+	c.netConn.SetDeadline(deadline)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.netConn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// handshake says HELLO, to get failure codes, and authenticates if there is a token
+func (c *conn) handshake(ctx context.Context, config Config) error {
+	requests := []string{fmt.Sprintf("HELLO: %d", PROTOCOL_VERSION)}
+	if config.AuthToken != "" {
+		requests = append(requests, "AUTH: "+config.AuthToken)
+	}
+
+	responses, err := c.roundTrip(ctx, requests)
+	if err != nil {
+		return err
+	}
+
+	//Servers that can't give failure codes settle for an older version, failures are then a bare FAIL
+	if !strings.HasPrefix(responses[0], "OK ") {
+		return fmt.Errorf("server refused protocol version [%d]: [%s]", PROTOCOL_VERSION, responses[0])
+	}
+
+	if config.AuthToken != "" {
+		if err := parseMutation(responses[1]); err != nil {
+			return fmt.Errorf("server refused the auth token: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c *conn) close() error {
+	return c.netConn.Close()
+}
+
+// contextError blames the context for errors caused by its deadline, the socket can time out a moment
+// before the context notices
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if deadline, set := ctx.Deadline(); set && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func isClosedByServer(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+func dial(ctx context.Context, config Config) (*conn, error) {
+	dialer := &net.Dialer{Timeout: config.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.TLSConfig != nil {
+		tlsConfig := config.TLSConfig
+		if tlsConfig.ServerName == "" && !tlsConfig.InsecureSkipVerify {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(config.Address)
+		}
+		netConn = tls.Client(netConn, tlsConfig)
+	}
+
+	c := &conn{
+		netConn: netConn,
+		reader:  bufio.NewReader(netConn),
+	}
+	err = c.handshake(ctx, config)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Error is the server failing a command. Code is one of the Code constants, or empty when the server
+// doesn't send codes.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return "command failed"
+	}
+	return fmt.Sprintf("command failed with [%s]: %s", e.Code, e.Message)
+}
+
+// Result is what one pipelined command got back, it is only filled once the pipeline is executed
+type Result struct {
+	parse    func(response string) ([]Status, error)
+	statuses []Status
+	err      error
+}
+
+// Err is the failure the server answered the command with, if any
+func (r *Result) Err() error {
+	return r.err
+}
+
+// Statuses is what a QUERY answered, in the same order as the seats asked about
+func (r *Result) Statuses() []Status {
+	return r.statuses
+}
+
+// Pipeline sends many commands on a single connection without waiting for each response. It isn't safe to
+// use from many goroutines, and shouldn't be reused after Exec.
+type Pipeline struct {
+	client   *Client
+	requests []string
+	results  []*Result
+}
+
+func (p *Pipeline) Reserve(seat string) *Result {
+	return p.add(fmt.Sprintf("RESERVE: %s key=%s", seat, newKey()), parseMutationResult)
+}
+
+func (p *Pipeline) Buy(seat string) *Result {
+	return p.add(fmt.Sprintf("BUY: %s key=%s", seat, newKey()), parseMutationResult)
+}
+
+func (p *Pipeline) Query(seats ...string) *Result {
+	return p.add("QUERY: "+strings.Join(seats, ","), func(response string) ([]Status, error) {
+		return parseQuery(response, len(seats))
+	})
+}
+
+func (p *Pipeline) add(request string, parse func(string) ([]Status, error)) *Result {
+	result := &Result{parse: parse}
+	p.requests = append(p.requests, request)
+	p.results = append(p.results, result)
+	return result
+}
+
+// Exec sends every command and fills their results. An error means the commands couldn't be sent or
+// their responses read, failures of single commands are in their Result.
+func (p *Pipeline) Exec(ctx context.Context) error {
+	if len(p.requests) == 0 {
+		return nil
+	}
+
+	responses, err := p.client.send(ctx, p.requests)
+	if err != nil {
+		return err
+	}
+
+	for i, response := range responses {
+		result := p.results[i]
+		result.statuses, result.err = result.parse(response)
+	}
+	return nil
+}
+
+func parseMutationResult(response string) ([]Status, error) {
+	return nil, parseMutation(response)
+}
+
+// parseMutation reads the OK or FAIL answered to commands that change something
+func parseMutation(response string) error {
+	if response == "OK" {
+		return nil
+	}
+	return parseFailure(response)
+}
+
+// parseFailure reads a bare FAIL, or one with a code and a message
+func parseFailure(response string) error {
+	parts := strings.SplitN(response, " ", 3)
+	if parts[0] != "FAIL" {
+		return fmt.Errorf("unexpected response [%s]", response)
+	}
+
+	failure := &Error{}
+	if len(parts) > 1 {
+		failure.Code = parts[1]
+	}
+	if len(parts) > 2 {
+		failure.Message = parts[2]
+	}
+	return failure
+}
+
+func parseQuery(response string, seats int) ([]Status, error) {
+	if strings.HasPrefix(response, "FAIL") {
+		return nil, parseFailure(response)
+	}
+
+	names := strings.Split(response, ",")
+	if len(names) != seats {
+		return nil, fmt.Errorf("asked about [%d] seats but got [%d] statuses in [%s]", seats, len(names), response)
+	}
+
+	statuses := make([]Status, len(names))
+	for i, name := range names {
+		statuses[i] = Status(name)
+	}
+	return statuses, nil
+}
+
+// newKey makes the idempotency key that lets a command be sent again after a network error
+func newKey() string {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		panic(fmt.Sprintf("could not generate idempotency key: %v", err))
+	}
+	return hex.EncodeToString(random)
+}
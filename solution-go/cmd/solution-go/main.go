@@ -0,0 +1,263 @@
+// Command solution-go runs the seat inventory server, on port 8099 unless told otherwise
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/partition"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/protocol"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/raft"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/replication"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/server"
+)
+
+// splitAddresses reads a comma separated list of addresses, ignoring blanks
+func splitAddresses(list string) []string {
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// replicaTlsConfig verifies the primary against the CAs in caFile, or the system ones when empty, and shows
+// it the certificate in certFile and keyFile if there is one
+func replicaTlsConfig(primary string, caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(primary)
+	if err != nil {
+		return nil, fmt.Errorf("invalid primary address [%s]: %v", primary, err)
+	}
+	config := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA certificates from [%s]: %v", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid PEM certificates found in [%s]", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load replica certificate [%s] and key [%s]: %v", certFile, keyFile, err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+func main() {
+	port := flag.Int("port", 8099, "Port clients connect to")
+	credentialsPath := flag.String("credentials", "", "JSON file with the principals clients authenticate as, everybody can query, reserve and buy when empty")
+	adminSecret := flag.String("admin-secret", "", "Secret clients must send to run admin commands like STATS and REFUND, admin commands are disabled when empty")
+	storePath := flag.String("store", "", "File seat statuses are kept in so they survive restarts, only kept in memory when empty")
+	storeType := flag.String("store-type", "file", "How the store file is laid out, either file, a log of every move read into memory when starting, or btree, pages read as needed")
+	historyPath := flag.String("history", "", "B-tree file seat histories are kept in, only kept in memory when empty")
+	bufferPoolPages := flag.Int("buffer-pool-pages", inventory.DEFAULT_BUFFER_POOL_PAGES, "How many pages of each B-tree file are cached in memory")
+	auditLogPath := flag.String("audit-log", "", "File every seat transition is appended to, only kept in memory when empty")
+	auditLogMaxBytes := flag.Int64("audit-log-max-bytes", 100*1024*1024, "Size at which the audit log is rotated")
+	auditLogBackups := flag.Int("audit-log-backups", 10, "How many rotated audit logs to keep")
+	idempotencyTtl := flag.Duration("idempotency-ttl", 10*time.Minute, "How long the response to a RESERVE or BUY with an idempotency key is remembered")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate served to clients, TLS is disabled when empty")
+	tlsKey := flag.String("tls-key", "", "PEM private key of the TLS certificate")
+	tlsClientCa := flag.String("tls-client-ca", "", "PEM CA certificates client certificates must be signed by, clients don't need one when empty")
+	tlsReloadInterval := flag.Duration("tls-reload-interval", 10*time.Second, "How often the TLS files are checked for changes")
+	replicationListen := flag.String("replication-listen", "", "Address replicas connect to for seat transitions, nobody can replicate this server when empty")
+	replicateFrom := flag.String("replicate-from", "", "Replication address of the primary this server follows as a read only replica until PROMOTE, it is a primary when empty")
+	replicateToken := flag.String("replicate-token", "", "Token of an admin principal, or the admin secret, sent to the primary, can be empty when -replicate-tls-cert identifies an admin")
+	replicateTls := flag.Bool("replicate-tls", false, "Connect to the primary over TLS, for primaries serving clients over TLS")
+	replicateTlsCa := flag.String("replicate-tls-ca", "", "PEM CA certificates the primary's certificate must be signed by, the system ones when empty")
+	replicateTlsCert := flag.String("replicate-tls-cert", "", "PEM certificate shown to primaries that require client certificates")
+	replicateTlsKey := flag.String("replicate-tls-key", "", "PEM private key of the replica certificate")
+	raftListen := flag.String("raft-listen", "", "Address other nodes of the cluster reach this one on, it is also its ID in the cluster, the server isn't part of one when empty")
+	raftPeers := flag.String("raft-peers", "", "Comma separated raft addresses of the other nodes of the cluster")
+	raftSecret := flag.String("raft-secret", "", "Secret every node of the raft cluster shares, connections from nodes without it are dropped, required with -raft-listen")
+	raftState := flag.String("raft-state", "", "File the raft term, vote, log and snapshots are kept in so they survive restarts, required with -raft-listen")
+	partitionListen := flag.String("partition-listen", "", "Address other nodes forward commands for this node's seats to, it is also its name on the hash ring, seats aren't partitioned when empty. Other nodes authenticate with -partition-secret on it.")
+	partitionNodes := flag.String("partition-nodes", "", "Comma separated partition addresses of the other nodes seats are spread over")
+	partitionSecret := flag.String("partition-secret", "", "Secret every partitioned node shares, other nodes send it before forwarding commands, required with -partition-listen")
+	partitionJoin := flag.Bool("partition-join", false, "Ask the other nodes to hand over the seats this node owns before serving, for nodes added to a running cluster")
+	idempotencyKeys := flag.Int("idempotency-keys", 100000, "How many idempotency keys are remembered at most")
+	flag.Parse()
+
+	logger := logging.NewLogger(true)
+
+	if *adminSecret != "" && !protocol.IsValidSecret(*adminSecret) {
+		logger.Errorf("the admin secret can only have letters, digits and underscores, clients couldn't send it otherwise")
+		os.Exit(1)
+	}
+
+	var auditLog *inventory.AuditLog
+	if *auditLogPath != "" {
+		var err error
+		auditLog, err = inventory.OpenAuditLog(*auditLogPath, *auditLogMaxBytes, *auditLogBackups)
+		if err != nil {
+			logger.Errorf("could not open audit log: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	audit := inventory.NewAuditTrail(auditLog)
+	if *historyPath != "" {
+		var err error
+		audit, err = inventory.OpenBTreeAuditTrail(auditLog, *historyPath, *bufferPoolPages)
+		if err != nil {
+			logger.Errorf("could not open seat histories: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	var store inventory.SeatStore = inventory.NewMapStore()
+	if *storePath != "" {
+		var err error
+		switch *storeType {
+		case "file":
+			store, err = inventory.OpenFileStore(*storePath)
+		case "btree":
+			store, err = inventory.OpenBTreeStore(*storePath, *bufferPoolPages)
+		default:
+			err = fmt.Errorf("unknown store type [%s]", *storeType)
+		}
+		if err != nil {
+			logger.Errorf("could not open seat store: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if *partitionListen != "" && !protocol.IsValidSecret(*partitionSecret) {
+		//Whoever reaches the peer address with it can do anything, admin commands included
+		logger.Errorf("partitioned nodes must share a secret of letters, digits and underscores, set -partition-secret")
+		os.Exit(1)
+	}
+	if *replicationListen != "" && *adminSecret == "" && *credentialsPath == "" {
+		//Replicas get every seat, only admins can follow this server
+		logger.Errorf("replicas must authenticate as admins, set -admin-secret or -credentials")
+		os.Exit(1)
+	}
+	if *raftListen != "" && *raftState == "" {
+		//Nodes that forget their vote could vote twice in a term, and forget entries they helped commit
+		logger.Errorf("raft nodes must keep their state in a file, set -raft-state")
+		os.Exit(1)
+	}
+	if *raftListen != "" && *raftSecret == "" {
+		//Anything reaching the raft port could otherwise take over leadership or append moves
+		logger.Errorf("raft nodes must share a secret, set -raft-secret")
+		os.Exit(1)
+	}
+	if *raftListen != "" && (*replicateFrom != "" || *storePath != "" || *partitionListen != "") {
+		//The cluster is what keeps seats, the inventory must start empty and only move with it
+		logger.Errorf("raft nodes can't replicate from a primary, keep their own seat store or be partitioned")
+		os.Exit(1)
+	}
+
+	seatInventory := inventory.NewStoredInventory(store, audit)
+	stats := server.NewStats()
+	idempotency := inventory.NewIdempotencyCache(*idempotencyTtl, *idempotencyKeys)
+	credentials := server.NewOpenCredentials()
+	if *credentialsPath != "" {
+		var err error
+		credentials, err = server.LoadCredentials(*credentialsPath)
+		if err != nil {
+			logger.Errorf("could not load credentials: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if *tlsCert != "" {
+		reloader, err := server.NewCertificateReloader(server.TlsFiles{CertFile: *tlsCert, KeyFile: *tlsKey, ClientCaFile: *tlsClientCa}, logger)
+		if err != nil {
+			logger.Errorf("could not load TLS configuration: %v", err)
+			os.Exit(1)
+		}
+		go reloader.Watch(*tlsReloadInterval)
+		tlsConfig = reloader.Config()
+	}
+
+	var replica *replication.Replica
+	if *replicateFrom != "" {
+		var replicaTls *tls.Config
+		if *replicateTls {
+			var err error
+			replicaTls, err = replicaTlsConfig(*replicateFrom, *replicateTlsCa, *replicateTlsCert, *replicateTlsKey)
+			if err != nil {
+				logger.Errorf("could not load replica TLS configuration: %v", err)
+				os.Exit(1)
+			}
+		}
+		replica = replication.NewReplica(seatInventory, *replicateFrom, *replicateToken, replicaTls, logger)
+		go replica.Follow()
+	}
+	if *replicationListen != "" {
+		//Replicas serve replicas too, those keep following it if it gets promoted
+		listener, err := net.Listen("tcp", *replicationListen)
+		if err != nil {
+			logger.Errorf("could not listen for replicas: %v", err)
+			os.Exit(1)
+		}
+		//Replicas see every seat, so they get the same TLS and credentials checks as clients
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		go replication.NewPrimary(seatInventory, server.NewReplicaAuthorizer(credentials, *adminSecret), logger).Serve(listener)
+	}
+
+	if *raftListen != "" {
+		peers := splitAddresses(*raftPeers)
+		node, err := raft.NewNode(raft.Config{ID: *raftListen, Peers: peers}, seatInventory, raft.NewTCPTransport(raft.DEFAULT_RPC_TIMEOUT, *raftSecret), raft.NewFileStorage(*raftState), logger)
+		if err != nil {
+			logger.Errorf("could not start raft node: %v", err)
+			os.Exit(1)
+		}
+		listener, err := net.Listen("tcp", *raftListen)
+		if err != nil {
+			logger.Errorf("could not listen for raft nodes: %v", err)
+			os.Exit(1)
+		}
+		go raft.Serve(listener, node, *raftSecret, logger)
+		node.Start()
+	}
+
+	var router *partition.Router
+	if *partitionListen != "" {
+		router = partition.NewRouter(*partitionListen, append(splitAddresses(*partitionNodes), *partitionListen), *partitionSecret, seatInventory, logger)
+		listener, err := net.Listen("tcp", *partitionListen)
+		if err != nil {
+			logger.Errorf("could not listen for other nodes: %v", err)
+			os.Exit(1)
+		}
+		if *partitionJoin {
+			if err := router.Join(); err != nil {
+				logger.Errorf("could not join the other nodes: %v", err)
+				os.Exit(1)
+			}
+		}
+		//Only nodes with the peer secret run admin commands there, the admin secret is for the client port
+		peerHandler := server.NewHandler(seatInventory, idempotency, stats, server.NewPeerCredentials(*partitionSecret), "", replica, router)
+		go server.NewServer(0, peerHandler, stats, nil, logger).Serve(listener)
+	}
+
+	handler := server.NewHandler(seatInventory, idempotency, stats, credentials, *adminSecret, replica, router)
+
+	err := server.NewServer(*port, handler, stats, tlsConfig, logger).Start()
+	if err != nil {
+		os.Exit(1)
+	}
+}
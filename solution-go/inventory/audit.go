@@ -0,0 +1,196 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Actor is whoever asked for a seat to change, so that disputes can be traced back to a connection
+type Actor struct {
+	ConnectionID  int64
+	RemoteAddress string
+}
+
+// AuditEntry is one transition of a seat, as kept in memory and written to the audit log
+type AuditEntry struct {
+	Time          time.Time  `json:"time"`
+	Seat          Seat       `json:"seat"`
+	From          SeatStatus `json:"from"`
+	To            SeatStatus `json:"to"`
+	ConnectionID  int64      `json:"connection_id"`
+	RemoteAddress string     `json:"remote_address"`
+	Reason        string     `json:"reason,omitempty"`
+}
+
+// AuditTrail remembers every transition of every seat, and also appends them to a log on disk if given one
+type AuditTrail struct {
+	history auditHistory
+	log     *AuditLog
+	lock    sync.Mutex
+}
+
+// auditHistory is where an AuditTrail keeps entries to look them up by seat
+type auditHistory interface {
+	append(entry AuditEntry) error
+	entries(seat Seat) ([]AuditEntry, error)
+}
+
+type memoryHistory map[Seat][]AuditEntry
+
+func (h memoryHistory) append(entry AuditEntry) error {
+	h[entry.Seat] = append(h[entry.Seat], entry)
+	return nil
+}
+
+func (h memoryHistory) entries(seat Seat) ([]AuditEntry, error) {
+	return append([]AuditEntry{}, h[seat]...), nil
+}
+
+// Record remembers the entry, and appends it to the log if there is one. The log is written last, so it
+// never has transitions the history refused.
+func (a *AuditTrail) Record(entry AuditEntry) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	err := a.history.append(entry)
+	if err != nil {
+		return fmt.Errorf("could not record seat [%s] in its history: %v", entry.Seat, err)
+	}
+
+	if a.log != nil {
+		err := a.log.Write(entry)
+		if err != nil {
+			return fmt.Errorf("could not write seat [%s] to audit log: %v", entry.Seat, err)
+		}
+	}
+	return nil
+}
+
+// synced waits for the entries recorded so far to be durable, histories that aren't group synced already
+// are when they are recorded
+func (a *AuditTrail) synced() func() error {
+	if history, ok := a.history.(groupSynced); ok {
+		return history.synced()
+	}
+	return func() error { return nil }
+}
+
+// History is every transition of the seat, oldest first
+func (a *AuditTrail) History(seat Seat) ([]AuditEntry, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.history.entries(seat)
+}
+
+// Close closes the file the history is kept in, if there is one. The log is left to whoever opened it.
+func (a *AuditTrail) Close() error {
+	if closer, ok := a.history.(interface{ close() error }); ok {
+		return closer.close()
+	}
+	return nil
+}
+
+// AuditLog writes one JSON entry per line, moving the file aside once it reaches maxBytes. Only the
+// newest backups are kept, named path.1 (newest) to path.N (oldest).
+type AuditLog struct {
+	path     string
+	maxBytes int64
+	backups  int
+	file     *os.File
+	size     int64
+}
+
+// Write appends the entry as a JSON line, rotating the file first if the line wouldn't fit
+func (l *AuditLog) Write(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		err = l.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	written, err := l.file.Write(line)
+	l.size += int64(written)
+	return err
+}
+
+func (l *AuditLog) Close() error {
+	return l.file.Close()
+}
+
+func (l *AuditLog) rotate() error {
+	err := l.file.Close()
+	if err != nil {
+		return err
+	}
+
+	if l.backups > 0 {
+		for n := l.backups - 1; n > 0; n-- {
+			err = os.Rename(backupPath(l.path, n), backupPath(l.path, n+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		err = os.Rename(l.path, backupPath(l.path, 1))
+	} else {
+		err = os.Remove(l.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+func (l *AuditLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// OpenAuditLog appends to the file at path, rotating it at maxBytes and keeping that many backups
+func OpenAuditLog(path string, maxBytes int64, backups int) (*AuditLog, error) {
+	log := &AuditLog{
+		path:     path,
+		maxBytes: maxBytes,
+		backups:  backups,
+	}
+
+	err := log.open()
+	if err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// NewAuditTrail only keeps transitions in memory when log is nil
+func NewAuditTrail(log *AuditLog) *AuditTrail {
+	return &AuditTrail{
+		history: memoryHistory{},
+		log:     log,
+	}
+}
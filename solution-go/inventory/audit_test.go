@@ -0,0 +1,240 @@
+package inventory
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readAuditLog(t *testing.T, path string) []AuditEntry {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Error opening audit log [%s]: %v", path, err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Error parsing audit log line [%s]: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func historyOf(t *testing.T, inventory *Inventory, seat Seat) []AuditEntry {
+	history, err := inventory.History(seat)
+	if err != nil {
+		t.Fatalf("Unexpected error getting the history of seat [%s]: %v", seat, err)
+	}
+	return history
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+	return dir
+}
+
+func TestAuditTrail(t *testing.T) {
+	t.Run("Inventory records who changed each seat and when", func(t *testing.T) {
+		inventory := NewInventory()
+		buyer := Actor{ConnectionID: 2, RemoteAddress: "10.0.0.2:4000"}
+
+		inventory.Reserve("A1", testActor)
+		inventory.Reserve("A1", buyer)
+		inventory.Buy("A1", buyer)
+
+		history := historyOf(t, inventory, "A1")
+		if len(history) != 2 {
+			t.Fatalf("Expected only successful transitions to be recorded, got %v", history)
+		}
+
+		reserved, sold := history[0], history[1]
+		if reserved.From != FREE || reserved.To != RESERVED || reserved.ConnectionID != 1 || reserved.RemoteAddress != "127.0.0.1:5000" {
+			t.Errorf("Unexpected entry for reservation: %+v", reserved)
+		}
+		if sold.From != RESERVED || sold.To != SOLD || sold.ConnectionID != 2 || sold.RemoteAddress != "10.0.0.2:4000" {
+			t.Errorf("Unexpected entry for sale: %+v", sold)
+		}
+		if sold.Time.Before(reserved.Time) {
+			t.Errorf("Expected entries to be in the order they happened, got %v", history)
+		}
+
+		if len(historyOf(t, inventory, "B1")) != 0 {
+			t.Errorf("Expected seats that never changed to have no history")
+		}
+	})
+
+	t.Run("Seats dont change if the transition cant be audited", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		log, err := OpenAuditLog(filepath.Join(dir, "audit.log"), 1024, 1)
+		if err != nil {
+			t.Fatalf("Error opening audit log: %v", err)
+		}
+		log.Close()
+
+		inventory := NewAuditedInventory(NewAuditTrail(log))
+		err = inventory.Reserve("A1", testActor)
+		if err == nil {
+			t.Fatalf("Expected error when audit log cant be written")
+		}
+
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, FREE)
+	})
+
+	t.Run("Keeps histories in a B-tree across restarts", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "history")
+
+		audit, err := OpenBTreeAuditTrail(nil, path, 4)
+		if err != nil {
+			t.Fatalf("Error opening audit trail: %v", err)
+		}
+		inventory := NewAuditedInventory(audit)
+		for i := 0; i < 200; i++ {
+			inventory.Reserve(Seat(fmt.Sprintf("A%d", i)), testActor)
+		}
+		inventory.Buy("A1", testActor)
+		inventory.Refund("A1", FREE, "customer cancelled", testActor)
+		audit.Close()
+
+		reopened, err := OpenBTreeAuditTrail(nil, path, 4)
+		if err != nil {
+			t.Fatalf("Error reopening audit trail: %v", err)
+		}
+		defer reopened.Close()
+		inventory = NewAuditedInventory(reopened)
+		inventory.Reserve("A1", testActor)
+
+		history := historyOf(t, inventory, "A1")
+		var moves []SeatStatus
+		for _, entry := range history {
+			moves = append(moves, entry.To)
+		}
+		if expected := []SeatStatus{RESERVED, SOLD, FREE, RESERVED}; !reflect.DeepEqual(moves, expected) {
+			t.Errorf("Expected seat [A1] to have moved to %v, got %v", expected, moves)
+		}
+		if history[2].Reason != "customer cancelled" || history[0].ConnectionID != testActor.ConnectionID {
+			t.Errorf("Expected entries to be kept whole, got %+v", history)
+		}
+		if len(historyOf(t, inventory, "A10")) != 1 || len(historyOf(t, inventory, "B1")) != 0 {
+			t.Errorf("Expected each seat to only have its own history")
+		}
+	})
+
+	t.Run("Only logs transitions the history kept", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		logPath := filepath.Join(dir, "audit.log")
+		log, err := OpenAuditLog(logPath, 1024*1024, 1)
+		if err != nil {
+			t.Fatalf("Error opening audit log: %v", err)
+		}
+		defer log.Close()
+		audit, err := OpenBTreeAuditTrail(log, filepath.Join(dir, "history"), 4)
+		if err != nil {
+			t.Fatalf("Error opening audit trail: %v", err)
+		}
+		defer audit.Close()
+		inventory := NewAuditedInventory(audit)
+
+		inventory.Reserve("A1", testActor)
+		inventory.Buy("A1", testActor)
+		if err := inventory.Refund("A1", FREE, strings.Repeat("x", MAX_VALUE_SIZE), testActor); err == nil {
+			t.Fatalf("Expected error refunding with a reason too long for the history")
+		}
+
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, SOLD)
+		if logged := readAuditLog(t, logPath); len(logged) != 2 {
+			t.Errorf("Expected only the reservation and sale to be logged, got %+v", logged)
+		}
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	t.Run("Writes one entry per line", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "audit.log")
+
+		log, err := OpenAuditLog(path, 1024*1024, 1)
+		if err != nil {
+			t.Fatalf("Error opening audit log: %v", err)
+		}
+
+		inventory := NewAuditedInventory(NewAuditTrail(log))
+		inventory.Reserve("A1", testActor)
+		inventory.Buy("A1", testActor)
+		log.Close()
+
+		entries := readAuditLog(t, path)
+		if len(entries) != 2 || entries[0].Seat != "A1" || entries[1].To != SOLD || entries[1].RemoteAddress != testActor.RemoteAddress {
+			t.Errorf("Unexpected entries in audit log: %+v", entries)
+		}
+	})
+
+	t.Run("Rotates once full and keeps only the newest backups", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "audit.log")
+
+		//Small enough that each entry goes to a file of its own
+		log, err := OpenAuditLog(path, 10, 2)
+		if err != nil {
+			t.Fatalf("Error opening audit log: %v", err)
+		}
+
+		for _, seat := range []Seat{"A1", "A2", "A3", "A4"} {
+			if err := log.Write(AuditEntry{Seat: seat}); err != nil {
+				t.Fatalf("Error writing to audit log: %v", err)
+			}
+		}
+		log.Close()
+
+		expectations := map[string]Seat{path: "A4", path + ".1": "A3", path + ".2": "A2"}
+		for file, seat := range expectations {
+			entries := readAuditLog(t, file)
+			if len(entries) != 1 || entries[0].Seat != seat {
+				t.Errorf("Expected [%s] to hold only seat [%s], got %+v", file, seat, entries)
+			}
+		}
+
+		if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+			t.Errorf("Expected oldest backup to be removed, got %v", err)
+		}
+	})
+
+	t.Run("Appends to existing logs", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "audit.log")
+
+		for _, seat := range []Seat{"A1", "A2"} {
+			log, err := OpenAuditLog(path, 1024*1024, 1)
+			if err != nil {
+				t.Fatalf("Error opening audit log: %v", err)
+			}
+			log.Write(AuditEntry{Seat: seat})
+			log.Close()
+		}
+
+		if entries := readAuditLog(t, path); len(entries) != 2 {
+			t.Errorf("Expected both entries to be kept, got %+v", entries)
+		}
+	})
+}
@@ -0,0 +1,290 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	MAX_KEY_SIZE   = 255
+	MAX_VALUE_SIZE = 1024
+)
+
+const (
+	leafNode     byte = 1
+	internalNode byte = 2
+	// nodeHeaderSize is the node kind, how many keys it has and, for internal nodes, its leftmost child
+	nodeHeaderSize = 8
+)
+
+// node is a page of the tree decoded. Leaves have a value per key, internal nodes have one child more than
+// keys, children[i] holding the keys from keys[i-1] up to, but not including, keys[i].
+type node struct {
+	leaf     bool
+	keys     [][]byte
+	values   [][]byte
+	children []pageID
+}
+
+func (n *node) cellSize(i int) int {
+	if n.leaf {
+		return 2 + len(n.keys[i]) + 2 + len(n.values[i])
+	}
+	return 2 + len(n.keys[i]) + 4
+}
+
+func (n *node) size() int {
+	size := nodeHeaderSize
+	for i := range n.keys {
+		size += n.cellSize(i)
+	}
+	return size
+}
+
+func (n *node) encode() []byte {
+	data := make([]byte, PAGE_SIZE)
+	data[0] = internalNode
+	if n.leaf {
+		data[0] = leafNode
+	} else {
+		binary.BigEndian.PutUint32(data[4:], uint32(n.children[0]))
+	}
+	binary.BigEndian.PutUint16(data[1:], uint16(len(n.keys)))
+
+	offset := nodeHeaderSize
+	for i, key := range n.keys {
+		binary.BigEndian.PutUint16(data[offset:], uint16(len(key)))
+		offset += 2 + copy(data[offset+2:], key)
+		if n.leaf {
+			binary.BigEndian.PutUint16(data[offset:], uint16(len(n.values[i])))
+			offset += 2 + copy(data[offset+2:], n.values[i])
+		} else {
+			binary.BigEndian.PutUint32(data[offset:], uint32(n.children[i+1]))
+			offset += 4
+		}
+	}
+	return data
+}
+
+func decodeNode(id pageID, data []byte) (*node, error) {
+	if data[0] != leafNode && data[0] != internalNode {
+		return nil, fmt.Errorf("%v: page [%d] isn't a node", errCorruptPage, id)
+	}
+	n := &node{leaf: data[0] == leafNode}
+	count := int(binary.BigEndian.Uint16(data[1:]))
+	n.keys = make([][]byte, 0, count)
+	if n.leaf {
+		n.values = make([][]byte, 0, count)
+	} else {
+		n.children = make([]pageID, 0, count+1)
+		n.children = append(n.children, pageID(binary.BigEndian.Uint32(data[4:])))
+	}
+
+	//Keys and values are cut from a copy of the whole page, which the pool can change under them. Slicing
+	//past the page panics, which a corrupt page must not do.
+	data = append([]byte(nil), data...)
+	offset := nodeHeaderSize
+	field := func(size int) []byte {
+		if offset+size > len(data) {
+			return nil
+		}
+		value := data[offset : offset+size : offset+size]
+		offset += size
+		return value
+	}
+	for i := 0; i < count; i++ {
+		sizes := field(2)
+		if sizes == nil {
+			return nil, fmt.Errorf("%v: page [%d] is cut short", errCorruptPage, id)
+		}
+		key := field(int(binary.BigEndian.Uint16(sizes)))
+		if n.leaf {
+			sizes = field(2)
+			if key == nil || sizes == nil {
+				return nil, fmt.Errorf("%v: page [%d] is cut short", errCorruptPage, id)
+			}
+			value := field(int(binary.BigEndian.Uint16(sizes)))
+			if value == nil {
+				return nil, fmt.Errorf("%v: page [%d] is cut short", errCorruptPage, id)
+			}
+			n.values = append(n.values, value)
+		} else {
+			child := field(4)
+			if key == nil || child == nil {
+				return nil, fmt.Errorf("%v: page [%d] is cut short", errCorruptPage, id)
+			}
+			n.children = append(n.children, pageID(binary.BigEndian.Uint32(child)))
+		}
+		n.keys = append(n.keys, key)
+	}
+	return n, nil
+}
+
+// childFor is the index of the child that holds the key
+func (n *node) childFor(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) > 0 })
+}
+
+// split moves the upper half of the node, by size, to a new node, returning it with the key separating them
+func (n *node) split() ([]byte, *node) {
+	half, size, mid := n.size()/2, nodeHeaderSize, 0
+	for mid < len(n.keys)-1 && size < half {
+		size += n.cellSize(mid)
+		mid++
+	}
+	if mid == 0 {
+		mid = 1
+	}
+
+	if n.leaf {
+		right := &node{leaf: true, keys: n.keys[mid:], values: n.values[mid:]}
+		n.keys, n.values = n.keys[:mid:mid], n.values[:mid:mid]
+		return right.keys[0], right
+	}
+	//The separating key moves up, it doesn't stay in either half
+	separator := n.keys[mid]
+	right := &node{keys: n.keys[mid+1:], children: n.children[mid+1:]}
+	n.keys, n.children = n.keys[:mid:mid], n.children[:mid+1:mid+1]
+	return separator, right
+}
+
+// btree maps keys to values in pages of a file, keeping them sorted. Changes are made in the pager's open
+// transaction, callers commit or roll it back. There is no deleting, keys only ever get new values.
+type btree struct {
+	pager *pager
+}
+
+func (t *btree) read(id pageID) (*node, error) {
+	data, err := t.pager.read(id)
+	if err != nil {
+		return nil, err
+	}
+	return decodeNode(id, data)
+}
+
+func (t *btree) get(key []byte) ([]byte, bool, error) {
+	id := t.pager.root
+	for {
+		n, err := t.read(id)
+		if err != nil {
+			return nil, false, err
+		}
+		if !n.leaf {
+			id = n.children[n.childFor(key)]
+			continue
+		}
+		i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) >= 0 })
+		if i < len(n.keys) && bytes.Equal(n.keys[i], key) {
+			return n.values[i], true, nil
+		}
+		return nil, false, nil
+	}
+}
+
+func (t *btree) put(key []byte, value []byte) error {
+	if len(key) == 0 || len(key) > MAX_KEY_SIZE {
+		return fmt.Errorf("keys must have between 1 and %d bytes, [%s] has %d", MAX_KEY_SIZE, key, len(key))
+	}
+	if len(value) > MAX_VALUE_SIZE {
+		return fmt.Errorf("values can't have more than %d bytes, the one for [%s] has %d", MAX_VALUE_SIZE, key, len(value))
+	}
+
+	separator, right, err := t.insert(t.pager.root, key, value)
+	if err != nil || right == 0 {
+		return err
+	}
+	//The root split, the tree grows a level
+	root := t.pager.allocate()
+	err = t.pager.write(root, (&node{keys: [][]byte{separator}, children: []pageID{t.pager.root, right}}).encode())
+	if err != nil {
+		return err
+	}
+	t.pager.root = root
+	return nil
+}
+
+// insert puts the key in the subtree under the page, returning the new page and separating key if it split
+func (t *btree) insert(id pageID, key []byte, value []byte) ([]byte, pageID, error) {
+	n, err := t.read(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if n.leaf {
+		i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) >= 0 })
+		if i < len(n.keys) && bytes.Equal(n.keys[i], key) {
+			n.values[i] = value
+		} else {
+			n.keys = append(n.keys[:i], append([][]byte{key}, n.keys[i:]...)...)
+			n.values = append(n.values[:i], append([][]byte{value}, n.values[i:]...)...)
+		}
+	} else {
+		i := n.childFor(key)
+		separator, right, err := t.insert(n.children[i], key, value)
+		if err != nil || right == 0 {
+			return nil, 0, err
+		}
+		n.keys = append(n.keys[:i], append([][]byte{separator}, n.keys[i:]...)...)
+		n.children = append(n.children[:i+1], append([]pageID{right}, n.children[i+1:]...)...)
+	}
+
+	if n.size() <= PAGE_SIZE {
+		return nil, 0, t.pager.write(id, n.encode())
+	}
+	separator, rightNode := n.split()
+	right := t.pager.allocate()
+	if err := t.pager.write(id, n.encode()); err != nil {
+		return nil, 0, err
+	}
+	return separator, right, t.pager.write(right, rightNode.encode())
+}
+
+// synced returns a function waiting until everything committed so far is durable
+func (t *btree) synced() func() error {
+	write := t.pager.syncs.written()
+	return func() error { return t.pager.syncs.wait(write) }
+}
+
+// scan visits keys in order, starting at from, until visit returns false
+func (t *btree) scan(from []byte, visit func(key []byte, value []byte) bool) error {
+	_, err := t.scanPage(t.pager.root, from, visit)
+	return err
+}
+
+func (t *btree) scanPage(id pageID, from []byte, visit func(key []byte, value []byte) bool) (bool, error) {
+	n, err := t.read(id)
+	if err != nil {
+		return false, err
+	}
+
+	if n.leaf {
+		for i, key := range n.keys {
+			if bytes.Compare(key, from) >= 0 && !visit(key, n.values[i]) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	for _, child := range n.children[n.childFor(from):] {
+		more, err := t.scanPage(child, from, visit)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// openBTree opens the tree in the file at path, creating an empty one if there is no file, caching at most
+// poolPages pages in memory
+func openBTree(path string, poolPages int) (*btree, error) {
+	if poolPages < 1 {
+		return nil, fmt.Errorf("the buffer pool needs at least one page, got %d", poolPages)
+	}
+	p, err := openPager(path, poolPages, (&node{leaf: true}).encode())
+	if err != nil {
+		return nil, err
+	}
+	return &btree{pager: p}, nil
+}
@@ -0,0 +1,181 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+)
+
+// BTreeStore keeps seats in a B-tree in a file, only caching a bounded number of pages in memory, so
+// venues with more seats than fit in memory can be sold. Every move is synced to the tree's write ahead
+// log before it is acknowledged, moves made at once sharing syncs, and the log is replayed when opening
+// after a crash.
+type BTreeStore struct {
+	tree *btree
+	lock sync.Mutex
+}
+
+func (s *BTreeStore) Get(seat Seat) (SeatStatus, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.get(seat)
+}
+
+func (s *BTreeStore) CompareAndSwap(seat Seat, old SeatStatus, status SeatStatus) (bool, error) {
+	swapped, err := s.compareAndSwapUnsynced(seat, old, status)
+	if err != nil || !swapped {
+		return swapped, err
+	}
+	return true, s.synced()()
+}
+
+func (s *BTreeStore) compareAndSwapUnsynced(seat Seat, old SeatStatus, status SeatStatus) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current, err := s.get(seat)
+	if err != nil || current != old {
+		return false, err
+	}
+
+	err = s.tree.put([]byte(seat), []byte(status))
+	if err == nil {
+		_, err = s.tree.pager.commit()
+	}
+	if err != nil {
+		s.tree.pager.rollback()
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *BTreeStore) synced() func() error {
+	return s.tree.synced()
+}
+
+func (s *BTreeStore) Range(from Seat, visit func(seat Seat, status SeatStatus) bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.tree.scan([]byte(from), func(key []byte, value []byte) bool {
+		return visit(Seat(key), SeatStatus(value))
+	})
+}
+
+func (s *BTreeStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.tree.pager.close()
+}
+
+func (s *BTreeStore) get(seat Seat) (SeatStatus, error) {
+	value, found, err := s.tree.get([]byte(seat))
+	if err != nil || !found {
+		return FREE, err
+	}
+	return SeatStatus(value), nil
+}
+
+// OpenBTreeStore opens the seats in the B-tree file at path, creating it if it doesn't exist, caching at
+// most poolPages pages of it in memory
+func OpenBTreeStore(path string, poolPages int) (*BTreeStore, error) {
+	tree, err := openBTree(path, poolPages)
+	if err != nil {
+		return nil, err
+	}
+	return &BTreeStore{tree: tree}, nil
+}
+
+// HISTORY_KEY_OVERHEAD is what history keys add to the seat, a separator and the entry's position
+const HISTORY_KEY_OVERHEAD = 1 + 4
+
+// treeHistory keeps audit entries in a B-tree, keyed by the seat and the entry's position in its history.
+// Each seat also has a counter with the position its next entry goes in.
+type treeHistory struct {
+	tree *btree
+}
+
+func (h *treeHistory) append(entry AuditEntry) error {
+	prefix := historyPrefix(entry.Seat)
+	counterKey := historyCounterKey(entry.Seat)
+	var position uint32
+	counter, found, err := h.tree.get(counterKey)
+	if err != nil {
+		return err
+	}
+	if found {
+		position = binary.BigEndian.Uint32(counter)
+	}
+
+	//Reasons escaped for HTML could grow six times, only quotes and backslashes grow when they aren't
+	var value bytes.Buffer
+	encoder := json.NewEncoder(&value)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(entry); err != nil {
+		return err
+	}
+	key := make([]byte, len(entry.Seat)+HISTORY_KEY_OVERHEAD)
+	binary.BigEndian.PutUint32(key[copy(key, prefix):], position)
+
+	next := make([]byte, 4)
+	binary.BigEndian.PutUint32(next, position+1)
+	err = h.tree.put(key, bytes.TrimSuffix(value.Bytes(), []byte("\n")))
+	if err == nil {
+		err = h.tree.put(counterKey, next)
+	}
+	if err == nil {
+		_, err = h.tree.pager.commit()
+	}
+	if err != nil {
+		h.tree.pager.rollback()
+	}
+	return err
+}
+
+func (h *treeHistory) synced() func() error {
+	return h.tree.synced()
+}
+
+func (h *treeHistory) entries(seat Seat) ([]AuditEntry, error) {
+	prefix := historyPrefix(seat)
+	entries := []AuditEntry{}
+	var err error
+	scanErr := h.tree.scan(prefix, func(key []byte, value []byte) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		var entry AuditEntry
+		err = json.Unmarshal(value, &entry)
+		entries = append(entries, entry)
+		return err == nil
+	})
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return entries, err
+}
+
+func (h *treeHistory) close() error {
+	return h.tree.pager.close()
+}
+
+// historyPrefix ends the seat with a zero byte, which seats can't have, so that A1 doesn't prefix A10
+func historyPrefix(seat Seat) []byte {
+	return append([]byte(seat), 0)
+}
+
+// historyCounterKey ends the seat with a byte seats can't have either, sorting right after its entries
+func historyCounterKey(seat Seat) []byte {
+	return append([]byte(seat), 1)
+}
+
+// OpenBTreeAuditTrail is an audit trail keeping seat histories in the B-tree file at path instead of
+// memory, caching at most poolPages pages of it, and appending to the log if given one
+func OpenBTreeAuditTrail(log *AuditLog, path string, poolPages int) (*AuditTrail, error) {
+	tree, err := openBTree(path, poolPages)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditTrail{history: &treeHistory{tree: tree}, log: log}, nil
+}
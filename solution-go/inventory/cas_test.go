@@ -0,0 +1,131 @@
+package inventory
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCompareAndSet(t *testing.T) {
+	t.Run("Moves seats along allowed transitions", func(t *testing.T) {
+		inventory := NewInventory()
+		steps := []struct {
+			from       SeatStatus
+			to         SeatStatus
+			privileged bool
+		}{
+			{FREE, RESERVED, false},
+			{RESERVED, FREE, true},
+			{FREE, RESERVED, false},
+			{RESERVED, SOLD, false},
+			{SOLD, FREE, true},
+		}
+
+		for _, step := range steps {
+			err := inventory.CompareAndSet("A1", step.from, step.to, step.privileged, testActor)
+			if err != nil {
+				t.Fatalf("Unexpected error moving from [%s] to [%s]: %v", step.from, step.to, err)
+			}
+			expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, step.to)
+		}
+
+		if history := historyOf(t, inventory, "A1"); len(history) != len(steps) {
+			t.Errorf("Expected every transition to be audited, got %v", history)
+		}
+	})
+
+	t.Run("Only moves seats in the expected status", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.Reserve("A1", testActor)
+
+		err := inventory.CompareAndSet("A1", FREE, RESERVED, false, testActor)
+		if err == nil {
+			t.Fatalf("Expected error when seat isnt in the expected status")
+		}
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, RESERVED)
+	})
+
+	t.Run("Rejects transitions that arent allowed", func(t *testing.T) {
+		inventory := NewInventory()
+		forbidden := [][]SeatStatus{{FREE, SOLD}, {SOLD, RESERVED}, {FREE, FREE}, {SOLD, SOLD}}
+
+		for _, transition := range forbidden {
+			err := inventory.CompareAndSet("A1", transition[0], transition[1], true, testActor)
+			if err == nil {
+				t.Errorf("Expected error moving from [%s] to [%s]", transition[0], transition[1])
+			}
+		}
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, FREE)
+	})
+
+	t.Run("Rejects privileged transitions from ordinary clients", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.Reserve("A1", testActor)
+		inventory.Buy("A1", testActor)
+
+		err := inventory.CompareAndSet("A1", SOLD, FREE, false, testActor)
+		if err == nil {
+			t.Fatalf("Expected error refunding without privileges")
+		}
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, SOLD)
+	})
+
+	t.Run("Exactly one of many concurrent clients wins", func(t *testing.T) {
+		inventory := NewInventory()
+		numClients := 50
+		var winners int64
+
+		wg := &sync.WaitGroup{}
+		wg.Add(numClients)
+		for c := 0; c < numClients; c++ {
+			go func() {
+				defer wg.Done()
+				if inventory.CompareAndSet("A1", FREE, RESERVED, false, testActor) == nil {
+					atomic.AddInt64(&winners, 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if winners != 1 {
+			t.Errorf("Expected exactly one winner, got [%d]", winners)
+		}
+	})
+
+	t.Run("Seats cycling through refunds never skip a status", func(t *testing.T) {
+		inventory := NewInventory()
+		cycle := [][]SeatStatus{{FREE, RESERVED}, {RESERVED, SOLD}, {SOLD, FREE}}
+		numClients := 20
+		attemptsPerClient := 200
+		var applied int64
+
+		wg := &sync.WaitGroup{}
+		wg.Add(numClients)
+		for c := 0; c < numClients; c++ {
+			go func(c int) {
+				defer wg.Done()
+				for a := 0; a < attemptsPerClient; a++ {
+					transition := cycle[(c+a)%len(cycle)]
+					if inventory.CompareAndSet("A1", transition[0], transition[1], true, testActor) == nil {
+						atomic.AddInt64(&applied, 1)
+					}
+				}
+			}(c)
+		}
+		wg.Wait()
+
+		history := historyOf(t, inventory, "A1")
+		if int64(len(history)) != applied {
+			t.Fatalf("Expected [%d] transitions in history, got [%d]", applied, len(history))
+		}
+
+		status := FREE
+		for _, entry := range history {
+			if entry.From != status {
+				t.Fatalf("Seat went from [%s] while it was [%s]: %+v", entry.From, status, entry)
+			}
+			status = entry.To
+		}
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, status)
+	})
+}
@@ -0,0 +1,22 @@
+package inventory
+
+import "fmt"
+
+// KindError explains what went wrong in its own words, while errors.Is matches it against its kind
+type KindError struct {
+	Kind   error
+	Reason string
+}
+
+func (e *KindError) Error() string {
+	return e.Reason
+}
+
+func (e *KindError) Unwrap() error {
+	return e.Kind
+}
+
+// NewKindError explains an error of the given kind with a formatted reason
+func NewKindError(kind error, format string, v ...interface{}) error {
+	return &KindError{kind, fmt.Sprintf(format, v...)}
+}
@@ -0,0 +1,149 @@
+package inventory
+
+import (
+	"errors"
+	"strings"
+)
+
+// Event has a seat space of its own, seat A1 of event EVT123 is named EVT123/A1. Seats named without an
+// event, like A1, share a space that is always open, like before events existed.
+type Event string
+
+// EVENT_SEPARATOR goes between an event and the name of one of its seats
+const EVENT_SEPARATOR = "/"
+
+// Events are OPEN when created, CLOSED once sales stop and ARCHIVED once nothing can change anymore. Their
+// status is kept in the store like a seat's, under the event's record, so it is saved, replicated and
+// handed over with the seats.
+const (
+	OPEN     SeatStatus = "OPEN"
+	CLOSED   SeatStatus = "CLOSED"
+	ARCHIVED SeatStatus = "ARCHIVED"
+)
+
+var eventStatuses = []SeatStatus{OPEN, CLOSED, ARCHIVED}
+
+const (
+	CREATE_EVENT  Command = "CREATE_EVENT"
+	CLOSE_EVENT   Command = "CLOSE_EVENT"
+	ARCHIVE_EVENT Command = "ARCHIVE_EVENT"
+)
+
+// eventTransitionTable is every move an event's record can make, only admins make them and archiving is final
+var eventTransitionTable = []seatTransition{
+	{CREATE_EVENT, FREE, OPEN, true},
+	{CLOSE_EVENT, OPEN, CLOSED, true},
+	{ARCHIVE_EVENT, OPEN, ARCHIVED, true},
+	{ARCHIVE_EVENT, CLOSED, ARCHIVED, true},
+}
+
+var (
+	ErrUnknownEvent  = errors.New("event does not exist")
+	ErrEventExists   = errors.New("event already exists")
+	ErrEventClosed   = errors.New("event is closed")
+	ErrEventArchived = errors.New("event is archived")
+)
+
+// EventOf tells which event the seat belongs to, if any
+func EventOf(seat Seat) (Event, bool) {
+	split := strings.SplitN(string(seat), EVENT_SEPARATOR, 2)
+	if len(split) == 1 {
+		return "", false
+	}
+	return Event(split[0]), true
+}
+
+// EventRecord is the name the event's status is kept under, no seat has it
+func EventRecord(event Event) Seat {
+	return Seat(string(event) + EVENT_SEPARATOR)
+}
+
+// IsEventRecord tells whether the name is an event's record instead of a seat
+func IsEventRecord(seat Seat) bool {
+	return strings.HasSuffix(string(seat), EVENT_SEPARATOR)
+}
+
+// IsStoredStatus tells whether the status is one stores can keep for the seat, event records have event statuses
+func IsStoredStatus(seat Seat, status string) bool {
+	if !IsEventRecord(seat) {
+		return IsStatus(status)
+	}
+	if status == string(FREE) {
+		return true
+	}
+	for _, s := range eventStatuses {
+		if string(s) == status {
+			return true
+		}
+	}
+	return false
+}
+
+func isEventCommand(command Command) bool {
+	return command == CREATE_EVENT || command == CLOSE_EVENT || command == ARCHIVE_EVENT
+}
+
+// eventRefusal tells why the event couldn't make the move, given the status it is in
+func eventRefusal(move Move, current SeatStatus) error {
+	event, _ := EventOf(move.Seat)
+	switch {
+	case current == FREE:
+		return NewKindError(ErrUnknownEvent, "event [%s] does not exist", event)
+	case move.Command == CREATE_EVENT:
+		return NewKindError(ErrEventExists, "event [%s] already exists, it is [%s]", event, current)
+	case current == ARCHIVED:
+		return NewKindError(ErrEventArchived, "event [%s] is archived", event)
+	default:
+		return NewKindError(ErrEventClosed, "event [%s] is already closed", event)
+	}
+}
+
+// checkEvent refuses moves of seats whose event doesn't exist or doesn't take the move anymore. Closed
+// events still take refunds, archived ones nothing. Callers hold the lock.
+func (i *Inventory) checkEvent(move Move) error {
+	event, inEvent := EventOf(move.Seat)
+	if !inEvent || isEventCommand(move.Command) {
+		return nil
+	}
+	status, err := i.store.Get(EventRecord(event))
+	if err != nil {
+		return err
+	}
+	switch {
+	case status == FREE:
+		return NewKindError(ErrUnknownEvent, "cannot [%s] seat [%s], event [%s] does not exist", move.Command, move.Seat, event)
+	case status == ARCHIVED:
+		return NewKindError(ErrEventArchived, "cannot [%s] seat [%s], event [%s] is archived", move.Command, move.Seat, event)
+	case status == CLOSED && move.To != FREE && move.To != QUARANTINED:
+		return NewKindError(ErrEventClosed, "cannot [%s] seat [%s], event [%s] is closed", move.Command, move.Seat, event)
+	}
+	return nil
+}
+
+// checkKnown refuses reads of seats of events that don't exist, callers hold the lock
+func (i *Inventory) checkKnown(seat Seat) error {
+	event, inEvent := EventOf(seat)
+	if !inEvent {
+		return nil
+	}
+	status, err := i.store.Get(EventRecord(event))
+	if err == nil && status == FREE {
+		err = NewKindError(ErrUnknownEvent, "seat [%s] is in event [%s], which does not exist", seat, event)
+	}
+	return err
+}
+
+// CreateEvent opens a seat space for the event
+func (i *Inventory) CreateEvent(event Event, actor Actor) error {
+	return i.move(Move{Command: CREATE_EVENT, Seat: EventRecord(event), To: OPEN, Privileged: true, Actor: actor})
+}
+
+// CloseEvent stops sales of the event's seats, they can still be refunded
+func (i *Inventory) CloseEvent(event Event, actor Actor) error {
+	return i.move(Move{Command: CLOSE_EVENT, Seat: EventRecord(event), To: CLOSED, Privileged: true, Actor: actor})
+}
+
+// ArchiveEvent freezes the event's seats as they are, they can still be queried
+func (i *Inventory) ArchiveEvent(event Event, actor Actor) error {
+	return i.move(Move{Command: ARCHIVE_EVENT, Seat: EventRecord(event), To: ARCHIVED, Privileged: true, Actor: actor})
+}
@@ -0,0 +1,105 @@
+package inventory
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	t.Run("Seats of events that dont exist cant be used", func(t *testing.T) {
+		inventory := NewInventory()
+
+		if err := inventory.Reserve("EVT1/A1", testActor); !errors.Is(err, ErrUnknownEvent) {
+			t.Errorf("Expected an unknown event error reserving, got %v", err)
+		}
+		if _, err := inventory.GetMany([]Seat{"A1", "EVT1/A1"}); !errors.Is(err, ErrUnknownEvent) {
+			t.Errorf("Expected an unknown event error querying, got %v", err)
+		}
+		if err := inventory.CloseEvent("EVT1", testActor); !errors.Is(err, ErrUnknownEvent) {
+			t.Errorf("Expected an unknown event error closing, got %v", err)
+		}
+	})
+
+	t.Run("Each event has a seat space of its own", func(t *testing.T) {
+		inventory := NewInventory()
+		for _, event := range []Event{"EVT1", "EVT2"} {
+			if err := inventory.CreateEvent(event, testActor); err != nil {
+				t.Fatalf("Unexpected error creating event [%s]: %v", event, err)
+			}
+		}
+		if err := inventory.CreateEvent("EVT1", testActor); !errors.Is(err, ErrEventExists) {
+			t.Errorf("Expected an event exists error, got %v", err)
+		}
+
+		inventory.Reserve("A1", testActor)
+		inventory.Reserve("EVT1/A1", testActor)
+		inventory.Buy("EVT1/A1", testActor)
+
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, RESERVED)
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"EVT1/A1"}, SOLD)
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"EVT2/A1"}, FREE)
+	})
+
+	t.Run("Closed events only take refunds", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.CreateEvent("EVT1", testActor)
+		inventory.Reserve("EVT1/A1", testActor)
+		inventory.Buy("EVT1/A1", testActor)
+		inventory.Reserve("EVT1/A2", testActor)
+		if err := inventory.CloseEvent("EVT1", testActor); err != nil {
+			t.Fatalf("Unexpected error closing event: %v", err)
+		}
+
+		if err := inventory.Reserve("EVT1/A3", testActor); !errors.Is(err, ErrEventClosed) {
+			t.Errorf("Expected an event closed error reserving, got %v", err)
+		}
+		if err := inventory.Buy("EVT1/A2", testActor); !errors.Is(err, ErrEventClosed) {
+			t.Errorf("Expected an event closed error buying, got %v", err)
+		}
+		if err := inventory.Refund("EVT1/A1", QUARANTINED, "event cancelled", testActor); err != nil {
+			t.Errorf("Unexpected error refunding a seat of a closed event: %v", err)
+		}
+		if err := inventory.CloseEvent("EVT1", testActor); !errors.Is(err, ErrEventClosed) {
+			t.Errorf("Expected an event closed error closing again, got %v", err)
+		}
+	})
+
+	t.Run("Archived events cant change but can still be read", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.CreateEvent("EVT1", testActor)
+		inventory.Reserve("EVT1/A1", testActor)
+		inventory.Buy("EVT1/A1", testActor)
+		if err := inventory.ArchiveEvent("EVT1", testActor); err != nil {
+			t.Fatalf("Unexpected error archiving event: %v", err)
+		}
+
+		if err := inventory.Refund("EVT1/A1", FREE, "too late", testActor); !errors.Is(err, ErrEventArchived) {
+			t.Errorf("Expected an event archived error refunding, got %v", err)
+		}
+		if err := inventory.CreateEvent("EVT1", testActor); !errors.Is(err, ErrEventExists) {
+			t.Errorf("Expected an event exists error creating it again, got %v", err)
+		}
+		if err := inventory.ArchiveEvent("EVT1", testActor); !errors.Is(err, ErrEventArchived) {
+			t.Errorf("Expected an event archived error archiving again, got %v", err)
+		}
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"EVT1/A1"}, SOLD)
+		if history := historyOf(t, inventory, "EVT1/A1"); len(history) != 2 {
+			t.Errorf("Expected the seat's history to be kept, got %+v", history)
+		}
+	})
+
+	t.Run("Events arent counted or listed as seats", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.CreateEvent("EVT1", testActor)
+		inventory.Reserve("EVT1/A1", testActor)
+
+		counts, _ := inventory.Counts()
+		if counts[RESERVED] != 1 || len(counts) != 1 {
+			t.Errorf("Expected one reserved seat, got %v", counts)
+		}
+		listings, _, _ := inventory.List("EVT1/", "", "", 10)
+		if len(listings) != 1 || listings[0].Seat != "EVT1/A1" {
+			t.Errorf("Expected only seat [EVT1/A1] to be listed, got %v", listings)
+		}
+	})
+}
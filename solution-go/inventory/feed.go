@@ -0,0 +1,142 @@
+package inventory
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+const (
+	FEED_RETENTION        = 10000
+	SUBSCRIPTION_BUFFER   = 1024
+	SUBSCRIBE_FROM_LATEST = -1
+)
+
+// ErrCannotResume is returned when the transitions after a sequence are not retained anymore
+var ErrCannotResume = errors.New("cannot resume from that sequence")
+
+// Transition is a seat moving between statuses, numbered in the order they happened
+type Transition struct {
+	Sequence int64
+	Seat     Seat
+	From     SeatStatus
+	To       SeatStatus
+}
+
+// Subscription receives the transitions of seats with a prefix
+type Subscription struct {
+	prefix  string
+	backlog []Transition
+	events  chan Transition
+	lagged  bool
+}
+
+// Backlog holds transitions that happened before subscribing, to be delivered before any of the events
+func (s *Subscription) Backlog() []Transition {
+	return s.backlog
+}
+
+// Events is closed when the subscription is cancelled or when the subscriber fell too far behind
+func (s *Subscription) Events() <-chan Transition {
+	return s.events
+}
+
+// Lagged tells if the subscription was cancelled because the subscriber was too slow, it can only be
+// trusted once Events is closed
+func (s *Subscription) Lagged() bool {
+	return s.lagged
+}
+
+func (s *Subscription) matches(t Transition) bool {
+	return strings.HasPrefix(string(t.Seat), s.prefix)
+}
+
+// Feed hands out every seat transition, numbered in the order they happened. The last FEED_RETENTION
+// transitions are kept so subscribers can resume after reconnecting.
+type Feed struct {
+	sequence    int64
+	retained    []Transition
+	subscribers map[*Subscription]bool
+	lock        sync.Mutex
+}
+
+// Publish numbers the transition and hands it to every matching subscriber
+func (f *Feed) Publish(seat Seat, from SeatStatus, to SeatStatus) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.sequence++
+	transition := Transition{f.sequence, seat, from, to}
+
+	if len(f.retained) == FEED_RETENTION {
+		f.retained = f.retained[1:]
+	}
+	f.retained = append(f.retained, transition)
+
+	for s := range f.subscribers {
+		if !s.matches(transition) {
+			continue
+		}
+
+		select {
+		case s.events <- transition:
+		default:
+			//Never block the inventory on a slow subscriber, it can resume from the last event it saw
+			s.lagged = true
+			f.cancel(s)
+		}
+	}
+}
+
+// Subscribe starts delivering transitions for seats with the prefix that happened after the sequence given,
+// or only new ones when after is SUBSCRIBE_FROM_LATEST. It also returns the latest sequence at that moment.
+func (f *Feed) Subscribe(prefix string, after int64) (*Subscription, int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if after == SUBSCRIBE_FROM_LATEST {
+		after = f.sequence
+	}
+
+	if after > f.sequence {
+		return nil, f.sequence, NewKindError(ErrCannotResume, "cannot resume after sequence [%d], latest is [%d]", after, f.sequence)
+	}
+
+	oldestRetained := f.sequence - int64(len(f.retained)) + 1
+	if after+1 < oldestRetained {
+		return nil, f.sequence, NewKindError(ErrCannotResume, "cannot resume after sequence [%d], oldest retained is [%d]", after, oldestRetained)
+	}
+
+	subscription := &Subscription{
+		prefix: prefix,
+		events: make(chan Transition, SUBSCRIPTION_BUFFER),
+	}
+	for _, t := range f.retained {
+		if t.Sequence > after && subscription.matches(t) {
+			subscription.backlog = append(subscription.backlog, t)
+		}
+	}
+
+	f.subscribers[subscription] = true
+	return subscription, f.sequence, nil
+}
+
+// Unsubscribe stops delivering transitions to the subscription
+func (f *Feed) Unsubscribe(s *Subscription) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.cancel(s)
+}
+
+func (f *Feed) cancel(s *Subscription) {
+	if f.subscribers[s] {
+		delete(f.subscribers, s)
+		close(s.events)
+	}
+}
+
+func NewFeed() *Feed {
+	return &Feed{
+		subscribers: map[*Subscription]bool{},
+	}
+}
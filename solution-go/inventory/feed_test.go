@@ -0,0 +1,141 @@
+package inventory
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func receive(t *testing.T, subscription *Subscription, howMany int) []Transition {
+	var received []Transition
+	for i := 0; i < howMany; i++ {
+		select {
+		case transition := <-subscription.Events():
+			received = append(received, transition)
+		default:
+			t.Fatalf("Expected [%d] transitions, got only %v", howMany, received)
+		}
+	}
+	return received
+}
+
+func TestFeed(t *testing.T) {
+	t.Run("Delivers transitions of matching seats in order", func(t *testing.T) {
+		feed := NewFeed()
+		subscription, latest, err := feed.Subscribe("A", SUBSCRIBE_FROM_LATEST)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if latest != 0 {
+			t.Errorf("Expected latest sequence to be 0, got [%d]", latest)
+		}
+
+		feed.Publish("A1", FREE, RESERVED)
+		feed.Publish("B1", FREE, RESERVED)
+		feed.Publish("A1", RESERVED, SOLD)
+
+		expected := []Transition{{1, "A1", FREE, RESERVED}, {3, "A1", RESERVED, SOLD}}
+		received := receive(t, subscription, 2)
+		if !reflect.DeepEqual(received, expected) {
+			t.Errorf("Expected transitions %v, got %v", expected, received)
+		}
+	})
+
+	t.Run("Resumes from a sequence number using the backlog", func(t *testing.T) {
+		feed := NewFeed()
+		feed.Publish("A1", FREE, RESERVED)
+		feed.Publish("A2", FREE, RESERVED)
+		feed.Publish("A1", RESERVED, SOLD)
+
+		subscription, latest, err := feed.Subscribe("", 1)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		expected := []Transition{{2, "A2", FREE, RESERVED}, {3, "A1", RESERVED, SOLD}}
+		if latest != 3 || !reflect.DeepEqual(subscription.Backlog(), expected) {
+			t.Errorf("Expected backlog %v up to [3], got %v up to [%d]", expected, subscription.Backlog(), latest)
+		}
+
+		feed.Publish("A2", RESERVED, SOLD)
+		received := receive(t, subscription, 1)
+		if received[0].Sequence != 4 {
+			t.Errorf("Expected live transitions to follow the backlog, got %v", received)
+		}
+	})
+
+	t.Run("Refuses to resume from sequences it doesnt have", func(t *testing.T) {
+		feed := NewFeed()
+		for i := 0; i < FEED_RETENTION+10; i++ {
+			feed.Publish("A1", FREE, RESERVED)
+		}
+
+		for _, after := range []int64{0, 9, FEED_RETENTION + 11} {
+			_, _, err := feed.Subscribe("", after)
+			if !errors.Is(err, ErrCannotResume) {
+				t.Errorf("Expected error resuming after [%d], got [%v]", after, err)
+			}
+		}
+
+		_, _, err := feed.Subscribe("", 10)
+		if err != nil {
+			t.Errorf("Expected resuming from the oldest retained transition to work, got %v", err)
+		}
+	})
+
+	t.Run("Cancels subscribers that fall behind", func(t *testing.T) {
+		feed := NewFeed()
+		subscription, _, err := feed.Subscribe("", SUBSCRIBE_FROM_LATEST)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		for i := 0; i < SUBSCRIPTION_BUFFER+1; i++ {
+			feed.Publish("A1", FREE, RESERVED)
+		}
+
+		receive(t, subscription, SUBSCRIPTION_BUFFER)
+		if _, open := <-subscription.Events(); open {
+			t.Fatalf("Expected subscription to be closed")
+		}
+		if !subscription.Lagged() {
+			t.Errorf("Expected subscription to be marked as lagged")
+		}
+	})
+
+	t.Run("Unsubscribing closes the subscription", func(t *testing.T) {
+		feed := NewFeed()
+		subscription, _, err := feed.Subscribe("", SUBSCRIBE_FROM_LATEST)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		feed.Unsubscribe(subscription)
+		feed.Unsubscribe(subscription)
+		feed.Publish("A1", FREE, RESERVED)
+
+		if _, open := <-subscription.Events(); open || subscription.Lagged() {
+			t.Errorf("Expected subscription to be closed without lagging")
+		}
+	})
+}
+
+func TestInventorySubscribe(t *testing.T) {
+	t.Run("Inventory publishes every transition", func(t *testing.T) {
+		inventory := NewInventory()
+		subscription, _, err := inventory.Subscribe("", SUBSCRIBE_FROM_LATEST)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		inventory.Reserve("A1", testActor)
+		inventory.Reserve("A1", testActor)
+		inventory.Buy("A1", testActor)
+
+		expected := []Transition{{1, "A1", FREE, RESERVED}, {2, "A1", RESERVED, SOLD}}
+		received := receive(t, subscription, 2)
+		if !reflect.DeepEqual(received, expected) {
+			t.Errorf("Expected transitions %v, got %v", expected, received)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileStore keeps seats in memory and appends every move to a file, one "<seat> <status>" line each, which
+// is read back when the store is opened. Moves are synced to disk before they are applied, so a crash can
+// only lose a move nobody was told about. A torn line left by a crash is dropped when opening.
+type FileStore struct {
+	memory *MapStore
+	file   *os.File
+	size   int64
+	lock   sync.Mutex
+}
+
+func (s *FileStore) Get(seat Seat) (SeatStatus, error) {
+	return s.memory.Get(seat)
+}
+
+func (s *FileStore) CompareAndSwap(seat Seat, old SeatStatus, status SeatStatus) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current, err := s.memory.Get(seat)
+	if err != nil || current != old {
+		return false, err
+	}
+
+	line := fmt.Sprintf("%s %s\n", seat, status)
+	_, err = s.file.WriteString(line)
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		//Cut off whatever made it to the file, the next move mustn't be appended to half a line
+		s.file.Truncate(s.size)
+		s.file.Seek(s.size, 0)
+		return false, fmt.Errorf("could not write seat [%s] to [%s]: %v", seat, s.file.Name(), err)
+	}
+	s.size += int64(len(line))
+
+	return s.memory.CompareAndSwap(seat, old, status)
+}
+
+func (s *FileStore) Range(from Seat, visit func(seat Seat, status SeatStatus) bool) error {
+	return s.memory.Range(from, visit)
+}
+
+func (s *FileStore) Close() error {
+	return s.file.Close()
+}
+
+// replayFile reads the moves in the file, returning the seats they left behind, how many moves there were
+// and how many bytes of the file hold whole lines
+func replayFile(path string) (*MapStore, int, int64, error) {
+	memory := NewMapStore()
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return memory, 0, 0, nil
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	moves := 0
+	var size int64
+	for {
+		end := bytes.IndexByte(content[size:], '\n')
+		if end < 0 {
+			break
+		}
+		line := string(content[size : size+int64(end)])
+		fields := strings.Split(line, " ")
+		if len(fields) != 2 || fields[0] == "" || !IsStoredStatus(Seat(fields[0]), fields[1]) {
+			return nil, 0, 0, fmt.Errorf("invalid line [%s] at byte [%d] of [%s]", line, size, path)
+		}
+
+		memory.set(Seat(fields[0]), SeatStatus(fields[1]))
+		moves++
+		size += int64(end) + 1
+	}
+	return memory, moves, size, nil
+}
+
+// compactFile replaces the file with one line per seat, writing a new file first so a crash leaves either
+// the old moves or the new ones
+func compactFile(path string, memory *MapStore) (int64, error) {
+	var content bytes.Buffer
+	memory.Range("", func(seat Seat, status SeatStatus) bool {
+		fmt.Fprintf(&content, "%s %s\n", seat, status)
+		return true
+	})
+
+	compacted := path + ".compacted"
+	file, err := os.OpenFile(compacted, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	_, err = file.Write(content.Bytes())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(compacted, path)
+	}
+	if err != nil {
+		os.Remove(compacted)
+		return 0, err
+	}
+	return int64(content.Len()), syncDir(filepath.Dir(path))
+}
+
+// syncDir makes a rename in the directory survive a crash
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// OpenFileStore reads the seats back from the file at path, creating it if it doesn't exist. When most of
+// the moves in it were overwritten by later ones the file is compacted first.
+func OpenFileStore(path string) (*FileStore, error) {
+	memory, moves, size, err := replayFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read seats from [%s]: %v", path, err)
+	}
+
+	if moves > 2*len(memory.seats) {
+		size, err = compactFile(path, memory)
+		if err != nil {
+			return nil, fmt.Errorf("could not compact [%s]: %v", path, err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	//Drop a torn line a crash may have left at the end, and write after the last whole one
+	err = file.Truncate(size)
+	if err == nil {
+		_, err = file.Seek(size, 0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not open [%s] for writing: %v", path, err)
+	}
+
+	return &FileStore{
+		memory: memory,
+		file:   file,
+		size:   size,
+	}, nil
+}
@@ -0,0 +1,122 @@
+package inventory
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrKeyReused = errors.New("idempotency key was already used for another command")
+
+type idempotentResult struct {
+	command   Command
+	seat      Seat
+	err       error
+	expiresAt time.Time
+}
+
+// runningCommand is a command being run for a key, retries with the key wait until done is closed
+type runningCommand struct {
+	command Command
+	seat    Seat
+	done    chan struct{}
+}
+
+type idempotencyKeyExpiry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// IdempotencyCache remembers what was answered to each idempotency key, so that a client retrying a
+// command it didn't get an answer for gets the original answer instead of having it applied twice.
+// Keys are forgotten after ttl, or sooner if more than capacity keys are remembered.
+type IdempotencyCache struct {
+	results  map[string]idempotentResult
+	running  map[string]*runningCommand
+	expiries []idempotencyKeyExpiry
+	ttl      time.Duration
+	capacity int
+	now      func() time.Time
+	lock     sync.Mutex
+}
+
+// Do runs execute only if the key wasn't seen before, otherwise it returns what execute returned the first
+// time. Reusing a key for a different command or seat is an error. Commands that were never applied
+// aren't remembered, so they can be retried with the same key. Commands run without holding up the ones
+// for other keys, retries of a command still running wait for it.
+func (c *IdempotencyCache) Do(key string, command Command, seat Seat, execute func() error) error {
+	running, seen, err := c.start(key, command, seat)
+	if seen {
+		return err
+	}
+
+	err = execute()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.running, key)
+	close(running.done)
+	if errors.Is(err, ErrNotApplied) {
+		return err
+	}
+	expiresAt := c.now().Add(c.ttl)
+	c.results[key] = idempotentResult{command, seat, err, expiresAt}
+	c.expiries = append(c.expiries, idempotencyKeyExpiry{key, expiresAt})
+	return err
+}
+
+// start marks the key as running the command, unless it was seen before and there is a result to return
+func (c *IdempotencyCache) start(key string, command Command, seat Seat) (*runningCommand, bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for {
+		c.evict(c.now())
+
+		if previous, seen := c.results[key]; seen {
+			if previous.command != command || previous.seat != seat {
+				return nil, true, NewKindError(ErrKeyReused, "idempotency key [%s] was already used for [%s: %s]", key, previous.command, previous.seat)
+			}
+			return nil, true, previous.err
+		}
+
+		running, isRunning := c.running[key]
+		if !isRunning {
+			break
+		}
+		if running.command != command || running.seat != seat {
+			return nil, true, NewKindError(ErrKeyReused, "idempotency key [%s] is being used for [%s: %s]", key, running.command, running.seat)
+		}
+		//The result is there once it finished, unless it was never applied and this one runs it
+		c.lock.Unlock()
+		<-running.done
+		c.lock.Lock()
+	}
+
+	running := &runningCommand{command, seat, make(chan struct{})}
+	c.running[key] = running
+	return running, false, nil
+}
+
+func (c *IdempotencyCache) evict(now time.Time) {
+	expired := 0
+	for _, e := range c.expiries {
+		if !now.After(e.expiresAt) && len(c.expiries)-expired < c.capacity {
+			break
+		}
+		delete(c.results, e.key)
+		expired++
+	}
+	c.expiries = c.expiries[expired:]
+}
+
+// NewIdempotencyCache remembers results for ttl, and at most capacity keys
+func NewIdempotencyCache(ttl time.Duration, capacity int) *IdempotencyCache {
+	return &IdempotencyCache{
+		results:  map[string]idempotentResult{},
+		running:  map[string]*runningCommand{},
+		ttl:      ttl,
+		capacity: capacity,
+		now:      time.Now,
+	}
+}
@@ -0,0 +1,139 @@
+package inventory
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var succeed = func() error { return nil }
+
+func TestIdempotencyCache(t *testing.T) {
+	t.Run("Replays the original result for a key", func(t *testing.T) {
+		cache := NewIdempotencyCache(time.Minute, 10)
+		executions := 0
+		execute := func() error {
+			executions++
+			if executions == 1 {
+				return nil
+			}
+			return errors.New("applied twice")
+		}
+
+		for i := 0; i < 3; i++ {
+			err := cache.Do("k1", BUY, "A1", execute)
+			if err != nil {
+				t.Errorf("Expected retry #%d to get the original result, got [%v]", i, err)
+			}
+		}
+
+		if executions != 1 {
+			t.Errorf("Expected command to run once, ran [%d] times", executions)
+		}
+	})
+
+	t.Run("Replays failures too", func(t *testing.T) {
+		cache := NewIdempotencyCache(time.Minute, 10)
+		failure := errors.New("seat is sold")
+		cache.Do("k1", BUY, "A1", func() error { return failure })
+
+		if err := cache.Do("k1", BUY, "A1", succeed); err != failure {
+			t.Errorf("Expected original failure to be replayed, got [%v]", err)
+		}
+	})
+
+	t.Run("Lets commands that were never applied be retried", func(t *testing.T) {
+		cache := NewIdempotencyCache(time.Minute, 10)
+		cache.Do("k1", BUY, "A1", func() error { return NewKindError(ErrNotApplied, "no leader") })
+
+		if err := cache.Do("k1", BUY, "A1", succeed); err != nil {
+			t.Errorf("Expected the retry to run, got [%v]", err)
+		}
+	})
+
+	t.Run("Runs commands for other keys while one is running", func(t *testing.T) {
+		cache := NewIdempotencyCache(time.Minute, 10)
+		started, release := make(chan struct{}), make(chan struct{})
+		go cache.Do("k1", BUY, "A1", func() error {
+			close(started)
+			<-release
+			return nil
+		})
+		<-started
+		defer close(release)
+
+		finished := make(chan error, 1)
+		go func() { finished <- cache.Do("k2", BUY, "A2", succeed) }()
+		select {
+		case err := <-finished:
+			if err != nil {
+				t.Errorf("Unexpected error for another key: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected another key not to wait for the running command")
+		}
+	})
+
+	t.Run("Retries of a running command wait for its result", func(t *testing.T) {
+		cache := NewIdempotencyCache(time.Minute, 10)
+		failure := errors.New("seat is sold")
+		started, release := make(chan struct{}), make(chan struct{})
+		go cache.Do("k1", BUY, "A1", func() error {
+			close(started)
+			<-release
+			return failure
+		})
+		<-started
+
+		retried := make(chan error, 1)
+		go func() { retried <- cache.Do("k1", BUY, "A1", func() error { return errors.New("applied twice") }) }()
+		if err := cache.Do("k1", BUY, "A2", succeed); !errors.Is(err, ErrKeyReused) {
+			t.Errorf("Expected error reusing a running key for another seat, got [%v]", err)
+		}
+		close(release)
+		if err := <-retried; err != failure {
+			t.Errorf("Expected the retry to get the original result, got [%v]", err)
+		}
+	})
+
+	t.Run("Rejects keys reused for something else", func(t *testing.T) {
+		cache := NewIdempotencyCache(time.Minute, 10)
+		cache.Do("k1", RESERVE, "A1", succeed)
+
+		if err := cache.Do("k1", RESERVE, "A2", succeed); !errors.Is(err, ErrKeyReused) {
+			t.Errorf("Expected error reusing key for another seat, got [%v]", err)
+		}
+		if err := cache.Do("k1", BUY, "A1", succeed); !errors.Is(err, ErrKeyReused) {
+			t.Errorf("Expected error reusing key for another command, got [%v]", err)
+		}
+	})
+
+	t.Run("Forgets keys once they expire", func(t *testing.T) {
+		now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+		cache := NewIdempotencyCache(time.Minute, 10)
+		cache.now = func() time.Time { return now }
+
+		cache.Do("k1", RESERVE, "A1", succeed)
+		now = now.Add(2 * time.Minute)
+
+		ranAgain := false
+		cache.Do("k1", RESERVE, "A1", func() error { ranAgain = true; return nil })
+		if !ranAgain {
+			t.Errorf("Expected expired key to run the command again")
+		}
+	})
+
+	t.Run("Forgets oldest keys when full", func(t *testing.T) {
+		cache := NewIdempotencyCache(time.Minute, 2)
+		cache.Do("k1", RESERVE, "A1", succeed)
+		cache.Do("k2", RESERVE, "A2", succeed)
+		cache.Do("k3", RESERVE, "A3", succeed)
+
+		if len(cache.results) != 2 {
+			t.Fatalf("Expected only 2 keys to be remembered, got %v", cache.results)
+		}
+		if _, remembered := cache.results["k1"]; remembered {
+			t.Errorf("Expected oldest key to be forgotten")
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package inventory
+
+import "math/rand"
+
+const maxIndexLevel = 32
+
+type seatIndexNode struct {
+	seat Seat
+	next []*seatIndexNode
+}
+
+// seatIndex keeps seats sorted so they can be listed by prefix and resumed from a cursor, it is a skip list
+// because seats are only ever added and inserting into a sorted slice would be linear
+type seatIndex struct {
+	head   *seatIndexNode
+	level  int
+	random *rand.Rand
+}
+
+func (x *seatIndex) randomLevel() int {
+	level := 1
+	for level < maxIndexLevel && x.random.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
+
+func (x *seatIndex) Insert(seat Seat) {
+	update := make([]*seatIndexNode, maxIndexLevel)
+	node := x.head
+	for l := x.level - 1; l >= 0; l-- {
+		for node.next[l] != nil && node.next[l].seat < seat {
+			node = node.next[l]
+		}
+		update[l] = node
+	}
+
+	if next := node.next[0]; next != nil && next.seat == seat {
+		return
+	}
+
+	level := x.randomLevel()
+	for l := x.level; l < level; l++ {
+		update[l] = x.head
+	}
+	if level > x.level {
+		x.level = level
+	}
+
+	inserted := &seatIndexNode{seat: seat, next: make([]*seatIndexNode, level)}
+	for l := 0; l < level; l++ {
+		inserted.next[l] = update[l].next[l]
+		update[l].next[l] = inserted
+	}
+}
+
+// Seek returns the first node whose seat is not smaller than from, or nil if there is none
+func (x *seatIndex) Seek(from Seat) *seatIndexNode {
+	node := x.head
+	for l := x.level - 1; l >= 0; l-- {
+		for node.next[l] != nil && node.next[l].seat < from {
+			node = node.next[l]
+		}
+	}
+	return node.next[0]
+}
+
+func newSeatIndex() *seatIndex {
+	return &seatIndex{
+		head:   &seatIndexNode{next: make([]*seatIndexNode, maxIndexLevel)},
+		level:  1,
+		random: rand.New(rand.NewSource(1)),
+	}
+}
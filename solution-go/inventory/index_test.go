@@ -0,0 +1,51 @@
+package inventory
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSeatIndex(t *testing.T) {
+	t.Run("Keeps seats sorted and without duplicates", func(t *testing.T) {
+		var expected []string
+		index := newSeatIndex()
+		for _, i := range rand.Perm(1000) {
+			seat := fmt.Sprintf("S%04d", i)
+			index.Insert(Seat(seat))
+			index.Insert(Seat(seat))
+			expected = append(expected, seat)
+		}
+		sort.Strings(expected)
+
+		var actual []string
+		for node := index.Seek(""); node != nil; node = node.next[0] {
+			actual = append(actual, string(node.seat))
+		}
+
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("Expected index to iterate over %d sorted seats, got %d: %v", len(expected), len(actual), actual)
+		}
+	})
+
+	t.Run("Seeks to the first seat not smaller than the one given", func(t *testing.T) {
+		index := newSeatIndex()
+		for _, seat := range []Seat{"B2", "A1", "C3"} {
+			index.Insert(seat)
+		}
+
+		expectations := map[Seat]Seat{"": "A1", "A1": "A1", "A2": "B2", "B": "B2", "C3": "C3"}
+		for from, expected := range expectations {
+			node := index.Seek(from)
+			if node == nil || node.seat != expected {
+				t.Errorf("Expected seeking [%s] to find [%s], got %+v", from, expected, node)
+			}
+		}
+
+		if node := index.Seek("D"); node != nil {
+			t.Errorf("Expected seeking past the last seat to find nothing, got [%s]", node.seat)
+		}
+	})
+}
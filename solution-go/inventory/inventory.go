@@ -0,0 +1,467 @@
+// Package inventory keeps the status of every seat and moves seats between statuses following the
+// transition table, recording who moved them and telling subscribers as they move.
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrReadOnly is returned when moving seats of an inventory that only follows another one
+var ErrReadOnly = errors.New("inventory is read only")
+
+// SeatListing is a seat and its status, as returned by List
+type SeatListing struct {
+	Seat   Seat
+	Status SeatStatus
+}
+
+// Inventory knows the status of every seat, seats nobody touched are FREE. It is safe to use from many goroutines.
+type Inventory struct {
+	store SeatStore
+	feed  *Feed
+	audit *AuditTrail
+	// readOnly inventories only move seats when told to replicate what another inventory did
+	readOnly bool
+	moveLog  MoveLog
+	lock     sync.Mutex
+}
+
+// Reserve moves a FREE seat to RESERVED
+func (i *Inventory) Reserve(seat Seat, actor Actor) error {
+	return i.move(Move{Command: RESERVE, Seat: seat, To: RESERVED, Actor: actor})
+}
+
+// Buy moves a RESERVED seat to SOLD
+func (i *Inventory) Buy(seat Seat, actor Actor) error {
+	return i.move(Move{Command: BUY, Seat: seat, To: SOLD, Actor: actor})
+}
+
+// CompareAndSet moves the seat to a new status only if it currently is in the one expected
+func (i *Inventory) CompareAndSet(seat Seat, from SeatStatus, to SeatStatus, privileged bool, actor Actor) error {
+	return i.move(Move{Command: CAS, Seat: seat, From: from, To: to, Privileged: privileged, Actor: actor})
+}
+
+// Refund takes a SOLD seat back, either straight to FREE or to QUARANTINED, which is also how quarantined
+// seats are released. Callers must make sure the actor is allowed to refund, the reason goes to the history
+// and must fit in it with the seat, see protocol.MAX_REASON_SIZE.
+func (i *Inventory) Refund(seat Seat, to SeatStatus, reason string, actor Actor) error {
+	return i.move(Move{Command: REFUND, Seat: seat, To: to, Privileged: true, Reason: reason, Actor: actor})
+}
+
+// move applies the move right away, or once the move log agreed on it if there is one
+func (i *Inventory) move(move Move) error {
+	i.lock.Lock()
+	moveLog := i.moveLog
+	i.lock.Unlock()
+
+	if moveLog != nil {
+		return moveLog.Propose(move)
+	}
+	return i.Apply(move)
+}
+
+// Apply makes a move following the transition table, without going through the move log. Moves that say
+// which status they expect the seat to be in are refused if it is in another, the others move the seat
+// from wherever it is.
+func (i *Inventory) Apply(move Move) error {
+	i.lock.Lock()
+	err := i.apply(move)
+	synced := i.synced()
+	i.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return synced()
+}
+
+func (i *Inventory) apply(move Move) error {
+	if i.readOnly {
+		return i.refuseReadOnly(move.Command, move.Seat)
+	}
+	if err := i.checkEvent(move); err != nil {
+		return err
+	}
+	currentStatus, err := i.store.Get(move.Seat)
+	if err != nil {
+		return err
+	}
+
+	refuse := func(kind error) error {
+		if isEventCommand(move.Command) {
+			return eventRefusal(move, currentStatus)
+		}
+		return &TransitionError{kind, move.Command, move.Seat, currentStatus, move.To}
+	}
+
+	expected := move.From
+	if expected == "" {
+		expected = currentStatus
+	}
+	rule, found := findTransition(move.Command, expected, move.To)
+	if !found {
+		if move.From == "" && leadsTo(move.Command, move.To) {
+			return refuse(ErrWrongStatus)
+		}
+		return refuse(ErrForbiddenTransition)
+	}
+	if rule.privileged && !move.Privileged {
+		return refuse(ErrNotPrivileged)
+	}
+	if currentStatus != expected {
+		return refuse(ErrWrongStatus)
+	}
+
+	return i.set(move.Seat, currentStatus, move.To, move.Actor, move.Reason)
+}
+
+// SetMoveLog makes clients' moves go through the log, see MoveLog
+func (i *Inventory) SetMoveLog(moveLog MoveLog) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.moveLog = moveLog
+}
+
+// SetReadOnly makes clients unable to move seats, only Replicate and Restore can
+func (i *Inventory) SetReadOnly(readOnly bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.readOnly = readOnly
+}
+
+// CheckWritable tells ahead of time whether the command would be refused because the inventory is read only
+func (i *Inventory) CheckWritable(command Command, seat Seat) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.readOnly {
+		return i.refuseReadOnly(command, seat)
+	}
+	return nil
+}
+
+func (i *Inventory) refuseReadOnly(command Command, seat Seat) error {
+	return NewKindError(ErrReadOnly, "cannot [%s] seat [%s], the inventory is read only", command, seat)
+}
+
+// Replicate moves the seat to the status another inventory moved it to, wherever it is now
+func (i *Inventory) Replicate(seat Seat, to SeatStatus, actor Actor) error {
+	i.lock.Lock()
+	current, err := i.store.Get(seat)
+	if err == nil && current != to {
+		err = i.set(seat, current, to, actor, "")
+	}
+	synced := i.synced()
+	i.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return synced()
+}
+
+// Pick returns the statuses of the seats picked, leaving them as they are. Seats FREE aren't returned.
+func (i *Inventory) Pick(picked func(Seat) bool) ([]SeatListing, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.pick(picked)
+}
+
+func (i *Inventory) pick(picked func(Seat) bool) ([]SeatListing, error) {
+	var listings []SeatListing
+	err := i.store.Range("", func(seat Seat, status SeatStatus) bool {
+		if status != FREE && picked(seat) {
+			listings = append(listings, SeatListing{seat, status})
+		}
+		return true
+	})
+	return listings, err
+}
+
+// Release moves the seats picked back to FREE and returns the statuses they were in, so another inventory
+// can take them over. Seats already FREE aren't returned.
+func (i *Inventory) Release(picked func(Seat) bool, actor Actor) ([]SeatListing, error) {
+	i.lock.Lock()
+	released, err := i.release(picked, actor)
+	synced := i.synced()
+	i.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return released, synced()
+}
+
+func (i *Inventory) release(picked func(Seat) bool, actor Actor) ([]SeatListing, error) {
+	released, err := i.pick(picked)
+	if err != nil {
+		return nil, err
+	}
+	for _, listing := range released {
+		if err := i.set(listing.Seat, listing.Status, FREE, actor, "released to another inventory"); err != nil {
+			return nil, err
+		}
+	}
+	return released, nil
+}
+
+// Restore makes the inventory hold exactly the seats given, moving seats it has that aren't listed back to
+// FREE. Readers can't look at the seats halfway through.
+func (i *Inventory) Restore(seats []SeatListing, actor Actor) error {
+	i.lock.Lock()
+	err := i.restore(seats, actor)
+	synced := i.synced()
+	i.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return synced()
+}
+
+func (i *Inventory) restore(seats []SeatListing, actor Actor) error {
+	wanted := make(map[Seat]SeatStatus, len(seats))
+	for _, listing := range seats {
+		wanted[listing.Seat] = listing.Status
+	}
+	var moves []SeatListing
+	var froms []SeatStatus
+	err := i.store.Range("", func(seat Seat, status SeatStatus) bool {
+		if _, listed := wanted[seat]; !listed && status != FREE {
+			moves = append(moves, SeatListing{seat, FREE})
+			froms = append(froms, status)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, listing := range seats {
+		current, err := i.store.Get(listing.Seat)
+		if err != nil {
+			return err
+		}
+		if current != listing.Status {
+			moves = append(moves, listing)
+			froms = append(froms, current)
+		}
+	}
+
+	for n, move := range moves {
+		if err := i.set(move.Seat, froms[n], move.Status, actor, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Seats lists every seat the inventory knows about, in order
+func (i *Inventory) Seats() ([]SeatListing, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.seats()
+}
+
+func (i *Inventory) seats() ([]SeatListing, error) {
+	var seats []SeatListing
+	err := i.store.Range("", func(seat Seat, status SeatStatus) bool {
+		seats = append(seats, SeatListing{seat, status})
+		return true
+	})
+	return seats, err
+}
+
+// Snapshot lists every seat the inventory knows about and subscribes to every transition after that
+// moment, so that nothing is missed or seen twice. It also returns the sequence of the last transition.
+func (i *Inventory) Snapshot() ([]SeatListing, *Subscription, int64, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	seats, err := i.seats()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	//Seats only move holding the lock, so no transition can sneak in between listing and subscribing
+	subscription, latest, err := i.feed.Subscribe("", SUBSCRIBE_FROM_LATEST)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return seats, subscription, latest, nil
+}
+
+// Counts is how many seats are in each status, seats that were never moved aren't counted
+func (i *Inventory) Counts() (map[SeatStatus]int, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	counts := map[SeatStatus]int{}
+	err := i.store.Range("", func(seat Seat, status SeatStatus) bool {
+		if !IsEventRecord(seat) {
+			counts[status]++
+		}
+		return true
+	})
+	return counts, err
+}
+
+// Get is the status of the seat, or of the event if given its record
+func (i *Inventory) Get(seat Seat) (SeatStatus, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if err := i.checkKnown(seat); err != nil {
+		return "", err
+	}
+	return i.store.Get(seat)
+}
+
+// GetMany returns the status of each seat in the same order, all read at the same point in time
+func (i *Inventory) GetMany(seats []Seat) ([]SeatStatus, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	statuses := make([]SeatStatus, len(seats))
+	for n, seat := range seats {
+		if err := i.checkKnown(seat); err != nil {
+			return nil, err
+		}
+		status, err := i.store.Get(seat)
+		if err != nil {
+			return nil, err
+		}
+		statuses[n] = status
+	}
+	return statuses, nil
+}
+
+// List pages through seats the inventory knows about in order, starting right after the cursor. Seats
+// that were never reserved are FREE but unknown, so they are never listed. An empty status matches all
+// of them. The returned cursor is empty when there are no more matching seats.
+func (i *Inventory) List(prefix string, status SeatStatus, cursor Seat, limit int) ([]SeatListing, Seat, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	from := Seat(prefix)
+	if cursor != "" && cursor >= from {
+		from = cursor + "\x00"
+	}
+
+	var listings []SeatListing
+	var next Seat
+	err := i.store.Range(from, func(seat Seat, seatStatus SeatStatus) bool {
+		if !strings.HasPrefix(string(seat), prefix) {
+			return false
+		}
+		if (status != "" && seatStatus != status) || IsEventRecord(seat) {
+			return true
+		}
+
+		if len(listings) == limit {
+			next = listings[len(listings)-1].Seat
+			return false
+		}
+		listings = append(listings, SeatListing{seat, seatStatus})
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return listings, next, nil
+}
+
+// Subscribe follows transitions of seats with the prefix, see Feed.Subscribe
+func (i *Inventory) Subscribe(prefix string, after int64) (*Subscription, int64, error) {
+	return i.feed.Subscribe(prefix, after)
+}
+
+// Unsubscribe stops delivering transitions to the subscription
+func (i *Inventory) Unsubscribe(s *Subscription) {
+	i.feed.Unsubscribe(s)
+}
+
+// History is every transition of the seat, oldest first
+func (i *Inventory) History(seat Seat) ([]AuditEntry, error) {
+	i.lock.Lock()
+	err := i.checkKnown(seat)
+	i.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return i.audit.History(seat)
+}
+
+// compareAndSwap leaves syncing to synced if the store can, so moves made while a sync runs share the next
+func (i *Inventory) compareAndSwap(seat Seat, old SeatStatus, status SeatStatus) (bool, error) {
+	if store, ok := i.store.(groupCommitStore); ok {
+		return store.compareAndSwapUnsynced(seat, old, status)
+	}
+	return i.store.CompareAndSwap(seat, old, status)
+}
+
+// synced returns a function waiting until the moves made so far and their history are durable, which is
+// called once the lock is let go of so moves made meanwhile can share syncs. Readers can see moves before
+// then, but whoever made them only hears they were once they are durable. Callers hold the lock.
+func (i *Inventory) synced() func() error {
+	var waits []func() error
+	if store, ok := i.store.(groupSynced); ok {
+		waits = append(waits, store.synced())
+	}
+	waits = append(waits, i.audit.synced())
+	return func() error {
+		for _, wait := range waits {
+			if err := wait(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// set applies the transition and then audits it, a seat can't change unless we know who changed it, so a
+// transition the audit refuses is undone
+func (i *Inventory) set(seat Seat, from SeatStatus, status SeatStatus, actor Actor, reason string) error {
+	//Only the inventory moves seats in its store, holding the lock, so the swap failing means the store is shared
+	swapped, err := i.compareAndSwap(seat, from, status)
+	if err != nil {
+		return err
+	}
+	if !swapped {
+		return fmt.Errorf("seat [%s] was moved from [%s] by something else sharing the store", seat, from)
+	}
+
+	err = i.audit.Record(AuditEntry{
+		Time:          time.Now(),
+		Seat:          seat,
+		From:          from,
+		To:            status,
+		ConnectionID:  actor.ConnectionID,
+		RemoteAddress: actor.RemoteAddress,
+		Reason:        reason,
+	})
+	if err != nil {
+		if _, undoErr := i.compareAndSwap(seat, status, from); undoErr != nil {
+			return fmt.Errorf("could not audit seat [%s] moving to [%s] (%v), nor move it back: %v", seat, status, err, undoErr)
+		}
+		return err
+	}
+	i.feed.Publish(seat, from, status)
+	return nil
+}
+
+// NewInventory keeps seats and their history only in memory
+func NewInventory() *Inventory {
+	return NewAuditedInventory(NewAuditTrail(nil))
+}
+
+// NewAuditedInventory records every transition in the audit trail
+func NewAuditedInventory(audit *AuditTrail) *Inventory {
+	return NewStoredInventory(NewMapStore(), audit)
+}
+
+// NewStoredInventory keeps seats in the store, which nothing else should move seats in, and records every
+// transition in the audit trail
+func NewStoredInventory(store SeatStore, audit *AuditTrail) *Inventory {
+	return &Inventory{
+		store: store,
+		feed:  NewFeed(),
+		audit: audit,
+	}
+}
@@ -0,0 +1,340 @@
+package inventory
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testActor = Actor{ConnectionID: 1, RemoteAddress: "127.0.0.1:5000"}
+
+func TestNewInventory(t *testing.T) {
+	t.Run("Seats are free when no other action was performed", func(t *testing.T) {
+		seats := []Seat{"A1", "b4", "qe33"}
+		inventory := NewInventory()
+
+		expectAllSeatsToHaveStatus(t, inventory, seats, FREE)
+	})
+
+	t.Run("Free seats can be reserved", func(t *testing.T) {
+		seatsToReserve := []Seat{"A1", "b4", "A321"}
+		seatsToRemainFree := []Seat{"ABC", "ORCH1", "Z2"}
+		inventory := NewInventory()
+
+		for _, seat := range seatsToReserve {
+			err := inventory.Reserve(seat, testActor)
+			if err != nil {
+				t.Errorf("Unexpected error when reserving seat [%s]: %v", seat, err)
+			}
+		}
+
+		expectAllSeatsToHaveStatus(t, inventory, seatsToRemainFree, FREE)
+		expectAllSeatsToHaveStatus(t, inventory, seatsToReserve, RESERVED)
+	})
+
+	t.Run("Reserved seats can be bought", func(t *testing.T) {
+		seatsToReserveOnly := []Seat{"A1", "A4", "A321"}
+		seatsToRemainFree := []Seat{"ABC", "ORCH1", "Z2"}
+		seatsToBuy := []Seat{"LL", "67231", "32y789dwund"}
+
+		inventory := NewInventory()
+		allSeatsToReserve := append(seatsToReserveOnly, seatsToBuy...)
+		for _, seat := range allSeatsToReserve {
+			err := inventory.Reserve(seat, testActor)
+			if err != nil {
+				t.Errorf("Unexpected error when reserving seat [%s]: %v", seat, err)
+			}
+		}
+
+		for _, seat := range seatsToBuy {
+			err := inventory.Buy(seat, testActor)
+			if err != nil {
+				t.Errorf("Unexpected error when buying seat [%s]: %v", seat, err)
+			}
+		}
+
+		expectAllSeatsToHaveStatus(t, inventory, seatsToRemainFree, FREE)
+		expectAllSeatsToHaveStatus(t, inventory, seatsToReserveOnly, RESERVED)
+		expectAllSeatsToHaveStatus(t, inventory, seatsToBuy, SOLD)
+	})
+
+	t.Run("Seats cannot be bought unless reserved", func(t *testing.T) {
+		seatsToReserveOnly := []Seat{"A1", "A4", "A321"}
+		seatsToRemainFree := []Seat{"ABC", "ORCH1", "Z2"}
+		seatsThatDontExist := []Seat{"XYZ", "XCC", "XCC"}
+
+		inventory := NewInventory()
+
+		for _, seat := range seatsToReserveOnly {
+			err := inventory.Reserve(seat, testActor)
+			if err != nil {
+				t.Errorf("Unexpected error when reserving seat [%s]: %v", seat, err)
+			}
+		}
+
+		allSeats := append(seatsToRemainFree, seatsThatDontExist...)
+		for _, seat := range allSeats {
+			err := inventory.Buy(seat, testActor)
+			if err == nil {
+				currentStatus, _ := inventory.Get(seat)
+				t.Fatalf("Expecting error when buying seat [%s], got nothing. Seart currently marked as [%s]", seat, currentStatus)
+			}
+		}
+
+		expectAllSeatsToHaveStatus(t, inventory, seatsToRemainFree, FREE)
+		expectAllSeatsToHaveStatus(t, inventory, seatsToReserveOnly, RESERVED)
+		expectAllSeatsToHaveStatus(t, inventory, seatsThatDontExist, FREE)
+	})
+}
+
+func TestInventoryCounts(t *testing.T) {
+	t.Run("Counts seats per status", func(t *testing.T) {
+		inventory := NewInventory()
+		for _, seat := range []Seat{"A1", "A2", "A3"} {
+			if err := inventory.Reserve(seat, testActor); err != nil {
+				t.Fatalf("Unexpected error when reserving seat [%s]: %v", seat, err)
+			}
+		}
+		if err := inventory.Buy("A1", testActor); err != nil {
+			t.Fatalf("Unexpected error when buying seat [A1]: %v", err)
+		}
+
+		counts, err := inventory.Counts()
+		if err != nil {
+			t.Fatalf("Unexpected error counting seats: %v", err)
+		}
+		if counts[RESERVED] != 2 || counts[SOLD] != 1 || counts[FREE] != 0 {
+			t.Errorf("Expected 2 reserved and 1 sold seats, got %v", counts)
+		}
+	})
+}
+
+func TestInventoryGetMany(t *testing.T) {
+	t.Run("Returns statuses in the order seats were asked for", func(t *testing.T) {
+		inventory := NewInventory()
+		if err := inventory.Reserve("B2", testActor); err != nil {
+			t.Fatalf("Unexpected error when reserving seat [B2]: %v", err)
+		}
+
+		statuses, err := inventory.GetMany([]Seat{"A1", "B2", "A1"})
+		if err != nil {
+			t.Fatalf("Unexpected error getting seats: %v", err)
+		}
+		if !reflect.DeepEqual(statuses, []SeatStatus{FREE, RESERVED, FREE}) {
+			t.Errorf("Unexpected statuses: %v", statuses)
+		}
+	})
+}
+
+func TestInventoryList(t *testing.T) {
+	inventory := NewInventory()
+	for _, seat := range []Seat{"B3", "A2", "B1", "A1", "B2", "C1"} {
+		if err := inventory.Reserve(seat, testActor); err != nil {
+			t.Fatalf("Unexpected error when reserving seat [%s]: %v", seat, err)
+		}
+	}
+	for _, seat := range []Seat{"B1", "B3"} {
+		if err := inventory.Buy(seat, testActor); err != nil {
+			t.Fatalf("Unexpected error when buying seat [%s]: %v", seat, err)
+		}
+	}
+
+	t.Run("Lists seats with a prefix in order", func(t *testing.T) {
+		listings, cursor, _ := inventory.List("B", "", "", 10)
+		expected := []SeatListing{{"B1", SOLD}, {"B2", RESERVED}, {"B3", SOLD}}
+		if !reflect.DeepEqual(listings, expected) || cursor != "" {
+			t.Errorf("Expected %v and no cursor, got %v and [%s]", expected, listings, cursor)
+		}
+	})
+
+	t.Run("Filters by status", func(t *testing.T) {
+		listings, _, _ := inventory.List("", SOLD, "", 10)
+		expected := []SeatListing{{"B1", SOLD}, {"B3", SOLD}}
+		if !reflect.DeepEqual(listings, expected) {
+			t.Errorf("Expected %v, got %v", expected, listings)
+		}
+	})
+
+	t.Run("Pages through seats using the cursor", func(t *testing.T) {
+		var all []SeatListing
+		pages := 0
+		cursor := Seat("")
+		for {
+			var listings []SeatListing
+			var err error
+			listings, cursor, err = inventory.List("", "", cursor, 4)
+			if err != nil {
+				t.Fatalf("Unexpected error listing seats: %v", err)
+			}
+			all = append(all, listings...)
+			pages++
+			if cursor == "" {
+				break
+			}
+		}
+
+		if pages != 2 || len(all) != 6 || all[0].Seat != "A1" || all[5].Seat != "C1" {
+			t.Errorf("Expected 6 seats in 2 pages, got %v in [%d] pages", all, pages)
+		}
+	})
+
+	t.Run("Doesnt report a cursor when the page is exactly full", func(t *testing.T) {
+		listings, cursor, _ := inventory.List("A", "", "", 2)
+		if len(listings) != 2 || cursor != "" {
+			t.Errorf("Expected 2 seats and no cursor, got %v and [%s]", listings, cursor)
+		}
+	})
+}
+
+func TestInventoryRefund(t *testing.T) {
+	t.Run("Refunds sold seats to FREE or QUARANTINED", func(t *testing.T) {
+		inventory := NewInventory()
+		for _, seat := range []Seat{"A1", "A2"} {
+			inventory.Reserve(seat, testActor)
+			inventory.Buy(seat, testActor)
+		}
+
+		if err := inventory.Refund("A1", FREE, "customer cancelled", testActor); err != nil {
+			t.Fatalf("Unexpected error refunding to FREE: %v", err)
+		}
+		if err := inventory.Refund("A2", QUARANTINED, "card charged back", testActor); err != nil {
+			t.Fatalf("Unexpected error refunding to QUARANTINED: %v", err)
+		}
+
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, FREE)
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A2"}, QUARANTINED)
+	})
+
+	t.Run("Records the reason in the history", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.Reserve("A1", testActor)
+		inventory.Buy("A1", testActor)
+		inventory.Refund("A1", FREE, "customer cancelled", testActor)
+
+		history := historyOf(t, inventory, "A1")
+		last := history[len(history)-1]
+		if last.From != SOLD || last.To != FREE || last.Reason != "customer cancelled" {
+			t.Errorf("Expected refund with reason as last entry, got %+v", last)
+		}
+		if history[0].Reason != "" {
+			t.Errorf("Expected no reason for a reservation, got %+v", history[0])
+		}
+	})
+
+	t.Run("Quarantined seats cant be reserved until released", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.Reserve("A1", testActor)
+		inventory.Buy("A1", testActor)
+		inventory.Refund("A1", QUARANTINED, "card charged back", testActor)
+
+		if err := inventory.Reserve("A1", testActor); err == nil {
+			t.Fatalf("Expected error reserving a quarantined seat")
+		}
+		if err := inventory.Refund("A1", FREE, "chargeback resolved", testActor); err != nil {
+			t.Fatalf("Unexpected error releasing quarantined seat: %v", err)
+		}
+		if err := inventory.Reserve("A1", testActor); err != nil {
+			t.Errorf("Unexpected error reserving a released seat: %v", err)
+		}
+	})
+
+	t.Run("Only refunds seats that were sold", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.Reserve("A2", testActor)
+
+		for _, seat := range []Seat{"A1", "A2"} {
+			if err := inventory.Refund(seat, FREE, "oops", testActor); err == nil {
+				t.Errorf("Expected error refunding seat [%s] that wasnt sold", seat)
+			}
+		}
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A2"}, RESERVED)
+	})
+}
+
+func TestInventoryReplication(t *testing.T) {
+	t.Run("Read only inventories only move seats they are told to replicate", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.SetReadOnly(true)
+
+		if err := inventory.Reserve("A1", testActor); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Expected a read only error, got %v", err)
+		}
+		if err := inventory.Replicate("A1", SOLD, testActor); err != nil {
+			t.Fatalf("Unexpected error replicating seat [A1]: %v", err)
+		}
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, SOLD)
+
+		inventory.SetReadOnly(false)
+		if err := inventory.Refund("A1", FREE, "replicated by mistake", testActor); err != nil {
+			t.Errorf("Unexpected error once writable: %v", err)
+		}
+	})
+
+	t.Run("Restores exactly the seats given", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.Reserve("A1", testActor)
+		inventory.Reserve("A2", testActor)
+
+		err := inventory.Restore([]SeatListing{{"A2", SOLD}, {"A3", QUARANTINED}}, testActor)
+		if err != nil {
+			t.Fatalf("Unexpected error restoring seats: %v", err)
+		}
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, FREE)
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A2"}, SOLD)
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A3"}, QUARANTINED)
+	})
+
+	t.Run("Releases the seats picked", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.Reserve("A1", testActor)
+		inventory.Reserve("B1", testActor)
+		inventory.Buy("B1", testActor)
+
+		picked, err := inventory.Pick(func(seat Seat) bool { return seat[0] == 'B' })
+		if err != nil || len(picked) != 1 || picked[0] != (SeatListing{"B1", SOLD}) {
+			t.Errorf("Expected [B1] to be picked as [%s], got %v %v", SOLD, picked, err)
+		}
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"B1"}, SOLD)
+
+		released, err := inventory.Release(func(seat Seat) bool { return seat[0] == 'B' }, testActor)
+		if err != nil {
+			t.Fatalf("Unexpected error releasing seats: %v", err)
+		}
+		if len(released) != 1 || released[0] != (SeatListing{"B1", SOLD}) {
+			t.Errorf("Expected [B1] to be released as [%s], got %v", SOLD, released)
+		}
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, RESERVED)
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"B1"}, FREE)
+	})
+
+	t.Run("Snapshots are followed by every later transition", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.Reserve("A1", testActor)
+
+		seats, subscription, latest, err := inventory.Snapshot()
+		if err != nil {
+			t.Fatalf("Unexpected error taking snapshot: %v", err)
+		}
+		defer inventory.Unsubscribe(subscription)
+		if len(seats) != 1 || seats[0] != (SeatListing{"A1", RESERVED}) || latest != 1 {
+			t.Errorf("Unexpected snapshot %v at sequence [%d]", seats, latest)
+		}
+
+		inventory.Buy("A1", testActor)
+		if transition := <-subscription.Events(); transition.Sequence != 2 || transition.To != SOLD {
+			t.Errorf("Unexpected transition after snapshot %+v", transition)
+		}
+	})
+}
+
+func expectAllSeatsToHaveStatus(t *testing.T, inventory *Inventory, seats []Seat, desiredStatus SeatStatus) {
+	for _, seat := range seats {
+		seatStatus, err := inventory.Get(seat)
+		if err != nil {
+			t.Fatalf("Unexpected error getting seat [%s]: %v", seat, err)
+		}
+		if seatStatus != desiredStatus {
+			t.Errorf("Seat [%s] expected to be [%s], got [%s]", seat, desiredStatus, seatStatus)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package inventory
+
+import "errors"
+
+var (
+	// ErrNotApplied is returned when a move log couldn't get a move agreed on, it was never applied and can
+	// be retried
+	ErrNotApplied = errors.New("move was not applied")
+	// ErrOutcomeUnknown is returned when a move log lost track of a move, it may or may not have been applied
+	ErrOutcomeUnknown = errors.New("move may or may not have been applied")
+)
+
+// Move is a command to move a seat, as a client asked for it. From is empty unless the command says which
+// status it expects the seat to be in.
+type Move struct {
+	Command    Command
+	Seat       Seat
+	From       SeatStatus
+	To         SeatStatus
+	Privileged bool
+	Reason     string
+	Actor      Actor
+}
+
+// MoveLog puts the moves clients ask an inventory for in an order agreed with other inventories, which
+// then each Apply them in that order. Propose only returns once the move was applied to this inventory,
+// with what applying it returned.
+type MoveLog interface {
+	Propose(move Move) error
+}
@@ -0,0 +1,446 @@
+package inventory
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	PAGE_SIZE                 = 4096
+	DEFAULT_BUFFER_POOL_PAGES = 1024
+	// WAL_CHECKPOINT_PAGES is how many pages are logged before they are all written to the page file and
+	// the log starts over
+	WAL_CHECKPOINT_PAGES = 4096
+)
+
+type pageID uint32
+
+const (
+	metaPage      pageID = 0
+	commitRecord         = ^uint32(0)
+	walHeaderSize        = 8
+)
+
+var pagerMagic = []byte("SEATPGR1")
+
+var errCorruptPage = errors.New("corrupt page")
+
+type page struct {
+	id   pageID
+	data []byte
+	// dirty pages were committed to the log but not written to the page file yet, in the log write numbered
+	// write, and can't be until that write is synced
+	dirty bool
+	write int64
+}
+
+// pager reads and writes fixed size pages of a file through a buffer pool that holds at most capacity
+// pages, besides those changed by the open transaction. Commit appends whole images of every page changed to
+// a write ahead log, and the transaction is durable once syncs says the log was synced, which it is before
+// anything touches the page file. Opening replays the committed transactions in the log, so a crash halfway
+// through writing a page, or a transaction, never leaves a broken tree behind. The pager is not safe for
+// concurrent use, but waiting on syncs is.
+type pager struct {
+	file     *os.File
+	wal      *os.File
+	syncs    *groupSync
+	walSize  int64
+	walPages int
+	capacity int
+	pool     map[pageID]*list.Element
+	lru      *list.List
+
+	// pageCount and root are kept in the meta page, the committed ones are what rollback goes back to
+	pageCount          uint32
+	root               pageID
+	committedPageCount uint32
+	committedRoot      pageID
+
+	// tx has the content pages changed by the open transaction had before it, nil for pages it allocated
+	tx map[pageID][]byte
+}
+
+// read returns the content of the page, which callers must not change
+func (p *pager) read(id pageID) ([]byte, error) {
+	pg, err := p.fetch(id)
+	if err != nil {
+		return nil, err
+	}
+	return pg.data, nil
+}
+
+// write changes the page as part of the open transaction
+func (p *pager) write(id pageID, data []byte) error {
+	pg, err := p.fetch(id)
+	if err != nil {
+		return err
+	}
+	if _, changed := p.tx[id]; !changed {
+		p.tx[id] = append([]byte(nil), pg.data...)
+	}
+	copy(pg.data, data)
+	return nil
+}
+
+// allocate adds an empty page to the file as part of the open transaction
+func (p *pager) allocate() pageID {
+	id := pageID(p.pageCount)
+	p.pageCount++
+	p.pool[id] = p.lru.PushFront(&page{id: id, data: make([]byte, PAGE_SIZE)})
+	p.tx[id] = nil
+	return id
+}
+
+func (p *pager) fetch(id pageID) (*page, error) {
+	if element, cached := p.pool[id]; cached {
+		p.lru.MoveToFront(element)
+		return element.Value.(*page), nil
+	}
+	if uint32(id) >= p.pageCount {
+		return nil, fmt.Errorf("page [%d] is past the end of [%s]", id, p.file.Name())
+	}
+
+	if err := p.evict(p.capacity - 1); err != nil {
+		return nil, err
+	}
+	pg := &page{id: id, data: make([]byte, PAGE_SIZE)}
+	if _, err := p.file.ReadAt(pg.data, int64(id)*PAGE_SIZE); err != nil {
+		return nil, fmt.Errorf("could not read page [%d] of [%s]: %v", id, p.file.Name(), err)
+	}
+	p.pool[id] = p.lru.PushFront(pg)
+	return pg, nil
+}
+
+// evict drops the least recently used pages until at most keep are left. Dirty pages are written to the
+// page file first, they are safe in the log already. Pages the open transaction changed stay, and so do
+// pages the log has yet to sync, until then there can be more than keep.
+func (p *pager) evict(keep int) error {
+	synced := p.syncs.durable()
+	for element := p.lru.Back(); element != nil && len(p.pool) > keep; {
+		pg := element.Value.(*page)
+		previous := element.Prev()
+		if _, changed := p.tx[pg.id]; !changed && pg.write <= synced {
+			if pg.dirty {
+				if _, err := p.file.WriteAt(pg.data, int64(pg.id)*PAGE_SIZE); err != nil {
+					return fmt.Errorf("could not write page [%d] of [%s]: %v", pg.id, p.file.Name(), err)
+				}
+			}
+			p.lru.Remove(element)
+			delete(p.pool, pg.id)
+		}
+		element = previous
+	}
+	return nil
+}
+
+// commit appends the open transaction to the log, or rolls it back if it can't. It is durable once
+// syncs.wait returns for the write commit returns, which also covers every transaction before it.
+func (p *pager) commit() (int64, error) {
+	if len(p.tx) == 0 {
+		return p.syncs.written(), nil
+	}
+	//A log that couldn't be synced might have lost anything written since, nothing goes after it
+	if err := p.syncs.failure(); err != nil {
+		p.rollback()
+		return 0, err
+	}
+	if p.pageCount != p.committedPageCount || p.root != p.committedRoot {
+		if err := p.write(metaPage, p.meta()); err != nil {
+			p.rollback()
+			return 0, err
+		}
+	}
+
+	ids := make([]pageID, 0, len(p.tx))
+	for id := range p.tx {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var records bytes.Buffer
+	for _, id := range ids {
+		data := p.pool[id].Value.(*page).data
+		writeWalHeader(&records, uint32(id), crc32.ChecksumIEEE(data))
+		records.Write(data)
+	}
+	writeWalHeader(&records, commitRecord, 0)
+
+	if _, err := p.wal.WriteAt(records.Bytes(), p.walSize); err != nil {
+		p.rollback()
+		return 0, fmt.Errorf("could not write to [%s]: %v", p.wal.Name(), err)
+	}
+	write := p.syncs.wrote()
+
+	for _, id := range ids {
+		pg := p.pool[id].Value.(*page)
+		pg.dirty, pg.write = true, write
+	}
+	p.tx = map[pageID][]byte{}
+	p.committedPageCount, p.committedRoot = p.pageCount, p.root
+	p.walSize += int64(records.Len())
+	p.walPages += len(ids)
+
+	//The transaction is in the log whatever happens now, pages that can't be written yet stay in the pool
+	//and the log until a later try succeeds
+	if p.walPages >= WAL_CHECKPOINT_PAGES {
+		p.checkpoint()
+	}
+	p.evict(p.capacity)
+	return write, nil
+}
+
+// rollback puts every page the open transaction changed back as it was
+func (p *pager) rollback() {
+	for id, original := range p.tx {
+		element := p.pool[id]
+		if original == nil {
+			p.lru.Remove(element)
+			delete(p.pool, id)
+		} else {
+			copy(element.Value.(*page).data, original)
+		}
+	}
+	p.tx = map[pageID][]byte{}
+	p.pageCount, p.root = p.committedPageCount, p.committedRoot
+}
+
+// checkpoint writes every dirty page to the page file and, once that is synced, empties the log
+func (p *pager) checkpoint() error {
+	written := p.syncs.written()
+	if err := p.syncs.wait(written); err != nil {
+		return err
+	}
+	for _, element := range p.pool {
+		pg := element.Value.(*page)
+		if !pg.dirty {
+			continue
+		}
+		if _, err := p.file.WriteAt(pg.data, int64(pg.id)*PAGE_SIZE); err != nil {
+			return fmt.Errorf("could not write page [%d] of [%s]: %v", pg.id, p.file.Name(), err)
+		}
+		pg.dirty = false
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+
+	if err := p.wal.Truncate(0); err != nil {
+		return err
+	}
+	p.walSize, p.walPages = 0, 0
+	return p.wal.Sync()
+}
+
+// groupSync shares syncs of the log between whoever waits for their writes to be durable, a write made
+// while a sync runs waits for the next one along with every other write made meanwhile
+type groupSync struct {
+	sync func() error
+	lock sync.Mutex
+	done *sync.Cond
+	// writes and syncs count what was done, syncs only for tests
+	writes  int64
+	synced  int64
+	syncing bool
+	syncs   int64
+	err     error
+}
+
+// wrote counts a write, returning it to wait for
+func (g *groupSync) wrote() int64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.writes++
+	return g.writes
+}
+
+func (g *groupSync) written() int64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.writes
+}
+
+// durable is the last write synced
+func (g *groupSync) durable() int64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.synced
+}
+
+func (g *groupSync) failure() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.err
+}
+
+// wait returns once the write is durable, syncing unless a sync already running covers it
+func (g *groupSync) wait(write int64) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for g.synced < write {
+		if g.err != nil {
+			return g.err
+		}
+		if g.syncing {
+			g.done.Wait()
+			continue
+		}
+
+		g.syncing = true
+		covered := g.writes
+		g.lock.Unlock()
+		err := g.sync()
+		g.lock.Lock()
+		g.syncing = false
+		g.syncs++
+		if err != nil {
+			g.err = NewKindError(ErrOutcomeUnknown, "could not sync the write ahead log, writes since the last sync may be lost: %v", err)
+		} else if covered > g.synced {
+			g.synced = covered
+		}
+		g.done.Broadcast()
+	}
+	return nil
+}
+
+func newGroupSync(syncLog func() error) *groupSync {
+	g := &groupSync{sync: syncLog}
+	g.done = sync.NewCond(&g.lock)
+	return g
+}
+
+func (p *pager) close() error {
+	err := p.checkpoint()
+	if closeErr := p.file.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := p.wal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (p *pager) meta() []byte {
+	data := make([]byte, PAGE_SIZE)
+	copy(data, pagerMagic)
+	binary.BigEndian.PutUint32(data[8:], p.pageCount)
+	binary.BigEndian.PutUint32(data[12:], uint32(p.root))
+	return data
+}
+
+func writeWalHeader(w *bytes.Buffer, id uint32, checksum uint32) {
+	var header [walHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:], id)
+	binary.BigEndian.PutUint32(header[4:], checksum)
+	w.Write(header[:])
+}
+
+// replayWal writes the pages of every committed transaction in the log to the page file, stopping at the
+// first record that is incomplete or doesn't match its checksum, which is where a crash cut the log short
+func replayWal(file *os.File, wal *os.File) error {
+	var header [walHeaderSize]byte
+	pending := map[uint32][]byte{}
+	for offset := int64(0); ; {
+		if _, err := wal.ReadAt(header[:], offset); err != nil {
+			break
+		}
+		id, checksum := binary.BigEndian.Uint32(header[0:]), binary.BigEndian.Uint32(header[4:])
+		offset += walHeaderSize
+
+		if id == commitRecord {
+			for id, data := range pending {
+				if _, err := file.WriteAt(data, int64(id)*PAGE_SIZE); err != nil {
+					return err
+				}
+			}
+			pending = map[uint32][]byte{}
+			continue
+		}
+
+		data := make([]byte, PAGE_SIZE)
+		if _, err := wal.ReadAt(data, offset); err != nil || crc32.ChecksumIEEE(data) != checksum {
+			break
+		}
+		pending[id] = data
+		offset += PAGE_SIZE
+	}
+
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := wal.Truncate(0); err != nil {
+		return err
+	}
+	return wal.Sync()
+}
+
+// openPager opens the page file at path and its log next to it, creating them with an empty root page if
+// they don't exist. What the root page holds is up to the caller.
+func openPager(path string, capacity int, emptyRoot []byte) (*pager, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(path+".wal", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	p := &pager{
+		file:     file,
+		wal:      wal,
+		capacity: capacity,
+		pool:     map[pageID]*list.Element{},
+		lru:      list.New(),
+		tx:       map[pageID][]byte{},
+		syncs:    newGroupSync(wal.Sync),
+	}
+	err = p.load(emptyRoot)
+	if err != nil {
+		file.Close()
+		wal.Close()
+		return nil, fmt.Errorf("could not open [%s]: %v", path, err)
+	}
+	return p, nil
+}
+
+func (p *pager) load(emptyRoot []byte) error {
+	if err := replayWal(p.file, p.wal); err != nil {
+		return err
+	}
+
+	meta := make([]byte, PAGE_SIZE)
+	_, err := p.file.ReadAt(meta, 0)
+	if err == io.EOF {
+		//A new file, it only needs the meta page and a root
+		p.allocate()
+		p.root = p.allocate()
+		if err := p.write(p.root, emptyRoot); err != nil {
+			return err
+		}
+		write, err := p.commit()
+		if err != nil {
+			return err
+		}
+		return p.syncs.wait(write)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(meta[:len(pagerMagic)], pagerMagic) {
+		return fmt.Errorf("%v: meta page doesn't start with %q", errCorruptPage, pagerMagic)
+	}
+	p.pageCount = binary.BigEndian.Uint32(meta[8:])
+	p.root = pageID(binary.BigEndian.Uint32(meta[12:]))
+	p.committedPageCount, p.committedRoot = p.pageCount, p.root
+	return nil
+}
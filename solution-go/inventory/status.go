@@ -0,0 +1,123 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Seat names a seat, like A1
+type Seat string
+
+// Command names what moves a seat, clients send the commands of the same name to the server
+type Command string
+
+const (
+	RESERVE Command = "RESERVE"
+	BUY     Command = "BUY"
+	CAS     Command = "CAS"
+	REFUND  Command = "REFUND"
+)
+
+type SeatStatus string
+
+const (
+	FREE        SeatStatus = "FREE"
+	RESERVED    SeatStatus = "RESERVED"
+	SOLD        SeatStatus = "SOLD"
+	QUARANTINED SeatStatus = "QUARANTINED"
+)
+
+var allStatuses = []SeatStatus{FREE, RESERVED, SOLD, QUARANTINED}
+
+type seatTransition struct {
+	command    Command
+	from       SeatStatus
+	to         SeatStatus
+	privileged bool
+}
+
+// transitionTable is every move a seat can make and the command that makes it. Going back to FREE undoes
+// somebody else's reservation or purchase, so only privileged clients can do it. Refunded seats can be
+// QUARANTINED until somebody checks them, and nobody can reserve them meanwhile.
+var transitionTable = []seatTransition{
+	{RESERVE, FREE, RESERVED, false},
+	{BUY, RESERVED, SOLD, false},
+	{REFUND, SOLD, FREE, true},
+	{REFUND, SOLD, QUARANTINED, true},
+	{REFUND, QUARANTINED, FREE, true},
+	{CAS, FREE, RESERVED, false},
+	{CAS, RESERVED, SOLD, false},
+	{CAS, RESERVED, FREE, true},
+	{CAS, SOLD, FREE, true},
+	{CAS, SOLD, QUARANTINED, true},
+	{CAS, QUARANTINED, FREE, true},
+}
+
+// allTransitions are the moves of seats and of events' records, see eventTransitionTable
+var allTransitions = append(append([]seatTransition(nil), transitionTable...), eventTransitionTable...)
+
+var (
+	ErrWrongStatus         = errors.New("seat is not in a status it can be moved from")
+	ErrForbiddenTransition = errors.New("seats never make this move")
+	ErrNotPrivileged       = errors.New("only privileged clients can make this move")
+)
+
+// TransitionError tells why a seat couldn't move, errors.Is matches it against the Err* kinds above
+type TransitionError struct {
+	Kind    error
+	Command Command
+	Seat    Seat
+	Status  SeatStatus
+	To      SeatStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s of seat [%s] from [%s] to [%s] refused: %v", e.Command, e.Seat, e.Status, e.To, e.Kind)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return e.Kind
+}
+
+// IsStatus tells whether the name is one of the statuses seats can be in
+func IsStatus(status string) bool {
+	for _, s := range allStatuses {
+		if string(s) == status {
+			return true
+		}
+	}
+	return false
+}
+
+func findTransition(command Command, from SeatStatus, to SeatStatus) (seatTransition, bool) {
+	for _, t := range allTransitions {
+		if t.command == command && t.from == from && t.to == to {
+			return t, true
+		}
+	}
+	return seatTransition{}, false
+}
+
+// AlwaysPrivileged tells whether every move the command makes needs a privileged client, so anybody
+// allowed to send the command is
+func AlwaysPrivileged(command Command) bool {
+	found := false
+	for _, t := range allTransitions {
+		if t.command == command {
+			if !t.privileged {
+				return false
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+func leadsTo(command Command, to SeatStatus) bool {
+	for _, t := range allTransitions {
+		if t.command == command && t.to == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package inventory
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransitionTable(t *testing.T) {
+	t.Run("Only mentions known statuses", func(t *testing.T) {
+		for _, transition := range transitionTable {
+			if !IsStatus(string(transition.from)) || !IsStatus(string(transition.to)) {
+				t.Errorf("Unknown status in transition %+v", transition)
+			}
+		}
+	})
+
+	t.Run("Every move a command makes can also be made with CAS", func(t *testing.T) {
+		for _, transition := range transitionTable {
+			cas, found := findTransition(CAS, transition.from, transition.to)
+			if !found {
+				t.Errorf("Transition %+v cant be made with CAS", transition)
+			} else if cas.privileged != transition.privileged {
+				t.Errorf("Transition %+v needs different privileges with CAS", transition)
+			}
+		}
+	})
+}
+
+func TestTransitionErrors(t *testing.T) {
+	t.Run("Tells which kind of problem refused the move", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.Reserve("A1", testActor)
+		inventory.Reserve("A2", testActor)
+		inventory.Buy("A2", testActor)
+
+		expectations := []struct {
+			err  error
+			kind error
+		}{
+			{inventory.Reserve("A1", testActor), ErrWrongStatus},
+			{inventory.Buy("B1", testActor), ErrWrongStatus},
+			{inventory.Refund("A1", FREE, "oops", testActor), ErrWrongStatus},
+			{inventory.Refund("A2", RESERVED, "oops", testActor), ErrForbiddenTransition},
+			{inventory.CompareAndSet("A1", FREE, SOLD, true, testActor), ErrForbiddenTransition},
+			{inventory.CompareAndSet("A2", SOLD, FREE, false, testActor), ErrNotPrivileged},
+			{inventory.CompareAndSet("A2", RESERVED, SOLD, false, testActor), ErrWrongStatus},
+		}
+
+		for i, e := range expectations {
+			if !errors.Is(e.err, e.kind) {
+				t.Errorf("Expectation [%d] should have failed with [%v], got [%v]", i, e.kind, e.err)
+			}
+		}
+	})
+
+	t.Run("Reports the status the seat was in", func(t *testing.T) {
+		inventory := NewInventory()
+		inventory.Reserve("A1", testActor)
+
+		var transitionErr *TransitionError
+		err := inventory.Reserve("A1", testActor)
+		if !errors.As(err, &transitionErr) {
+			t.Fatalf("Expected a TransitionError, got [%v]", err)
+		}
+		if transitionErr.Status != RESERVED || transitionErr.To != RESERVED || transitionErr.Command != RESERVE {
+			t.Errorf("Unexpected error details %+v", transitionErr)
+		}
+	})
+}
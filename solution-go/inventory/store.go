@@ -0,0 +1,88 @@
+package inventory
+
+import "sync"
+
+// SeatStore keeps the status of each seat for an Inventory. Seats the store never saw are FREE. Every method
+// is atomic on its own and safe to call from many goroutines, the Inventory takes care of everything that
+// spans more than one call.
+type SeatStore interface {
+	// Get is the status of the seat
+	Get(seat Seat) (SeatStatus, error)
+	// CompareAndSwap moves the seat to status only if it currently is in old, telling whether it did
+	CompareAndSwap(seat Seat, old SeatStatus, status SeatStatus) (bool, error)
+	// Range visits the seats the store knows about in order, starting at from, until visit returns false.
+	// Seats only become known once they are first moved. Visit must not call the store.
+	Range(from Seat, visit func(seat Seat, status SeatStatus) bool) error
+}
+
+// groupSynced stores and histories leave what they write to be synced along with whatever else is written
+// meanwhile. The function synced returns waits until everything written before it was called is durable.
+type groupSynced interface {
+	synced() func() error
+}
+
+// groupCommitStore stores can move seats without waiting for the move to be durable, inventories move seats
+// holding their lock and only wait once they let go of it, so moves made meanwhile share the sync
+type groupCommitStore interface {
+	groupSynced
+	compareAndSwapUnsynced(seat Seat, old SeatStatus, status SeatStatus) (bool, error)
+}
+
+// MapStore keeps seats in memory, it is what inventories use unless given another store
+type MapStore struct {
+	seats map[Seat]SeatStatus
+	index *seatIndex
+	lock  sync.RWMutex
+}
+
+func (s *MapStore) Get(seat Seat) (SeatStatus, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.get(seat), nil
+}
+
+func (s *MapStore) CompareAndSwap(seat Seat, old SeatStatus, status SeatStatus) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.get(seat) != old {
+		return false, nil
+	}
+	s.set(seat, status)
+	return true, nil
+}
+
+func (s *MapStore) Range(from Seat, visit func(seat Seat, status SeatStatus) bool) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for node := s.index.Seek(from); node != nil; node = node.next[0] {
+		if !visit(node.seat, s.get(node.seat)) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MapStore) get(seat Seat) SeatStatus {
+	status := s.seats[seat]
+	if status == "" {
+		return FREE
+	}
+	return status
+}
+
+// set moves the seat without checking where it was, callers hold the lock
+func (s *MapStore) set(seat Seat, status SeatStatus) {
+	if _, known := s.seats[seat]; !known {
+		s.index.Insert(seat)
+	}
+	s.seats[seat] = status
+}
+
+func NewMapStore() *MapStore {
+	return &MapStore{
+		seats: map[Seat]SeatStatus{},
+		index: newSeatIndex(),
+	}
+}
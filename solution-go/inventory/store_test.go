@@ -0,0 +1,540 @@
+package inventory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// testSeatStore is what every SeatStore must do, each backend runs it with a function opening an empty store
+func testSeatStore(t *testing.T, open func(t *testing.T) SeatStore) {
+	t.Run("Seats it never saw are FREE", func(t *testing.T) {
+		store := open(t)
+		expectStoredStatus(t, store, "A1", FREE)
+	})
+
+	t.Run("Only swaps seats in the status expected", func(t *testing.T) {
+		store := open(t)
+		expectSwap(t, store, "A1", FREE, RESERVED, true)
+		expectSwap(t, store, "A1", FREE, SOLD, false)
+		expectStoredStatus(t, store, "A1", RESERVED)
+
+		expectSwap(t, store, "A1", RESERVED, SOLD, true)
+		expectStoredStatus(t, store, "A1", SOLD)
+	})
+
+	t.Run("Ranges over seats in order from where it is asked to", func(t *testing.T) {
+		store := open(t)
+		for _, seat := range []Seat{"B2", "A1", "C1", "B1"} {
+			expectSwap(t, store, seat, FREE, RESERVED, true)
+		}
+		expectSwap(t, store, "B1", RESERVED, FREE, true)
+
+		expected := []SeatListing{{"B1", FREE}, {"B2", RESERVED}, {"C1", RESERVED}}
+		if actual := rangeOver(t, store, "B", -1); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("Stops ranging when told to", func(t *testing.T) {
+		store := open(t)
+		for _, seat := range []Seat{"A1", "A2", "A3"} {
+			expectSwap(t, store, seat, FREE, RESERVED, true)
+		}
+
+		expected := []SeatListing{{"A1", RESERVED}, {"A2", RESERVED}}
+		if actual := rangeOver(t, store, "", 2); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("Swaps atomically", func(t *testing.T) {
+		store := open(t)
+		var swaps int64
+		var wait sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				swapped, err := store.CompareAndSwap("A1", FREE, RESERVED)
+				if err != nil {
+					t.Errorf("Unexpected error swapping: %v", err)
+				}
+				if swapped {
+					atomic.AddInt64(&swaps, 1)
+				}
+			}()
+		}
+		wait.Wait()
+
+		if swaps != 1 {
+			t.Errorf("Expected exactly one swap to win, [%d] did", swaps)
+		}
+	})
+
+	t.Run("Backs an inventory", func(t *testing.T) {
+		inventory := NewStoredInventory(open(t), NewAuditTrail(nil))
+		if err := inventory.Reserve("A1", testActor); err != nil {
+			t.Fatalf("Unexpected error when reserving seat [A1]: %v", err)
+		}
+		if err := inventory.Reserve("A1", testActor); err == nil {
+			t.Errorf("Expected reserving seat [A1] twice to fail")
+		}
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A1"}, RESERVED)
+	})
+}
+
+func expectStoredStatus(t *testing.T, store SeatStore, seat Seat, expected SeatStatus) {
+	status, err := store.Get(seat)
+	if err != nil {
+		t.Fatalf("Unexpected error getting seat [%s]: %v", seat, err)
+	}
+	if status != expected {
+		t.Errorf("Expected seat [%s] to be [%s], got [%s]", seat, expected, status)
+	}
+}
+
+func expectSwap(t *testing.T, store SeatStore, seat Seat, old SeatStatus, status SeatStatus, expected bool) {
+	swapped, err := store.CompareAndSwap(seat, old, status)
+	if err != nil {
+		t.Fatalf("Unexpected error swapping seat [%s]: %v", seat, err)
+	}
+	if swapped != expected {
+		t.Errorf("Expected swapping seat [%s] from [%s] to [%s] to be [%v]", seat, old, status, expected)
+	}
+}
+
+// rangeOver collects at most limit seats, or all of them when limit is negative
+func rangeOver(t *testing.T, store SeatStore, from Seat, limit int) []SeatListing {
+	var visited []SeatListing
+	err := store.Range(from, func(seat Seat, status SeatStatus) bool {
+		visited = append(visited, SeatListing{seat, status})
+		return len(visited) != limit
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error ranging over seats: %v", err)
+	}
+	return visited
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Error writing [%s]: %v", path, err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading [%s]: %v", path, err)
+	}
+	return string(content)
+}
+
+func TestMapStore(t *testing.T) {
+	testSeatStore(t, func(t *testing.T) SeatStore {
+		return NewMapStore()
+	})
+}
+
+// failingStore refuses to move one seat, like a backend that can't keep it
+type failingStore struct {
+	SeatStore
+	failing Seat
+}
+
+func (s *failingStore) CompareAndSwap(seat Seat, old SeatStatus, status SeatStatus) (bool, error) {
+	if seat == s.failing {
+		return false, errors.New("disk full")
+	}
+	return s.SeatStore.CompareAndSwap(seat, old, status)
+}
+
+func TestStoredInventory(t *testing.T) {
+	t.Run("Only audits moves the store made", func(t *testing.T) {
+		inventory := NewStoredInventory(&failingStore{NewMapStore(), "A2"}, NewAuditTrail(nil))
+
+		if err := inventory.Reserve("A1", testActor); err != nil {
+			t.Fatalf("Unexpected error reserving: %v", err)
+		}
+		if err := inventory.Reserve("A2", testActor); err == nil {
+			t.Fatalf("Expected error reserving a seat the store refuses")
+		}
+
+		expectAllSeatsToHaveStatus(t, inventory, []Seat{"A2"}, FREE)
+		if history, _ := inventory.History("A2"); len(history) != 0 {
+			t.Errorf("Expected no history for a seat that never moved, got %+v", history)
+		}
+		if history, _ := inventory.History("A1"); len(history) != 1 {
+			t.Errorf("Expected the reservation in the history, got %+v", history)
+		}
+	})
+
+	t.Run("Only audits seats the B-tree can keep", func(t *testing.T) {
+		store, err := OpenBTreeStore(filepath.Join(tempDir(t), "seats"), 4)
+		if err != nil {
+			t.Fatalf("Unexpected error opening store: %v", err)
+		}
+		defer store.Close()
+		inventory := NewStoredInventory(store, NewAuditTrail(nil))
+
+		seat := Seat(strings.Repeat("A", MAX_KEY_SIZE+1))
+		if err := inventory.Reserve(seat, testActor); err == nil {
+			t.Fatalf("Expected error reserving a seat too long to be a key")
+		}
+		if history, _ := inventory.History(seat); len(history) != 0 {
+			t.Errorf("Expected no history for a seat that never moved, got %+v", history)
+		}
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	openFileStore := func(t *testing.T, path string) *FileStore {
+		store, err := OpenFileStore(path)
+		if err != nil {
+			t.Fatalf("Unexpected error opening store: %v", err)
+		}
+		return store
+	}
+
+	testSeatStore(t, func(t *testing.T) SeatStore {
+		return openFileStore(t, filepath.Join(tempDir(t), "seats"))
+	})
+
+	t.Run("Keeps seats after reopening", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "seats")
+		store := openFileStore(t, path)
+		expectSwap(t, store, "A1", FREE, RESERVED, true)
+		expectSwap(t, store, "A2", FREE, RESERVED, true)
+		expectSwap(t, store, "A2", RESERVED, SOLD, true)
+		store.Close()
+
+		reopened := openFileStore(t, path)
+		defer reopened.Close()
+		expectStoredStatus(t, reopened, "A1", RESERVED)
+		expectStoredStatus(t, reopened, "A2", SOLD)
+		expectSwap(t, reopened, "A1", RESERVED, SOLD, true)
+	})
+
+	t.Run("Drops a torn line left by a crash", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "seats")
+		writeFile(t, path, "A1 RESERVED\nA2 RESERV")
+
+		store := openFileStore(t, path)
+		expectStoredStatus(t, store, "A1", RESERVED)
+		expectStoredStatus(t, store, "A2", FREE)
+		expectSwap(t, store, "A3", FREE, RESERVED, true)
+		store.Close()
+
+		if content := readFile(t, path); content != "A1 RESERVED\nA3 RESERVED\n" {
+			t.Errorf("Expected the torn line to be replaced, got %q", content)
+		}
+	})
+
+	t.Run("Refuses files that aren't seats", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "seats")
+		writeFile(t, path, "A1 RESERVED\nA2 TAKEN\nA3 SOLD\n")
+
+		if _, err := OpenFileStore(path); err == nil {
+			t.Errorf("Expected error opening a file with an unknown status")
+		}
+	})
+
+	t.Run("Compacts files that are mostly overwritten moves", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "seats")
+		writeFile(t, path, "A1 RESERVED\nA1 SOLD\nA2 RESERVED\nA1 FREE\nA2 SOLD\n")
+
+		store := openFileStore(t, path)
+		defer store.Close()
+		if content := readFile(t, path); content != "A1 FREE\nA2 SOLD\n" {
+			t.Errorf("Expected one line per seat, got %q", content)
+		}
+		expectStoredStatus(t, store, "A1", FREE)
+		expectStoredStatus(t, store, "A2", SOLD)
+	})
+}
+
+func TestBTreeStore(t *testing.T) {
+	//A pool this small makes even few seats go through evictions
+	openBTreeStore := func(t *testing.T, path string) *BTreeStore {
+		store, err := OpenBTreeStore(path, 4)
+		if err != nil {
+			t.Fatalf("Unexpected error opening store: %v", err)
+		}
+		return store
+	}
+	// crash closes the files without writing anything the store had pending
+	crash := func(store *BTreeStore) {
+		store.tree.pager.file.Close()
+		store.tree.pager.wal.Close()
+	}
+	reserveAll := func(t *testing.T, store SeatStore, count int) {
+		for i := 0; i < count; i++ {
+			expectSwap(t, store, Seat(fmt.Sprintf("A%d", i)), FREE, RESERVED, true)
+		}
+	}
+
+	testSeatStore(t, func(t *testing.T) SeatStore {
+		return openBTreeStore(t, filepath.Join(tempDir(t), "seats"))
+	})
+
+	t.Run("Keeps more seats than fit in a page after reopening", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "seats")
+		store := openBTreeStore(t, path)
+		reserveAll(t, store, 3000)
+		expectSwap(t, store, "A1234", RESERVED, SOLD, true)
+		store.Close()
+
+		reopened := openBTreeStore(t, path)
+		defer reopened.Close()
+		expectStoredStatus(t, reopened, "A0", RESERVED)
+		expectStoredStatus(t, reopened, "A1234", SOLD)
+		expectStoredStatus(t, reopened, "A2999", RESERVED)
+		expectStoredStatus(t, reopened, "A3000", FREE)
+		if seats := rangeOver(t, reopened, "", -1); len(seats) != 3000 {
+			t.Errorf("Expected to range over [3000] seats, got [%d]", len(seats))
+		}
+		expected := []SeatListing{{"A1234", SOLD}, {"A1235", RESERVED}}
+		if actual := rangeOver(t, reopened, "A1234", 2); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("Replays acknowledged moves after a crash", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "seats")
+		store := openBTreeStore(t, path)
+		reserveAll(t, store, 500)
+		crash(store)
+
+		reopened := openBTreeStore(t, path)
+		defer reopened.Close()
+		expectStoredStatus(t, reopened, "A0", RESERVED)
+		expectStoredStatus(t, reopened, "A499", RESERVED)
+		if seats := rangeOver(t, reopened, "", -1); len(seats) != 500 {
+			t.Errorf("Expected to range over [500] seats, got [%d]", len(seats))
+		}
+	})
+
+	t.Run("Repairs pages torn by a crash", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "seats")
+		store := openBTreeStore(t, path)
+		reserveAll(t, store, 500)
+		//Evicting wrote pages to the file, pretend the crash happened halfway through one of them
+		store.tree.pager.file.WriteAt(bytes.Repeat([]byte{0xff}, PAGE_SIZE/2), PAGE_SIZE)
+		crash(store)
+
+		reopened := openBTreeStore(t, path)
+		defer reopened.Close()
+		if seats := rangeOver(t, reopened, "", -1); len(seats) != 500 {
+			t.Errorf("Expected to range over [500] seats, got [%d]", len(seats))
+		}
+	})
+
+	t.Run("Drops a move cut short by a crash", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "seats")
+		store := openBTreeStore(t, path)
+		expectSwap(t, store, "A1", FREE, RESERVED, true)
+		//Half of the next move's page made it to the log, its commit record didn't
+		store.tree.pager.wal.WriteAt(append([]byte{0, 0, 0, 1, 0, 0, 0, 0}, make([]byte, PAGE_SIZE/2)...), store.tree.pager.walSize)
+		crash(store)
+
+		reopened := openBTreeStore(t, path)
+		defer reopened.Close()
+		expectStoredStatus(t, reopened, "A1", RESERVED)
+		expectSwap(t, reopened, "A2", FREE, RESERVED, true)
+	})
+
+	t.Run("Keeps moves made at once after a crash", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "seats")
+		store := openBTreeStore(t, path)
+		audit, err := OpenBTreeAuditTrail(nil, filepath.Join(filepath.Dir(path), "history"), 4)
+		if err != nil {
+			t.Fatalf("Unexpected error opening audit trail: %v", err)
+		}
+		defer audit.Close()
+		inventory := NewStoredInventory(store, audit)
+		var reserving sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			reserving.Add(1)
+			go func(i int) {
+				defer reserving.Done()
+				for j := 0; j < 25; j++ {
+					if err := inventory.Reserve(Seat(fmt.Sprintf("A%d-%d", i, j)), testActor); err != nil {
+						t.Errorf("Unexpected error reserving: %v", err)
+					}
+				}
+			}(i)
+		}
+		reserving.Wait()
+		crash(store)
+
+		reopened := openBTreeStore(t, path)
+		defer reopened.Close()
+		if seats := rangeOver(t, reopened, "", -1); len(seats) != 200 {
+			t.Errorf("Expected to range over [200] seats, got [%d]", len(seats))
+		}
+	})
+
+	t.Run("Shares syncs between moves made while one runs", func(t *testing.T) {
+		var syncs int32
+		started, release := make(chan struct{}), make(chan struct{})
+		group := newGroupSync(func() error {
+			if atomic.AddInt32(&syncs, 1) == 1 {
+				close(started)
+				<-release
+			}
+			return nil
+		})
+
+		waited := make(chan error, 6)
+		first := group.wrote()
+		go func() { waited <- group.wait(first) }()
+		<-started
+		for i := 0; i < 5; i++ {
+			write := group.wrote()
+			go func() { waited <- group.wait(write) }()
+		}
+		close(release)
+		for i := 0; i < 6; i++ {
+			if err := <-waited; err != nil {
+				t.Fatalf("Unexpected error waiting: %v", err)
+			}
+		}
+		if syncs != 2 {
+			t.Errorf("Expected the writes made during the first sync to share the second, synced [%d] times", syncs)
+		}
+	})
+
+	t.Run("Refuses moves once the log couldn't be synced", func(t *testing.T) {
+		store := openBTreeStore(t, filepath.Join(tempDir(t), "seats"))
+		defer store.Close()
+		expectSwap(t, store, "A1", FREE, RESERVED, true)
+		store.tree.pager.syncs.sync = func() error { return errors.New("disk gone") }
+
+		if _, err := store.CompareAndSwap("A2", FREE, RESERVED); !errors.Is(err, ErrOutcomeUnknown) {
+			t.Errorf("Expected the move not to be known durable, got %v", err)
+		}
+		if _, err := store.CompareAndSwap("A3", FREE, RESERVED); err == nil {
+			t.Errorf("Expected moves after a failed sync to be refused")
+		}
+		expectStoredStatus(t, store, "A3", FREE)
+	})
+
+	t.Run("Only keeps its pools in memory however many seats there are", func(t *testing.T) {
+		dir := tempDir(t)
+		store := openBTreeStore(t, filepath.Join(dir, "seats"))
+		defer store.Close()
+		audit, err := OpenBTreeAuditTrail(nil, filepath.Join(dir, "history"), 4)
+		if err != nil {
+			t.Fatalf("Unexpected error opening audit trail: %v", err)
+		}
+		defer audit.Close()
+		history := audit.history.(*treeHistory)
+		reserve := func(from int, to int) {
+			for i := from; i < to; i++ {
+				seat := Seat(fmt.Sprintf("A%d", i))
+				expectSwap(t, store, seat, FREE, RESERVED, true)
+				if err := audit.Record(AuditEntry{Seat: seat, From: FREE, To: RESERVED, Reason: strings.Repeat("x", 100)}); err != nil {
+					t.Fatalf("Unexpected error recording: %v", err)
+				}
+				if err := audit.synced()(); err != nil {
+					t.Fatalf("Unexpected error syncing: %v", err)
+				}
+				//Pages a move changed stay until the log has them synced, a few per tree
+				if pool := len(history.tree.pager.pool) + len(store.tree.pager.pool); pool > 2*(4+4) {
+					t.Fatalf("Expected at most [%d] pages in the pools, there are [%d]", 2*(4+4), pool)
+				}
+			}
+		}
+		heap := func() uint64 {
+			var memory runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&memory)
+			return memory.HeapInuse
+		}
+
+		reserve(0, 1000)
+		before := heap()
+		//Four times the seats, and their histories, take over a megabyte in the files
+		reserve(1000, 5000)
+		if after := heap(); after > before+256<<10 {
+			t.Errorf("Expected memory to stay bounded by the pools, the heap went from [%d] to [%d] bytes", before, after)
+		}
+	})
+
+	t.Run("Refuses seats too long to be a key", func(t *testing.T) {
+		store := openBTreeStore(t, filepath.Join(tempDir(t), "seats"))
+		defer store.Close()
+		if _, err := store.CompareAndSwap(Seat(strings.Repeat("A", MAX_KEY_SIZE+1)), FREE, RESERVED); err == nil {
+			t.Errorf("Expected error storing a seat longer than [%d] bytes", MAX_KEY_SIZE)
+		}
+		reserveAll(t, store, 10)
+	})
+
+	t.Run("Refuses files that aren't trees", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "seats")
+		writeFile(t, path, strings.Repeat("A1 RESERVED\n", PAGE_SIZE))
+
+		if _, err := OpenBTreeStore(path, 4); err == nil {
+			t.Errorf("Expected error opening a file that isn't a tree")
+		}
+	})
+}
+
+// BenchmarkBTreeInventory reserves and buys b.N seats from 16 clients per processor, keeping them and their
+// histories in B-trees with the default buffer pools. Memory stays bounded by the pools however many seats
+// there are, going from 50000 seats to the tester's default of 500000 with -benchtime 500000x measured:
+//
+//	BenchmarkBTreeInventory    50000    539043 ns/op   6.836 heap-MB   37.59 sys-MB
+//	BenchmarkBTreeInventory   500000    650109 ns/op   7.484 heap-MB   50.34 sys-MB
+func BenchmarkBTreeInventory(b *testing.B) {
+	dir, err := ioutil.TempDir("", "benchmark")
+	if err != nil {
+		b.Fatalf("Error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := OpenBTreeStore(filepath.Join(dir, "seats"), DEFAULT_BUFFER_POOL_PAGES)
+	if err != nil {
+		b.Fatalf("Unexpected error opening store: %v", err)
+	}
+	audit, err := OpenBTreeAuditTrail(nil, filepath.Join(dir, "history"), DEFAULT_BUFFER_POOL_PAGES)
+	if err != nil {
+		b.Fatalf("Unexpected error opening audit trail: %v", err)
+	}
+	defer audit.Close()
+	inventory := NewStoredInventory(store, audit)
+
+	//Clients mostly wait on syncs, there are many more of them than processors
+	var next int64
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			seat := Seat(fmt.Sprintf("A%d", atomic.AddInt64(&next, 1)))
+			if err := inventory.Reserve(seat, testActor); err != nil {
+				b.Errorf("Unexpected error reserving seat [%s]: %v", seat, err)
+				return
+			}
+			if err := inventory.Buy(seat, testActor); err != nil {
+				b.Errorf("Unexpected error buying seat [%s]: %v", seat, err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+
+	var memory runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&memory)
+	b.ReportMetric(float64(memory.Sys)/(1<<20), "sys-MB")
+	b.ReportMetric(float64(memory.HeapInuse)/(1<<20), "heap-MB")
+}
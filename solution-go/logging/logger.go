@@ -1,17 +1,22 @@
-package main
+// Package logging writes the server logs.
+package logging
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"os"
+	"runtime"
 	"strconv"
 )
 
+// Logger writes leveled lines to stdout, prefixed with the goroutine that wrote them so connections can be told apart
 type Logger struct {
 	debug  bool
 	logger *log.Logger
 }
 
+// Debugf is only written when the logger was created with debug on
 func (l *Logger) Debugf(format string, v ...interface{}) {
 	if l.debug {
 		l.log("DEBUG", format, v...)
@@ -40,3 +45,13 @@ func NewLogger(debug bool) *Logger {
 		logger: actualLogger,
 	}
 }
+
+func logPrefix() string {
+	//from https://blog.sgmansfield.com/2015/12/goroutine-ids/
+	b := make([]byte, 64)
+	b = b[:runtime.Stack(b, false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	b = b[:bytes.IndexByte(b, ' ')]
+	n, _ := strconv.ParseUint(string(b), 10, 64)
+	return fmt.Sprintf("%04d", n)
+}
@@ -0,0 +1,94 @@
+// Package partition spreads seats over several servers, each keeping only the seats a consistent hash of
+// their names gives it. Seats of an event are hashed by the event's name, so they stay together. Any
+// server takes clients' commands and forwards them to the one owning the seat. When a server joins, the
+// others hand it the seats it now owns, and only those move.
+package partition
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+)
+
+// VIRTUAL_NODES is how many points each node has on the ring, more points spread seats more evenly
+const VIRTUAL_NODES = 128
+
+type point struct {
+	hash uint32
+	node string
+}
+
+// Ring gives each seat to the node with the first point after the seat's hash, going round. It is never
+// changed, joining makes a new one.
+type Ring struct {
+	nodes  []string
+	points []point
+}
+
+// hash mixes FNV's bits further, alone it puts names differing only in their last characters, like nodes
+// on the same host, close together on the ring
+func hash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	sum := h.Sum32()
+	sum ^= sum >> 16
+	sum *= 0x85ebca6b
+	sum ^= sum >> 13
+	sum *= 0xc2b2ae35
+	sum ^= sum >> 16
+	return sum
+}
+
+// Owner is the node the seat belongs to, the one its event belongs to if it is in one
+func (r *Ring) Owner(seat inventory.Seat) string {
+	key := string(seat)
+	if event, inEvent := inventory.EventOf(seat); inEvent {
+		key = string(inventory.EventRecord(event))
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+// Nodes are every node on the ring, in the order they were added
+func (r *Ring) Nodes() []string {
+	return append([]string(nil), r.nodes...)
+}
+
+func (r *Ring) Has(node string) bool {
+	for _, n := range r.nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// With is the ring once the node joined, seats only move from other nodes to it
+func (r *Ring) With(node string) *Ring {
+	if r.Has(node) {
+		return r
+	}
+	return NewRing(append(r.Nodes(), node))
+}
+
+func NewRing(nodes []string) *Ring {
+	r := &Ring{nodes: append([]string(nil), nodes...)}
+	for _, node := range nodes {
+		for v := 0; v < VIRTUAL_NODES; v++ {
+			r.points = append(r.points, point{hash(fmt.Sprintf("%s#%d", node, v)), node})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			return r.points[i].node < r.points[j].node
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
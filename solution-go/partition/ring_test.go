@@ -0,0 +1,73 @@
+package partition
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+)
+
+func TestRing(t *testing.T) {
+	seats := make([]inventory.Seat, 10000)
+	for i := range seats {
+		seats[i] = inventory.Seat(fmt.Sprintf("S%d", i))
+	}
+
+	t.Run("Spreads seats over every node", func(t *testing.T) {
+		ring := NewRing([]string{"n1", "n2", "n3"})
+		owned := map[string]int{}
+		for _, seat := range seats {
+			owned[ring.Owner(seat)]++
+		}
+		for _, node := range ring.Nodes() {
+			if owned[node] < len(seats)/6 {
+				t.Errorf("Expected node [%s] to own about a third of the seats, owns %v", node, owned)
+			}
+		}
+	})
+
+	t.Run("Gives the same seats to the same nodes whatever order they are listed in", func(t *testing.T) {
+		ring, reordered := NewRing([]string{"n1", "n2", "n3"}), NewRing([]string{"n3", "n1", "n2"})
+		for _, seat := range seats {
+			if ring.Owner(seat) != reordered.Owner(seat) {
+				t.Fatalf("Expected seat [%s] to belong to [%s], belongs to [%s]", seat, ring.Owner(seat), reordered.Owner(seat))
+			}
+		}
+	})
+
+	t.Run("Only moves seats to the node that joins", func(t *testing.T) {
+		ring := NewRing([]string{"n1", "n2", "n3"})
+		joined := ring.With("n4")
+		moved := 0
+		for _, seat := range seats {
+			if before, after := ring.Owner(seat), joined.Owner(seat); before != after {
+				moved++
+				if after != "n4" {
+					t.Fatalf("Expected seat [%s] to stay with [%s] or move to [n4], moved to [%s]", seat, before, after)
+				}
+			}
+		}
+		if moved < len(seats)/8 || moved > len(seats)/2 {
+			t.Errorf("Expected about a quarter of the seats to move, [%d] of [%d] did", moved, len(seats))
+		}
+	})
+	t.Run("Places seats where every release so far did", func(t *testing.T) {
+		//Changing where seats go would lose them on running clusters, nothing hands them over
+		ring := NewRing([]string{"10.0.0.1:8199", "10.0.0.2:8199", "10.0.0.3:8199"})
+		expected := map[inventory.Seat]string{"A1": "10.0.0.2:8199", "B7": "10.0.0.1:8199", "C12": "10.0.0.2:8199", "Z99": "10.0.0.3:8199"}
+		for seat, owner := range expected {
+			if ring.Owner(seat) != owner {
+				t.Errorf("Expected seat [%s] to belong to [%s], belongs to [%s]", seat, owner, ring.Owner(seat))
+			}
+		}
+	})
+
+	t.Run("Keeps an event's seats together", func(t *testing.T) {
+		ring := NewRing([]string{"n1", "n2", "n3"})
+		for _, seat := range seats {
+			if owner := ring.Owner("EVT1/" + seat); owner != ring.Owner(inventory.EventRecord("EVT1")) {
+				t.Fatalf("Expected seat [%s] of [EVT1] to be with the event on [%s], it is on [%s]", seat, ring.Owner(inventory.EventRecord("EVT1")), owner)
+			}
+		}
+	})
+}
@@ -0,0 +1,492 @@
+package partition
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/protocol"
+)
+
+const (
+	DEFAULT_FORWARD_TIMEOUT = 5 * time.Second
+	// MAX_IDLE_CONNECTIONS is how many connections to each node are kept open between commands
+	MAX_IDLE_CONNECTIONS = 16
+	// MAX_JOINED_ATTEMPTS is how many times nodes can refuse JOINED before joining gives up, while none took it
+	MAX_JOINED_ATTEMPTS   = 3
+	JOINED_RETRY_INTERVAL = 100 * time.Millisecond
+)
+
+// peerConn is a connection to another node's peer address, speaking the newest protocol with extended errors
+type peerConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Router knows which node owns each seat, runs commands for the seats this node owns and forwards the rest.
+// Nodes are named after their peer address, where commands they are forwarded are served to whoever AUTHs
+// with the secret every node shares.
+type Router struct {
+	self          string
+	secret        string
+	seatInventory *inventory.Inventory
+	logger        *logging.Logger
+	timeout       time.Duration
+
+	// lock is held for reading while commands run here, seats are only handed over once none are
+	lock sync.RWMutex
+	ring *Ring
+	// offered are the seats each joining node was handed, they stay here until it says it took them
+	offered map[string][]inventory.SeatListing
+
+	idleLock sync.Mutex
+	idle     map[string][]*peerConn
+}
+
+// Route forwards the command to the nodes owning its seats, returning their response. When the command is
+// for this node it returns a function the caller calls once it ran the command, so seats aren't handed
+// over halfway through.
+func (r *Router) Route(command protocol.Command, argument inventory.Seat, line string) (func(), string, error) {
+	var seats []inventory.Seat
+	switch command {
+	case protocol.RESERVE, protocol.BUY:
+		seat, _ := protocol.ParseMutation(argument)
+		seats = []inventory.Seat{seat}
+	case protocol.CAS:
+		seat, _, _, _, err := protocol.ParseCompareAndSet(argument)
+		if err != nil {
+			return nil, "", err
+		}
+		seats = []inventory.Seat{seat}
+	case protocol.REFUND:
+		seat, _, _, _, err := protocol.ParseRefund(argument)
+		if err != nil {
+			return nil, "", err
+		}
+		seats = []inventory.Seat{seat}
+	case protocol.HISTORY:
+		seat, _ := protocol.ParseHistory(argument)
+		seats = []inventory.Seat{seat}
+	case protocol.QUERY:
+		var err error
+		if seats, err = protocol.ParseSeats(argument); err != nil {
+			return nil, "", err
+		}
+	case protocol.CREATE_EVENT, protocol.CLOSE_EVENT, protocol.ARCHIVE_EVENT:
+		event, _ := protocol.ParseEventAdmin(argument)
+		seats = []inventory.Seat{inventory.EventRecord(event)}
+	case protocol.LIST, protocol.SUBSCRIBE:
+		prefix, err := listedPrefix(command, argument)
+		if err != nil {
+			return nil, "", err
+		}
+		//Seats are hashed, every node has some of any prefix but an event's, which one node has all of
+		event, inEvent := inventory.EventOf(prefix)
+		if len(r.Nodes()) == 1 {
+			return func() {}, "", nil
+		}
+		if !inEvent {
+			return nil, "", inventory.NewKindError(protocol.ErrCrossPartition, "[%s] covers seats of every node, send it to each node's peer address instead", command)
+		}
+		owner := r.Owner(inventory.EventRecord(event))
+		if owner == r.self {
+			//Subscriptions stream until the client hangs up, they can't hold up handing seats over
+			return func() {}, "", nil
+		}
+		if command == protocol.SUBSCRIBE {
+			return nil, "", inventory.NewKindError(protocol.ErrCrossPartition, "seats of event [%s] are on node [%s], subscribe at its peer address instead", event, owner)
+		}
+		seats = []inventory.Seat{inventory.EventRecord(event)}
+	default:
+		return func() {}, "", nil
+	}
+
+	r.lock.RLock()
+	owners := map[string][]int{}
+	for i, seat := range seats {
+		owner := r.ring.Owner(seat)
+		owners[owner] = append(owners[owner], i)
+	}
+	if _, local := owners[r.self]; local && len(owners) == 1 {
+		return r.lock.RUnlock, "", nil
+	}
+	if len(owners) == 1 {
+		owner := r.ring.Owner(seats[0])
+		r.lock.RUnlock()
+		response, err := r.forward(owner, line, command == protocol.HISTORY || command == protocol.LIST)
+		return nil, response, err
+	}
+	response, err := r.query(seats, owners)
+	return nil, response, err
+}
+
+func listedPrefix(command protocol.Command, argument inventory.Seat) (inventory.Seat, error) {
+	if command == protocol.LIST {
+		options, err := protocol.ParseListOptions(argument)
+		return inventory.Seat(options.Prefix), err
+	}
+	options, err := protocol.ParseSubscribeOptions(argument)
+	return inventory.Seat(options.Prefix), err
+}
+
+// query asks each node about the seats it owns and puts the answers back in the order they were asked
+// for. Callers hold the lock for reading, it is let go before asking the other nodes.
+func (r *Router) query(seats []inventory.Seat, owners map[string][]int) (string, error) {
+	statuses := make([]inventory.SeatStatus, len(seats))
+	if positions, local := owners[r.self]; local {
+		localSeats := make([]inventory.Seat, len(positions))
+		for i, position := range positions {
+			localSeats[i] = seats[position]
+		}
+		localStatuses, err := r.seatInventory.GetMany(localSeats)
+		if err != nil {
+			r.lock.RUnlock()
+			return "", err
+		}
+		for i, position := range positions {
+			statuses[position] = localStatuses[i]
+		}
+	}
+	r.lock.RUnlock()
+
+	for owner, positions := range owners {
+		if owner == r.self {
+			continue
+		}
+		ownedSeats := make([]string, len(positions))
+		for i, position := range positions {
+			ownedSeats[i] = string(seats[position])
+		}
+		response, err := r.forward(owner, fmt.Sprintf("%s: %s", protocol.QUERY, strings.Join(ownedSeats, ",")), false)
+		if err != nil {
+			return "", err
+		}
+		split := strings.Split(response, ",")
+		if len(split) != len(positions) {
+			return "", fmt.Errorf("node [%s] answered [%d] statuses for [%d] seats", owner, len(split), len(positions))
+		}
+		for i, position := range positions {
+			statuses[position] = inventory.SeatStatus(split[i])
+		}
+	}
+	return protocol.FormatStatuses(statuses), nil
+}
+
+// forward sends the line to the node and returns its response, reading up to END if it is many lines
+func (r *Router) forward(node string, line string, manyLines bool) (string, error) {
+	peer, err := r.checkout(node)
+	if err != nil {
+		return "", inventory.NewKindError(inventory.ErrNotApplied, "could not reach node [%s]: %v", node, err)
+	}
+
+	peer.conn.SetDeadline(time.Now().Add(r.timeout))
+	var lines []string
+	_, err = fmt.Fprintf(peer.conn, "%s\n", line)
+	for err == nil {
+		var response string
+		response, err = peer.reader.ReadString('\n')
+		response = strings.TrimSpace(response)
+		if err != nil {
+			break
+		}
+		if response == protocol.FAIL || strings.HasPrefix(response, protocol.FAIL+" ") {
+			r.checkin(node, peer)
+			return "", protocol.ParseFailure(response)
+		}
+		lines = append(lines, response)
+		if !manyLines || response == protocol.END || strings.HasPrefix(response, protocol.END+" ") {
+			r.checkin(node, peer)
+			return strings.Join(lines, "\n"), nil
+		}
+	}
+
+	peer.conn.Close()
+	return "", inventory.NewKindError(inventory.ErrOutcomeUnknown, "lost node [%s] while it ran [%s]: %v", node, line, err)
+}
+
+func (r *Router) checkout(node string) (*peerConn, error) {
+	r.idleLock.Lock()
+	if idle := r.idle[node]; len(idle) > 0 {
+		peer := idle[len(idle)-1]
+		r.idle[node] = idle[:len(idle)-1]
+		r.idleLock.Unlock()
+		return peer, nil
+	}
+	r.idleLock.Unlock()
+
+	conn, err := net.DialTimeout("tcp", node, r.timeout)
+	if err != nil {
+		return nil, err
+	}
+	peer := &peerConn{conn, bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(r.timeout))
+	fmt.Fprintf(conn, "%s: %d\n", protocol.HELLO, protocol.LatestProtocolVersion().Number)
+	if hello, err := peer.reader.ReadString('\n'); err != nil || !strings.HasPrefix(hello, protocol.OK) {
+		conn.Close()
+		return nil, fmt.Errorf("node [%s] didn't say hello back: [%s] %v", node, strings.TrimSpace(hello), err)
+	}
+	fmt.Fprintf(conn, "%s: %s\n", protocol.AUTH, r.secret)
+	if auth, err := peer.reader.ReadString('\n'); err != nil || strings.TrimSpace(auth) != protocol.OK {
+		conn.Close()
+		return nil, fmt.Errorf("node [%s] didn't take the peer secret: [%s] %v", node, strings.TrimSpace(auth), err)
+	}
+	return peer, nil
+}
+
+func (r *Router) checkin(node string, peer *peerConn) {
+	r.idleLock.Lock()
+	defer r.idleLock.Unlock()
+	if len(r.idle[node]) >= MAX_IDLE_CONNECTIONS {
+		peer.conn.Close()
+		return
+	}
+	r.idle[node] = append(r.idle[node], peer)
+}
+
+// Nodes are every node seats are spread over
+func (r *Router) Nodes() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.ring.Nodes()
+}
+
+// Owner is the node the seat belongs to
+func (r *Router) Owner(seat inventory.Seat) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.ring.Owner(seat)
+}
+
+// Offer returns the seats the node would own if it joined, leaving them here. They are only released once
+// the node took them and is Admitted, so a node that never got them doesn't lose them.
+func (r *Router) Offer(node string) ([]inventory.SeatListing, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ring := r.ring.With(node)
+	offered, err := r.seatInventory.Pick(func(seat inventory.Seat) bool { return ring.Owner(seat) == node })
+	if err != nil {
+		return nil, err
+	}
+	r.offered[node] = offered
+	return offered, nil
+}
+
+// Admit adds a node that took the seats it was Offered to the ring, moving them back to FREE here. Commands
+// for those seats are forwarded to it from then on. Seats that moved since they were offered would be lost,
+// the node must JOIN again then.
+func (r *Router) Admit(node string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	offered, found := r.offered[node]
+	if !found {
+		if r.ring.Has(node) {
+			//It already was, the answer got lost
+			return nil
+		}
+		return inventory.NewKindError(inventory.ErrNotApplied, "node [%s] was offered no seats, it must JOIN first", node)
+	}
+	delete(r.offered, node)
+
+	ring := r.ring.With(node)
+	picked := func(seat inventory.Seat) bool { return ring.Owner(seat) == node }
+	current, err := r.seatInventory.Pick(picked)
+	if err != nil {
+		return err
+	}
+	if !sameListings(offered, current) {
+		return inventory.NewKindError(inventory.ErrNotApplied, "seats offered to node [%s] moved before it took them, it must JOIN again", node)
+	}
+	released, err := r.seatInventory.Release(picked, inventory.Actor{RemoteAddress: node})
+	if err != nil {
+		return err
+	}
+	if !r.ring.Has(node) {
+		r.logger.Infof("Node [%s] joined, handed it [%d] seats", node, len(released))
+	}
+	r.ring = ring
+	return nil
+}
+
+func sameListings(a []inventory.SeatListing, b []inventory.SeatListing) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Withdraw forgets the seats Offered to a node that couldn't take them all, they stay here. Nodes already
+// Admitted can't withdraw, their seats were released.
+func (r *Router) Withdraw(node string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, found := r.offered[node]; !found && r.ring.Has(node) {
+		return inventory.NewKindError(inventory.ErrNotApplied, "node [%s] already took its seats", node)
+	}
+	delete(r.offered, node)
+	return nil
+}
+
+// Join asks every other node to offer this one its seats and takes them all over before telling any node it
+// did so it releases them. If it couldn't take them all the nodes keep their seats. It must be done before
+// serving anything, commands forwarded meanwhile wait in the listener's queue.
+func (r *Router) Join() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var contacted []string
+	offers := map[string][]inventory.SeatListing{}
+	for _, node := range r.ring.Nodes() {
+		if node == r.self {
+			continue
+		}
+		contacted = append(contacted, node)
+		offered, err := r.takeOffer(node, nil)
+		offers[node] = offered
+		if err != nil {
+			r.abortJoin(contacted, offers)
+			return fmt.Errorf("could not join node [%s]: %v", node, err)
+		}
+	}
+
+	admitted := 0
+	for _, node := range contacted {
+		refused, maybeAdmitted := 0, false
+		for {
+			_, err := r.forward(node, fmt.Sprintf("%s: %s", protocol.JOINED, r.self), false)
+			if err == nil {
+				break
+			}
+			var remoteErr *protocol.RemoteError
+			if errors.As(err, &remoteErr) {
+				//It answered, it didn't take it, seats might have moved since they were offered
+				refused, maybeAdmitted = refused+1, false
+			} else if errors.Is(err, inventory.ErrOutcomeUnknown) {
+				maybeAdmitted = true
+			} else if !maybeAdmitted {
+				refused++
+			}
+			//Only nodes that surely kept their seats can be asked to keep them, once a node released its seats
+			//this one has the only copy and can't give up
+			if admitted == 0 && !maybeAdmitted && refused >= MAX_JOINED_ATTEMPTS {
+				r.abortJoin(contacted, offers)
+				return fmt.Errorf("node [%s] kept its seats: %v", node, err)
+			}
+			r.logger.Errorf("Node [%s] didn't take that this node took its seats, trying again: %v", node, err)
+			time.Sleep(JOINED_RETRY_INTERVAL)
+			if remoteErr != nil {
+				offered, err := r.takeOffer(node, offers[node])
+				offers[node] = offered
+				if err != nil {
+					r.logger.Errorf("Could not take the seats node [%s] offered again: %v", node, err)
+				}
+			}
+		}
+		admitted++
+		r.logger.Infof("Joined node [%s], took over [%d] seats", node, len(offers[node]))
+	}
+	return nil
+}
+
+// takeOffer asks the node to offer this one its seats and takes them over, returning the seats offered even
+// if it couldn't take them all. Seats a previous offer had that this one doesn't went back to FREE there.
+func (r *Router) takeOffer(node string, previous []inventory.SeatListing) ([]inventory.SeatListing, error) {
+	response, err := r.forward(node, fmt.Sprintf("%s: %s", protocol.JOIN, r.self), true)
+	if err != nil {
+		return previous, err
+	}
+
+	var offered []inventory.SeatListing
+	lines := strings.Split(response, "\n")
+	for _, line := range lines[:len(lines)-1] {
+		split := strings.Split(line, " ")
+		if len(split) != 2 || !inventory.IsStoredStatus(inventory.Seat(split[0]), split[1]) {
+			return previous, fmt.Errorf("node [%s] handed over an invalid seat [%s]", node, line)
+		}
+		offered = append(offered, inventory.SeatListing{Seat: inventory.Seat(split[0]), Status: inventory.SeatStatus(split[1])})
+	}
+
+	actor := inventory.Actor{RemoteAddress: node}
+	stillOffered := make(map[inventory.Seat]bool, len(offered))
+	for _, listing := range offered {
+		stillOffered[listing.Seat] = true
+	}
+	for _, listing := range previous {
+		if !stillOffered[listing.Seat] {
+			if err := r.seatInventory.Replicate(listing.Seat, inventory.FREE, actor); err != nil {
+				return append(offered, previous...), err
+			}
+		}
+	}
+	for _, listing := range offered {
+		if err := r.seatInventory.Replicate(listing.Seat, listing.Status, actor); err != nil {
+			return append(offered, previous...), err
+		}
+	}
+	return offered, nil
+}
+
+// abortJoin tells the nodes contacted to keep the seats they offered and moves this node's copies back to
+// FREE. Nodes it can't tell keep them anyway, the offer is only released once they are told JOINED.
+func (r *Router) abortJoin(contacted []string, offers map[string][]inventory.SeatListing) {
+	for _, node := range contacted {
+		if _, err := r.forward(node, fmt.Sprintf("%s: %s", protocol.ABORT_JOIN, r.self), false); err != nil {
+			r.logger.Errorf("Could not tell node [%s] to keep its seats: %v", node, err)
+		}
+	}
+
+	taken := map[inventory.Seat]bool{}
+	for _, offered := range offers {
+		for _, listing := range offered {
+			taken[listing.Seat] = true
+		}
+	}
+	picked := func(seat inventory.Seat) bool { return taken[seat] }
+	if _, err := r.seatInventory.Release(picked, inventory.Actor{RemoteAddress: r.self}); err != nil {
+		r.logger.Errorf("Could not free the seats this node took before joining failed: %v", err)
+	}
+}
+
+// Close hangs up the connections kept open to other nodes
+func (r *Router) Close() {
+	r.idleLock.Lock()
+	defer r.idleLock.Unlock()
+	for node, idle := range r.idle {
+		for _, peer := range idle {
+			peer.conn.Close()
+		}
+		delete(r.idle, node)
+	}
+}
+
+// NewRouter spreads seats over the nodes, self among them, keeping the ones this node owns in the inventory.
+// It reaches the other nodes with the secret they all share.
+func NewRouter(self string, nodes []string, secret string, seatInventory *inventory.Inventory, logger *logging.Logger) *Router {
+	ring := NewRing(nodes)
+	if !ring.Has(self) {
+		ring = ring.With(self)
+	}
+	return &Router{
+		self:          self,
+		secret:        secret,
+		seatInventory: seatInventory,
+		logger:        logger,
+		timeout:       DEFAULT_FORWARD_TIMEOUT,
+		ring:          ring,
+		offered:       map[string][]inventory.SeatListing{},
+		idle:          map[string][]*peerConn{},
+	}
+}
@@ -0,0 +1,487 @@
+// Package protocol parses the lines clients send, one "<COMMAND>: <argument>" message per line, and
+// formats the responses the server sends back.
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+)
+
+// Command is the verb a message starts with
+type Command string
+
+const (
+	RESERVE   = "RESERVE"
+	BUY       = "BUY"
+	QUERY     = "QUERY"
+	LIST      = "LIST"
+	STATS     = "STATS"
+	SUBSCRIBE = "SUBSCRIBE"
+	HISTORY   = "HISTORY"
+	CAS       = "CAS"
+	REFUND    = "REFUND"
+	ERRORS    = "ERRORS"
+	HELLO     = "HELLO"
+	AUTH      = "AUTH"
+	PROMOTE   = "PROMOTE"
+	JOIN      = "JOIN"
+	JOINED    = "JOINED"
+	USE       = "USE"
+	// Events are created, closed and archived with the commands of the same name as inventory's
+	CREATE_EVENT  = "CREATE_EVENT"
+	CLOSE_EVENT   = "CLOSE_EVENT"
+	ARCHIVE_EVENT = "ARCHIVE_EVENT"
+	EVENT         = "EVENT"
+	LAGGED        = "LAGGED"
+	OK            = "OK"
+	FAIL          = "FAIL"
+	END           = "END"
+	// ABORT_JOIN is sent by nodes that couldn't take every seat JOIN offered them, the nodes offering keep them
+	ABORT_JOIN = "ABORT_JOIN"
+)
+
+// Clients choose with ERRORS how failures are answered, LEGACY_ERRORS is a bare FAIL and EXTENDED_ERRORS
+// adds a code and a message
+const (
+	LEGACY_ERRORS   = "legacy"
+	EXTENDED_ERRORS = "extended"
+)
+
+const (
+	MAX_SEATS_PER_QUERY = 1000
+	DEFAULT_LIST_LIMIT  = 100
+	MAX_LIST_LIMIT      = 1000
+)
+
+// MAX_SEAT_SIZE is the most bytes a seat can have, named with its event. Histories kept in a B-tree key
+// each entry by the seat and HISTORY_KEY_OVERHEAD more bytes, which must all fit in a key.
+const MAX_SEAT_SIZE = inventory.MAX_KEY_SIZE - inventory.HISTORY_KEY_OVERHEAD
+
+// MAX_REASON_SIZE is the most bytes a refund reason can have. Histories kept in a B-tree need each entry
+// to fit in a value, even for the longest seats with every character of the reason escaped.
+const MAX_REASON_SIZE = 200
+
+var (
+	ErrInvalidMessage  = errors.New("invalid message")
+	ErrUnknownCommand  = errors.New("unknown command")
+	ErrUnauthenticated = errors.New("unknown credentials")
+	ErrNotReplica      = errors.New("not a replica")
+	ErrNotPartitioned  = errors.New("not partitioned")
+	ErrCrossPartition  = errors.New("seats are in different partitions")
+)
+
+var messagePattern = regexp.MustCompile(`^(\w+): (.+)$`)
+
+var singleSeatPattern = regexp.MustCompile(`^\w+$`)
+
+// seat is a seat's name, with the event it is in before a slash unless it is in none
+const seat = `\w+(/\w+)?`
+
+var mutationPattern = regexp.MustCompile(`^` + seat + `( key=\w+)?$`)
+
+var optionsPattern = regexp.MustCompile(`^\w+=[\w/]+( \w+=[\w/]+)*$`)
+
+var eventAdminPattern = regexp.MustCompile(`^\w+( \w+)?$`)
+
+var argumentPatterns = map[Command]*regexp.Regexp{
+	RESERVE:   mutationPattern,
+	BUY:       mutationPattern,
+	QUERY:     regexp.MustCompile(`^` + seat + `(,` + seat + `)*$`),
+	LIST:      optionsPattern,
+	STATS:     singleSeatPattern,
+	SUBSCRIBE: optionsPattern,
+	HISTORY:   regexp.MustCompile(`^` + seat + `( \w+)?$`),
+	CAS:       regexp.MustCompile(`^` + seat + ` \w+ \w+( \w+)?$`),
+	REFUND:    regexp.MustCompile(`^` + seat + ` \w+ \w+ \S.*$`),
+	HELLO:     regexp.MustCompile(`^\d{1,9}$`),
+	AUTH:      regexp.MustCompile(`^\S+$`),
+	PROMOTE:   singleSeatPattern,
+	JOIN:      regexp.MustCompile(`^\S+( \w+)?$`),
+	JOINED:    regexp.MustCompile(`^\S+( \w+)?$`),
+	USE:       singleSeatPattern,
+
+	CREATE_EVENT:  eventAdminPattern,
+	CLOSE_EVENT:   eventAdminPattern,
+	ARCHIVE_EVENT: eventAdminPattern,
+	ABORT_JOIN:    regexp.MustCompile(`^\S+( \w+)?$`),
+	ERRORS:        regexp.MustCompile(`^(` + LEGACY_ERRORS + `|` + EXTENDED_ERRORS + `)$`),
+}
+
+// ListOptions are what a LIST asks for, parsed from its key=value argument
+type ListOptions struct {
+	Prefix string
+	Status inventory.SeatStatus
+	Cursor inventory.Seat
+	Limit  int
+}
+
+// IsValidSecret tells whether admin commands can carry the secret, they only take a single word
+func IsValidSecret(secret string) bool {
+	return singleSeatPattern.MatchString(secret)
+}
+
+// ParseMessage splits a line into its command and argument, checking the argument fits the command
+func ParseMessage(line string) (Command, inventory.Seat, error) {
+	matches := messagePattern.FindStringSubmatch(line)
+	if matches == nil {
+		return "", "", inventory.NewKindError(ErrInvalidMessage, "invalid message [%s]", line)
+	}
+
+	command := Command(matches[1])
+	seat := inventory.Seat(matches[2])
+
+	argumentPattern, known := argumentPatterns[command]
+	if !known {
+		return "", "", inventory.NewKindError(ErrUnknownCommand, "invalid command [%s] in message [%s]", command, line)
+	}
+
+	if !argumentPattern.MatchString(string(seat)) {
+		return "", "", inventory.NewKindError(ErrInvalidMessage, "invalid message [%s]", line)
+	}
+
+	return command, seat, checkSeatSizes(command, seat)
+}
+
+// checkSeatSizes refuses arguments naming seats, or events, the history couldn't keep
+func checkSeatSizes(command Command, argument inventory.Seat) error {
+	var seats []string
+	switch command {
+	case RESERVE, BUY, HISTORY, CAS, REFUND:
+		seats = strings.SplitN(string(argument), " ", 2)[:1]
+	case QUERY:
+		seats = strings.Split(string(argument), ",")
+	case USE, CREATE_EVENT, CLOSE_EVENT, ARCHIVE_EVENT:
+		event, _ := ParseEventAdmin(argument)
+		seats = []string{string(inventory.EventRecord(event))}
+	}
+
+	for _, seat := range seats {
+		if len(seat) > MAX_SEAT_SIZE {
+			return inventory.NewKindError(ErrInvalidMessage, "seats can't have more than [%d] bytes with their event, [%s] has [%d]", MAX_SEAT_SIZE, seat, len(seat))
+		}
+	}
+	return nil
+}
+
+// ParseMutation splits the seat a RESERVE or BUY applies to from its optional idempotency key
+func ParseMutation(argument inventory.Seat) (inventory.Seat, string) {
+	seatAndKey := strings.Split(string(argument), " key=")
+	if len(seatAndKey) == 1 {
+		return argument, ""
+	}
+	return inventory.Seat(seatAndKey[0]), seatAndKey[1]
+}
+
+// ParseCompareAndSet reads the seat, the status it is expected to be in, the one it should go to and the
+// admin secret, which only privileged moves by clients that aren't admins need
+func ParseCompareAndSet(argument inventory.Seat) (inventory.Seat, inventory.SeatStatus, inventory.SeatStatus, string, error) {
+	split := strings.Split(string(argument), " ")
+	seat, from, to := inventory.Seat(split[0]), split[1], split[2]
+	secret := ""
+	if len(split) > 3 {
+		secret = split[3]
+	}
+
+	for _, status := range []string{from, to} {
+		if !inventory.IsStatus(status) {
+			return "", "", "", "", inventory.NewKindError(ErrInvalidMessage, "invalid status [%s] for seat [%s]", status, seat)
+		}
+	}
+	return seat, inventory.SeatStatus(from), inventory.SeatStatus(to), secret, nil
+}
+
+// ParseRefund reads the seat, the status it goes back to, the admin secret and the free text reason
+func ParseRefund(argument inventory.Seat) (inventory.Seat, inventory.SeatStatus, string, string, error) {
+	split := strings.SplitN(string(argument), " ", 4)
+	seat, to, secret, reason := inventory.Seat(split[0]), inventory.SeatStatus(split[1]), split[2], split[3]
+
+	if to != inventory.FREE && to != inventory.QUARANTINED {
+		return "", "", "", "", inventory.NewKindError(ErrInvalidMessage, "seat [%s] can only be refunded to [%s] or [%s], not [%s]", seat, inventory.FREE, inventory.QUARANTINED, to)
+	}
+	//Checked before anything is moved, the history couldn't keep longer ones
+	if len(reason) > MAX_REASON_SIZE {
+		return "", "", "", "", inventory.NewKindError(ErrInvalidMessage, "refund reasons can't have more than [%d] bytes, the one for seat [%s] has [%d]", MAX_REASON_SIZE, seat, len(reason))
+	}
+	if !utf8.ValidString(reason) || strings.IndexFunc(reason, unicode.IsControl) >= 0 {
+		return "", "", "", "", inventory.NewKindError(ErrInvalidMessage, "the refund reason for seat [%s] must be printable UTF-8", seat)
+	}
+	return seat, to, secret, reason, nil
+}
+
+// ParseHistory reads the seat and the admin secret, which admins don't need
+func ParseHistory(argument inventory.Seat) (inventory.Seat, string) {
+	split := strings.SplitN(string(argument), " ", 2)
+	if len(split) == 1 {
+		return inventory.Seat(split[0]), ""
+	}
+	return inventory.Seat(split[0]), split[1]
+}
+
+// ParseSeats splits the comma separated seats a bulk QUERY asks about
+func ParseSeats(argument inventory.Seat) ([]inventory.Seat, error) {
+	split := strings.Split(string(argument), ",")
+	if len(split) > MAX_SEATS_PER_QUERY {
+		return nil, inventory.NewKindError(ErrInvalidMessage, "can query at most [%d] seats at once, got [%d]", MAX_SEATS_PER_QUERY, len(split))
+	}
+
+	seats := make([]inventory.Seat, len(split))
+	for i, seat := range split {
+		seats[i] = inventory.Seat(seat)
+	}
+	return seats, nil
+}
+
+// ParseListOptions reads the prefix, status, cursor and limit of a LIST
+func ParseListOptions(argument inventory.Seat) (ListOptions, error) {
+	options := ListOptions{Limit: DEFAULT_LIST_LIMIT}
+	for _, pair := range strings.Split(string(argument), " ") {
+		keyAndValue := strings.Split(pair, "=")
+		key, value := keyAndValue[0], keyAndValue[1]
+
+		switch key {
+		case "prefix":
+			options.Prefix = value
+		case "status":
+			if !inventory.IsStatus(value) {
+				return ListOptions{}, inventory.NewKindError(ErrInvalidMessage, "invalid status [%s] to list", value)
+			}
+			options.Status = inventory.SeatStatus(value)
+		case "cursor":
+			options.Cursor = inventory.Seat(value)
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > MAX_LIST_LIMIT {
+				return ListOptions{}, inventory.NewKindError(ErrInvalidMessage, "limit must be between 1 and %d, got [%s]", MAX_LIST_LIMIT, value)
+			}
+			options.Limit = limit
+		default:
+			return ListOptions{}, inventory.NewKindError(ErrInvalidMessage, "unknown option [%s] to list", key)
+		}
+	}
+	return options, nil
+}
+
+// SubscribeOptions are what a SUBSCRIBE asks for, parsed from its key=value argument
+type SubscribeOptions struct {
+	Prefix string
+	After  int64
+}
+
+// ParseSubscribeOptions reads the prefix and sequence to start after of a SUBSCRIBE
+func ParseSubscribeOptions(argument inventory.Seat) (SubscribeOptions, error) {
+	options := SubscribeOptions{After: inventory.SUBSCRIBE_FROM_LATEST}
+	for _, pair := range strings.Split(string(argument), " ") {
+		keyAndValue := strings.Split(pair, "=")
+		key, value := keyAndValue[0], keyAndValue[1]
+
+		switch key {
+		case "prefix":
+			options.Prefix = value
+		case "after":
+			if value == "latest" {
+				options.After = inventory.SUBSCRIBE_FROM_LATEST
+				continue
+			}
+			after, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || after < 0 {
+				return SubscribeOptions{}, inventory.NewKindError(ErrInvalidMessage, "after must be a sequence number or [latest], got [%s]", value)
+			}
+			options.After = after
+		default:
+			return SubscribeOptions{}, inventory.NewKindError(ErrInvalidMessage, "unknown option [%s] to subscribe", key)
+		}
+	}
+	return options, nil
+}
+
+// FormatTransition writes a transition as an EVENT line for subscribers
+func FormatTransition(t inventory.Transition) string {
+	return fmt.Sprintf("%s %d %s %s %s", EVENT, t.Sequence, t.Seat, t.From, t.To)
+}
+
+// FormatHistory writes one line per transition of a seat, oldest first, followed by END
+func FormatHistory(entries []inventory.AuditEntry) string {
+	lines := make([]string, 0, len(entries)+1)
+	for _, e := range entries {
+		line := fmt.Sprintf("%s %s %s %d %s", e.Time.UTC().Format(time.RFC3339Nano), e.From, e.To, e.ConnectionID, e.RemoteAddress)
+		if e.Reason != "" {
+			line += " " + e.Reason
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, END)
+	return strings.Join(lines, "\n")
+}
+
+// ErrorCode names the kind of error a command failed with, seats in the wrong status are named after the
+// status they are in so clients can tell a seat that may free up from one that is gone
+func ErrorCode(err error) string {
+	var transitionErr *inventory.TransitionError
+	var remoteErr *RemoteError
+	switch {
+	case errors.Is(err, inventory.ErrWrongStatus) && errors.As(err, &transitionErr):
+		return "SEAT_" + string(transitionErr.Status)
+	case errors.Is(err, inventory.ErrForbiddenTransition):
+		return "FORBIDDEN_TRANSITION"
+	case errors.Is(err, inventory.ErrNotPrivileged):
+		return "NOT_PRIVILEGED"
+	case errors.Is(err, ErrInvalidMessage):
+		return "INVALID_MESSAGE"
+	case errors.Is(err, ErrUnknownCommand):
+		return "UNKNOWN_COMMAND"
+	case errors.Is(err, ErrUnauthenticated):
+		return "UNAUTHENTICATED"
+	case errors.Is(err, inventory.ErrKeyReused):
+		return "KEY_REUSED"
+	case errors.Is(err, inventory.ErrCannotResume):
+		return "CANNOT_RESUME"
+	case errors.Is(err, inventory.ErrReadOnly):
+		return "READ_ONLY"
+	case errors.Is(err, ErrNotReplica):
+		return "NOT_REPLICA"
+	case errors.Is(err, inventory.ErrNotApplied):
+		return "NOT_APPLIED"
+	case errors.Is(err, inventory.ErrOutcomeUnknown):
+		return "OUTCOME_UNKNOWN"
+	case errors.Is(err, ErrNotPartitioned):
+		return "NOT_PARTITIONED"
+	case errors.Is(err, ErrCrossPartition):
+		return "CROSS_PARTITION"
+	case errors.Is(err, inventory.ErrUnknownEvent):
+		return "UNKNOWN_EVENT"
+	case errors.Is(err, inventory.ErrEventExists):
+		return "EVENT_EXISTS"
+	case errors.Is(err, inventory.ErrEventClosed):
+		return "EVENT_CLOSED"
+	case errors.Is(err, inventory.ErrEventArchived):
+		return "EVENT_ARCHIVED"
+	case errors.As(err, &remoteErr):
+		return remoteErr.Code
+	default:
+		return "ERROR"
+	}
+}
+
+// RemoteError is a failure another server answered with, relayed as it was
+type RemoteError struct {
+	Code    string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// ParseFailure reads back a failure written by FormatFailure, bare FAILs have no code or message
+func ParseFailure(line string) error {
+	split := strings.SplitN(line, " ", 3)
+	remoteErr := &RemoteError{Code: "ERROR", Message: "failed without saying why"}
+	if len(split) > 1 {
+		remoteErr.Code = split[1]
+	}
+	if len(split) > 2 {
+		remoteErr.Message = split[2]
+	}
+	return remoteErr
+}
+
+// ParseJoin reads the node joining and the admin secret of JOIN, and of the JOINED it sends once it took over
+// the seats it was handed or the ABORT_JOIN it sends when it couldn't. Nodes joining from the peer address don't
+// need the secret.
+func ParseJoin(argument inventory.Seat) (string, string) {
+	split := strings.SplitN(string(argument), " ", 2)
+	if len(split) == 1 {
+		return split[0], ""
+	}
+	return split[0], split[1]
+}
+
+// ParseEventAdmin reads the event an admin command is for and the admin secret, which admins don't need
+func ParseEventAdmin(argument inventory.Seat) (inventory.Event, string) {
+	split := strings.SplitN(string(argument), " ", 2)
+	if len(split) == 1 {
+		return inventory.Event(split[0]), ""
+	}
+	return inventory.Event(split[0]), split[1]
+}
+
+// Qualify names the seats an argument gives without an event as seats of the event, for connections that
+// said USE. Seats naming their event, and prefixes to list or subscribe to that do, are left alone. Seats
+// too long once named with the event are refused.
+func Qualify(command Command, argument inventory.Seat, event inventory.Event) (inventory.Seat, error) {
+	if event == "" {
+		return argument, nil
+	}
+	qualify := func(seat string) string {
+		if strings.Contains(seat, inventory.EVENT_SEPARATOR) {
+			return seat
+		}
+		return string(event) + inventory.EVENT_SEPARATOR + seat
+	}
+
+	switch command {
+	case RESERVE, BUY, HISTORY, CAS, REFUND:
+		split := strings.SplitN(string(argument), " ", 2)
+		split[0] = qualify(split[0])
+		qualified := inventory.Seat(strings.Join(split, " "))
+		return qualified, checkSeatSizes(command, qualified)
+	case QUERY:
+		split := strings.Split(string(argument), ",")
+		for i, seat := range split {
+			split[i] = qualify(seat)
+		}
+		qualified := inventory.Seat(strings.Join(split, ","))
+		return qualified, checkSeatSizes(command, qualified)
+	case LIST, SUBSCRIBE:
+		pairs := strings.Split(string(argument), " ")
+		for i, pair := range pairs {
+			if strings.HasPrefix(pair, "prefix=") {
+				pairs[i] = "prefix=" + qualify(strings.TrimPrefix(pair, "prefix="))
+				return inventory.Seat(strings.Join(pairs, " ")), nil
+			}
+		}
+		//Without a prefix the whole event is listed
+		return inventory.Seat(strings.Join(append(pairs, "prefix="+qualify("")), " ")), nil
+	}
+	return argument, nil
+}
+
+// FormatFailure answers a failed command, with its code and message only if the client asked for them
+func FormatFailure(err error, extended bool) string {
+	if !extended {
+		return FAIL
+	}
+	return fmt.Sprintf("%s %s %s", FAIL, ErrorCode(err), err)
+}
+
+// FormatStatuses answers a bulk QUERY with the statuses separated by commas
+func FormatStatuses(statuses []inventory.SeatStatus) string {
+	names := make([]string, len(statuses))
+	for i, status := range statuses {
+		names[i] = string(status)
+	}
+	return strings.Join(names, ",")
+}
+
+// FormatListing writes one line per seat followed by END and the cursor to resume from, if there is more
+func FormatListing(listings []inventory.SeatListing, cursor inventory.Seat) string {
+	lines := make([]string, 0, len(listings)+1)
+	for _, listing := range listings {
+		lines = append(lines, fmt.Sprintf("%s %s", listing.Seat, listing.Status))
+	}
+
+	if cursor == "" {
+		lines = append(lines, END)
+	} else {
+		lines = append(lines, fmt.Sprintf("%s %s", END, cursor))
+	}
+	return strings.Join(lines, "\n")
+}
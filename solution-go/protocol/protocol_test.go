@@ -0,0 +1,496 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+)
+
+func TestParseMessage(t *testing.T) {
+	t.Run("Parses valid messages", func(t *testing.T) {
+		expectations := map[string][]string{
+			"BUY: B0":                              {BUY, "B0"},
+			"RESERVE: A2342A":                      {RESERVE, "A2342A"},
+			"QUERY: 987423d":                       {QUERY, "987423d"},
+			"STATS: s3cr3t":                        {STATS, "s3cr3t"},
+			"QUERY: A1,B2,C3":                      {QUERY, "A1,B2,C3"},
+			"LIST: prefix=A1":                      {LIST, "prefix=A1"},
+			"SUBSCRIBE: after=3":                   {SUBSCRIBE, "after=3"},
+			"HISTORY: A1":                          {HISTORY, "A1"},
+			"HISTORY: A1 s3cr3t":                   {HISTORY, "A1 s3cr3t"},
+			"BUY: A1 key=k42":                      {BUY, "A1 key=k42"},
+			"CAS: A1 FREE RESERVED":                {CAS, "A1 FREE RESERVED"},
+			"CAS: A1 SOLD FREE s3cr3t":             {CAS, "A1 SOLD FREE s3cr3t"},
+			"REFUND: A1 FREE s3cr3t double booked": {REFUND, "A1 FREE s3cr3t double booked"},
+			"ERRORS: extended":                     {ERRORS, "extended"},
+			"HELLO: 2":                             {HELLO, "2"},
+			"AUTH: t0k3n-with.symbols":             {AUTH, "t0k3n-with.symbols"},
+			"JOIN: 10.0.0.4:8199 s3cr3t":           {JOIN, "10.0.0.4:8199 s3cr3t"},
+			"JOINED: 10.0.0.4:8199":                {JOINED, "10.0.0.4:8199"},
+			"RESERVE: EVT123/A1 key=k42":           {RESERVE, "EVT123/A1 key=k42"},
+			"QUERY: EVT123/A1,A1":                  {QUERY, "EVT123/A1,A1"},
+			"CAS: EVT123/A1 FREE RESERVED":         {CAS, "EVT123/A1 FREE RESERVED"},
+			"LIST: prefix=EVT123/A":                {LIST, "prefix=EVT123/A"},
+			"USE: EVT123":                          {USE, "EVT123"},
+			"CREATE_EVENT: EVT123 s3cr3t":          {CREATE_EVENT, "EVT123 s3cr3t"},
+			"ARCHIVE_EVENT: EVT123":                {ARCHIVE_EVENT, "EVT123"},
+		}
+
+		for message, expectedOutput := range expectations {
+			command, seat, err := ParseMessage(message)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if command != Command(expectedOutput[0]) || seat != inventory.Seat(expectedOutput[1]) {
+				t.Errorf("Expected message [%s] to parse into %v, got [%s][%s]", message, expectedOutput, command, seat)
+			}
+		}
+	})
+
+	t.Run("Rejects invalid messages", func(t *testing.T) {
+		invalidMessages := []string{
+			"BUY:B0",
+			"BUY: B0:",
+			"B:UY: B0:",
+			"RESERVE: ",
+			": 987423d",
+			"987423d",
+			"APRICOT: 987423d",
+			"RESERVE: A1,A2",
+			"RESERVE: A1 key=",
+			"RESERVE: A1 id=k42",
+			"QUERY: A1 key=k42",
+			"CAS: A1 FREE",
+			"CAS: A1 FREE RESERVED s3cr3t extra",
+			"REFUND: A1 FREE s3cr3t",
+			"ERRORS: EXTENDED",
+			"ERRORS: verbose",
+			"HELLO: two",
+			"HELLO: -1",
+			"AUTH: two words",
+			"REFUND: A1 FREE s3cr3t  ",
+			"BUY: A1,A2",
+			"QUERY: A1,",
+			"QUERY: ,A1",
+			"QUERY: A1,,A2",
+			"QUERY: A1, A2",
+			"LIST: A1",
+			"LIST: prefix=",
+			"LIST: prefix=A  limit=2",
+			"JOIN: 10.0.0.4:8199 two words",
+			"RESERVE: EVT123/",
+			"RESERVE: /A1",
+			"RESERVE: EVT123/A1/B2",
+			"USE: EVT123/A1",
+			"HISTORY: A1 two words",
+			"CLOSE_EVENT: EVT123/A1",
+			"CLOSE_EVENT: EVT123 two words",
+		}
+
+		for _, invalidMessage := range invalidMessages {
+			command, seat, err := ParseMessage(invalidMessage)
+			if err == nil {
+				t.Errorf("No error for invalid message [%v], got: [%s][%s]", invalidMessage, command, seat)
+			}
+		}
+	})
+}
+
+func TestIsValidSecret(t *testing.T) {
+	t.Run("Only takes secrets admin commands can carry", func(t *testing.T) {
+		for _, secret := range []string{"s3cr3t", "S3CR3T_2"} {
+			if !IsValidSecret(secret) {
+				t.Errorf("Expected secret [%s] to be valid", secret)
+			}
+		}
+		for _, secret := range []string{"", "s3cr-3t", "s3cr3t.v2", "two words"} {
+			if IsValidSecret(secret) {
+				t.Errorf("Expected secret [%s] to be invalid", secret)
+			}
+		}
+	})
+}
+
+func TestParseSeats(t *testing.T) {
+	t.Run("Splits bulk queries into seats", func(t *testing.T) {
+		seats, err := ParseSeats("A1,B2,C3")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(seats, []inventory.Seat{"A1", "B2", "C3"}) {
+			t.Errorf("Expected three seats, got %v", seats)
+		}
+	})
+
+	t.Run("Refuses to query too many seats at once", func(t *testing.T) {
+		tooMany := strings.TrimSuffix(strings.Repeat("A1,", MAX_SEATS_PER_QUERY+1), ",")
+		_, err := ParseSeats(inventory.Seat(tooMany))
+		if err == nil {
+			t.Errorf("Expected error when querying [%d] seats", MAX_SEATS_PER_QUERY+1)
+		}
+	})
+}
+
+func TestParseListOptions(t *testing.T) {
+	t.Run("Parses all options", func(t *testing.T) {
+		options, err := ParseListOptions("prefix=A status=SOLD cursor=A10 limit=5")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		expected := ListOptions{Prefix: "A", Status: inventory.SOLD, Cursor: "A10", Limit: 5}
+		if options != expected {
+			t.Errorf("Expected options %+v, got %+v", expected, options)
+		}
+	})
+
+	t.Run("Uses the default limit", func(t *testing.T) {
+		options, err := ParseListOptions("prefix=A")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if options.Limit != DEFAULT_LIST_LIMIT {
+			t.Errorf("Expected default limit [%d], got [%d]", DEFAULT_LIST_LIMIT, options.Limit)
+		}
+	})
+
+	t.Run("Rejects invalid options", func(t *testing.T) {
+		invalidOptions := []inventory.Seat{"status=GONE", "limit=0", "limit=1001", "limit=many", "colour=blue"}
+		for _, invalid := range invalidOptions {
+			options, err := ParseListOptions(invalid)
+			if err == nil {
+				t.Errorf("Expected error for options [%s], got %+v", invalid, options)
+			}
+		}
+	})
+}
+
+func TestFormatListing(t *testing.T) {
+	t.Run("Ends listings with the cursor to resume from", func(t *testing.T) {
+		listings := []inventory.SeatListing{{Seat: "A1", Status: inventory.SOLD}, {Seat: "A2", Status: inventory.RESERVED}}
+
+		expectations := map[inventory.Seat]string{
+			"":   "A1 SOLD\nA2 RESERVED\nEND",
+			"A2": "A1 SOLD\nA2 RESERVED\nEND A2",
+		}
+		for cursor, expected := range expectations {
+			actual := FormatListing(listings, cursor)
+			if actual != expected {
+				t.Errorf("Expected listing %q, got %q", expected, actual)
+			}
+		}
+	})
+}
+
+func TestParseSubscribeOptions(t *testing.T) {
+	t.Run("Parses prefix and sequence to resume after", func(t *testing.T) {
+		expectations := map[inventory.Seat]SubscribeOptions{
+			"prefix=A":          {"A", inventory.SUBSCRIBE_FROM_LATEST},
+			"after=latest":      {"", inventory.SUBSCRIBE_FROM_LATEST},
+			"prefix=B after=42": {"B", 42},
+		}
+
+		for argument, expected := range expectations {
+			options, err := ParseSubscribeOptions(argument)
+			if err != nil {
+				t.Errorf("Unexpected error parsing [%s]: %v", argument, err)
+			}
+			if options != expected {
+				t.Errorf("Expected [%s] to parse into %+v, got %+v", argument, expected, options)
+			}
+		}
+	})
+
+	t.Run("Rejects invalid options", func(t *testing.T) {
+		for _, invalid := range []inventory.Seat{"after=soon", "after=-1", "status=SOLD"} {
+			options, err := ParseSubscribeOptions(invalid)
+			if err == nil {
+				t.Errorf("Expected error for options [%s], got %+v", invalid, options)
+			}
+		}
+	})
+}
+
+func TestFormatTransition(t *testing.T) {
+	t.Run("Formats transitions as events", func(t *testing.T) {
+		actual := FormatTransition(inventory.Transition{Sequence: 7, Seat: "A1", From: inventory.RESERVED, To: inventory.SOLD})
+		if actual != "EVENT 7 A1 RESERVED SOLD" {
+			t.Errorf("Unexpected event [%s]", actual)
+		}
+	})
+}
+
+func TestFormatHistory(t *testing.T) {
+	t.Run("Formats one line per transition", func(t *testing.T) {
+		entries := []inventory.AuditEntry{
+			{Time: time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC), Seat: "A1", From: inventory.FREE, To: inventory.RESERVED, ConnectionID: 1, RemoteAddress: "10.0.0.1:4000"},
+			{Time: time.Date(2019, 10, 1, 12, 0, 1, 500, time.UTC), Seat: "A1", From: inventory.RESERVED, To: inventory.SOLD, ConnectionID: 2, RemoteAddress: "10.0.0.2:4000"},
+		}
+
+		expected := "2019-10-01T12:00:00Z FREE RESERVED 1 10.0.0.1:4000\n2019-10-01T12:00:01.0000005Z RESERVED SOLD 2 10.0.0.2:4000\nEND"
+		actual := FormatHistory(entries)
+		if actual != expected {
+			t.Errorf("Expected history %q, got %q", expected, actual)
+		}
+	})
+
+	t.Run("Ends lines with the reason when there is one", func(t *testing.T) {
+		entries := []inventory.AuditEntry{
+			{Time: time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC), Seat: "A1", From: inventory.SOLD, To: inventory.QUARANTINED, ConnectionID: 3, RemoteAddress: "10.0.0.3:4000", Reason: "card charged back"},
+		}
+
+		expected := "2019-10-01T12:00:00Z SOLD QUARANTINED 3 10.0.0.3:4000 card charged back\nEND"
+		actual := FormatHistory(entries)
+		if actual != expected {
+			t.Errorf("Expected history %q, got %q", expected, actual)
+		}
+	})
+}
+
+func TestParseMutation(t *testing.T) {
+	t.Run("Splits seat from idempotency key", func(t *testing.T) {
+		expectations := map[inventory.Seat][]string{
+			"A1":        {"A1", ""},
+			"A1 key=k1": {"A1", "k1"},
+		}
+		for argument, expected := range expectations {
+			seat, key := ParseMutation(argument)
+			if seat != inventory.Seat(expected[0]) || key != expected[1] {
+				t.Errorf("Expected [%s] to parse into %v, got [%s][%s]", argument, expected, seat, key)
+			}
+		}
+	})
+}
+
+func TestParseCompareAndSet(t *testing.T) {
+	t.Run("Reads seat and statuses", func(t *testing.T) {
+		seat, from, to, secret, err := ParseCompareAndSet("A1 SOLD FREE")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if seat != "A1" || from != inventory.SOLD || to != inventory.FREE || secret != "" {
+			t.Errorf("Unexpected parse result [%s][%s][%s][%s]", seat, from, to, secret)
+		}
+	})
+
+	t.Run("Reads the admin secret when given", func(t *testing.T) {
+		if _, _, _, secret, _ := ParseCompareAndSet("A1 SOLD FREE s3cr3t"); secret != "s3cr3t" {
+			t.Errorf("Expected secret [s3cr3t], got [%s]", secret)
+		}
+	})
+
+	t.Run("Rejects unknown statuses", func(t *testing.T) {
+		for _, invalid := range []inventory.Seat{"A1 GONE FREE", "A1 FREE sold"} {
+			if _, _, _, _, err := ParseCompareAndSet(invalid); err == nil {
+				t.Errorf("Expected error for [%s]", invalid)
+			}
+		}
+	})
+}
+
+func TestParseRefund(t *testing.T) {
+	t.Run("Reads seat, status, secret and reason", func(t *testing.T) {
+		seat, to, secret, reason, err := ParseRefund("A1 QUARANTINED s3cr3t card charged back")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if seat != "A1" || to != inventory.QUARANTINED || secret != "s3cr3t" || reason != "card charged back" {
+			t.Errorf("Unexpected parse result [%s][%s][%s][%s]", seat, to, secret, reason)
+		}
+	})
+
+	t.Run("Only refunds to FREE or QUARANTINED", func(t *testing.T) {
+		for _, invalid := range []inventory.Seat{"A1 RESERVED s3cr3t oops", "A1 SOLD s3cr3t oops", "A1 GONE s3cr3t oops"} {
+			if _, _, _, _, err := ParseRefund(invalid); err == nil {
+				t.Errorf("Expected error for [%s]", invalid)
+			}
+		}
+	})
+
+	t.Run("Only takes reasons the history can keep", func(t *testing.T) {
+		longest := strings.Repeat("x", MAX_REASON_SIZE)
+		if _, _, _, reason, err := ParseRefund(inventory.Seat("A1 FREE s3cr3t " + longest)); err != nil || reason != longest {
+			t.Errorf("Expected the longest reason to be taken, got [%s] %v", reason, err)
+		}
+		for _, invalid := range []string{longest + "x", "bell\a", "bad \xff byte"} {
+			if _, _, _, _, err := ParseRefund(inventory.Seat("A1 FREE s3cr3t " + invalid)); !errors.Is(err, ErrInvalidMessage) {
+				t.Errorf("Expected an invalid message error for reason [%q], got %v", invalid, err)
+			}
+		}
+	})
+}
+
+func TestQualify(t *testing.T) {
+	t.Run("Names seats given without an event as the event's", func(t *testing.T) {
+		expectations := []struct {
+			command  Command
+			argument inventory.Seat
+			expected inventory.Seat
+		}{
+			{RESERVE, "A1 key=k42", "EVT1/A1 key=k42"},
+			{BUY, "EVT2/A1", "EVT2/A1"},
+			{CAS, "A1 FREE RESERVED", "EVT1/A1 FREE RESERVED"},
+			{REFUND, "A1 FREE s3cr3t double booked", "EVT1/A1 FREE s3cr3t double booked"},
+			{HISTORY, "A1", "EVT1/A1"},
+			{QUERY, "A1,EVT2/A1,B2", "EVT1/A1,EVT2/A1,EVT1/B2"},
+			{LIST, "prefix=A limit=2", "prefix=EVT1/A limit=2"},
+			{LIST, "limit=2", "limit=2 prefix=EVT1/"},
+			{SUBSCRIBE, "after=3", "after=3 prefix=EVT1/"},
+			{STATS, "s3cr3t", "s3cr3t"},
+		}
+
+		for _, e := range expectations {
+			if actual, err := Qualify(e.command, e.argument, "EVT1"); err != nil || actual != e.expected {
+				t.Errorf("Expected [%s: %s] to become [%s], got [%s] and error [%v]", e.command, e.argument, e.expected, actual, err)
+			}
+		}
+	})
+
+	t.Run("Leaves arguments alone without an event", func(t *testing.T) {
+		if actual, err := Qualify(RESERVE, "A1", ""); err != nil || actual != "A1" {
+			t.Errorf("Expected seat to stay [A1], got [%s] and error [%v]", actual, err)
+		}
+	})
+
+	t.Run("Refuses seats too long once named with the event", func(t *testing.T) {
+		seat := inventory.Seat(strings.Repeat("A", MAX_SEAT_SIZE-len("EVT1/")))
+		if _, err := Qualify(RESERVE, seat, "EVT1"); err != nil {
+			t.Errorf("Expected the longest seat to be taken, got [%v]", err)
+		}
+		for _, command := range []Command{RESERVE, QUERY} {
+			if _, err := Qualify(command, seat+"A", "EVT1"); !errors.Is(err, ErrInvalidMessage) {
+				t.Errorf("Expected an invalid message error for [%s], got [%v]", command, err)
+			}
+		}
+	})
+}
+
+func TestSizeLimits(t *testing.T) {
+	t.Run("Refuses seats and events too long for the history", func(t *testing.T) {
+		longest := strings.Repeat("A", MAX_SEAT_SIZE)
+		for _, valid := range []string{"RESERVE: " + longest, "QUERY: A1," + longest, "CREATE_EVENT: " + longest[1:]} {
+			if _, _, err := ParseMessage(valid); err != nil {
+				t.Errorf("Expected [%.20s...] to be valid, got [%v]", valid, err)
+			}
+		}
+		for _, invalid := range []string{"RESERVE: " + longest + "A", "BUY: EVT1/" + longest, "QUERY: A1," + longest + "A", "HISTORY: " + longest + "A", "CAS: " + longest + "A FREE RESERVED", "REFUND: " + longest + "A FREE s3cr3t oops", "CREATE_EVENT: " + longest, "USE: " + longest} {
+			if _, _, err := ParseMessage(invalid); !errors.Is(err, ErrInvalidMessage) {
+				t.Errorf("Expected an invalid message error for [%.20s...], got [%v]", invalid, err)
+			}
+		}
+	})
+
+	t.Run("The longest seats and reasons fit in a B-tree history", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "protocol")
+		if err != nil {
+			t.Fatalf("Error creating temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		audit, err := inventory.OpenBTreeAuditTrail(nil, filepath.Join(dir, "history"), 4)
+		if err != nil {
+			t.Fatalf("Error opening audit trail: %v", err)
+		}
+		defer audit.Close()
+		seatInventory := inventory.NewAuditedInventory(audit)
+
+		actor := inventory.Actor{ConnectionID: -1 << 63, RemoteAddress: "[fe80::ffff:ffff:ffff:ffff%eth0]:65535"}
+		//Quotes are escaped, doubling their size, and HTML characters aren't
+		for i, reason := range []string{strings.Repeat(`"`, MAX_REASON_SIZE), strings.Repeat("<", MAX_REASON_SIZE)} {
+			seat := inventory.Seat(strings.Repeat(fmt.Sprintf("%c", 'A'+i), MAX_SEAT_SIZE))
+			seatInventory.Reserve(seat, actor)
+			seatInventory.Buy(seat, actor)
+			if err := seatInventory.Refund(seat, inventory.QUARANTINED, reason, actor); err != nil {
+				t.Fatalf("Unexpected error refunding with reason [%s]: %v", reason, err)
+			}
+			if history, err := seatInventory.History(seat); err != nil || len(history) != 3 || history[2].Reason != reason {
+				t.Errorf("Expected the refund and its reason to be kept, got %+v and error [%v]", history, err)
+			}
+		}
+	})
+}
+
+func TestErrorCode(t *testing.T) {
+	t.Run("Names each kind of error", func(t *testing.T) {
+		expectations := []struct {
+			err  error
+			code string
+		}{
+			{&inventory.TransitionError{Kind: inventory.ErrWrongStatus, Command: RESERVE, Seat: "A1", Status: inventory.RESERVED, To: inventory.RESERVED}, "SEAT_RESERVED"},
+			{&inventory.TransitionError{Kind: inventory.ErrWrongStatus, Command: RESERVE, Seat: "A1", Status: inventory.SOLD, To: inventory.RESERVED}, "SEAT_SOLD"},
+			{&inventory.TransitionError{Kind: inventory.ErrWrongStatus, Command: BUY, Seat: "A1", Status: inventory.FREE, To: inventory.SOLD}, "SEAT_FREE"},
+			{&inventory.TransitionError{Kind: inventory.ErrForbiddenTransition, Command: CAS, Seat: "A1", Status: inventory.FREE, To: inventory.SOLD}, "FORBIDDEN_TRANSITION"},
+			{&inventory.TransitionError{Kind: inventory.ErrNotPrivileged, Command: CAS, Seat: "A1", Status: inventory.SOLD, To: inventory.FREE}, "NOT_PRIVILEGED"},
+			{inventory.NewKindError(inventory.ErrNotPrivileged, "not an admin"), "NOT_PRIVILEGED"},
+			{inventory.NewKindError(ErrInvalidMessage, "invalid message [x]"), "INVALID_MESSAGE"},
+			{inventory.NewKindError(ErrUnknownCommand, "invalid command [X]"), "UNKNOWN_COMMAND"},
+			{inventory.NewKindError(inventory.ErrKeyReused, "key reused"), "KEY_REUSED"},
+			{inventory.NewKindError(ErrUnauthenticated, "who?"), "UNAUTHENTICATED"},
+			{inventory.NewKindError(inventory.ErrCannotResume, "too old"), "CANNOT_RESUME"},
+			{inventory.NewKindError(inventory.ErrReadOnly, "replica"), "READ_ONLY"},
+			{inventory.NewKindError(ErrNotReplica, "primary"), "NOT_REPLICA"},
+			{inventory.NewKindError(inventory.ErrNotApplied, "no leader"), "NOT_APPLIED"},
+			{inventory.NewKindError(inventory.ErrOutcomeUnknown, "timed out"), "OUTCOME_UNKNOWN"},
+			{inventory.NewKindError(ErrNotPartitioned, "one node"), "NOT_PARTITIONED"},
+			{inventory.NewKindError(ErrCrossPartition, "two nodes"), "CROSS_PARTITION"},
+			{inventory.NewKindError(inventory.ErrUnknownEvent, "no EVT1"), "UNKNOWN_EVENT"},
+			{inventory.NewKindError(inventory.ErrEventExists, "EVT1 again"), "EVENT_EXISTS"},
+			{inventory.NewKindError(inventory.ErrEventClosed, "EVT1 closed"), "EVENT_CLOSED"},
+			{inventory.NewKindError(inventory.ErrEventArchived, "EVT1 archived"), "EVENT_ARCHIVED"},
+			{&RemoteError{Code: "SEAT_SOLD", Message: "sold elsewhere"}, "SEAT_SOLD"},
+			{errors.New("disk full"), "ERROR"},
+		}
+
+		for _, e := range expectations {
+			if actual := ErrorCode(e.err); actual != e.code {
+				t.Errorf("Expected code [%s] for [%v], got [%s]", e.code, e.err, actual)
+			}
+		}
+	})
+}
+
+func TestFormatFailure(t *testing.T) {
+	err := &inventory.TransitionError{Kind: inventory.ErrWrongStatus, Command: RESERVE, Seat: "A1", Status: inventory.SOLD, To: inventory.RESERVED}
+
+	t.Run("Legacy failures are a bare FAIL", func(t *testing.T) {
+		if actual := FormatFailure(err, false); actual != "FAIL" {
+			t.Errorf("Expected [FAIL], got [%s]", actual)
+		}
+	})
+
+	t.Run("Extended failures have a code and a message", func(t *testing.T) {
+		expected := "FAIL SEAT_SOLD RESERVE of seat [A1] from [SOLD] to [RESERVED] refused: seat is not in a status it can be moved from"
+		if actual := FormatFailure(err, true); actual != expected {
+			t.Errorf("Expected [%s], got [%s]", expected, actual)
+		}
+	})
+
+	t.Run("Parse errors are told apart from unknown commands", func(t *testing.T) {
+		_, _, invalid := ParseMessage("RESERVE A1")
+		_, _, unknown := ParseMessage("STEAL: A1")
+		if !strings.HasPrefix(FormatFailure(invalid, true), "FAIL INVALID_MESSAGE ") {
+			t.Errorf("Unexpected failure [%s]", FormatFailure(invalid, true))
+		}
+		if !strings.HasPrefix(FormatFailure(unknown, true), "FAIL UNKNOWN_COMMAND ") {
+			t.Errorf("Unexpected failure [%s]", FormatFailure(unknown, true))
+		}
+	})
+
+	t.Run("Failures read back are relayed as they were", func(t *testing.T) {
+		line := FormatFailure(err, true)
+		if relayed := FormatFailure(ParseFailure(line), true); relayed != line {
+			t.Errorf("Expected [%s], got [%s]", line, relayed)
+		}
+		if relayed := FormatFailure(ParseFailure(FAIL), true); relayed != "FAIL ERROR failed without saying why" {
+			t.Errorf("Unexpected failure [%s]", relayed)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+)
+
+// ProtocolVersion is what a connection can do, picked with HELLO when it starts. Connections that never
+// say HELLO get DEFAULT_PROTOCOL_VERSION, which is how the server behaved before HELLO existed.
+type ProtocolVersion struct {
+	Number         int
+	Commands       []Command
+	ExtendedErrors bool
+}
+
+// DEFAULT_PROTOCOL_VERSION is spoken by clients that never say HELLO
+const DEFAULT_PROTOCOL_VERSION = 2
+
+// with is the commands of an older version and the ones a newer version adds
+func with(older []Command, added ...Command) []Command {
+	return append(append([]Command(nil), older...), added...)
+}
+
+// Commands of each version, they never change once released, new commands get a new version
+var (
+	originalCommands = []Command{RESERVE, BUY, QUERY}
+	// helloCommands are what the server had when HELLO was added
+	helloCommands = with(originalCommands, LIST, STATS, SUBSCRIBE, HISTORY, CAS, REFUND, ERRORS)
+	// authCommands added AUTH
+	authCommands = with(helloCommands, AUTH)
+	// replicaCommands added PROMOTE for replicas
+	replicaCommands = with(authCommands, PROMOTE)
+	// partitionCommands added JOIN for nodes added to a partitioned cluster
+	partitionCommands = with(replicaCommands, JOIN)
+	// eventCommands added events
+	eventCommands = with(partitionCommands, USE, CREATE_EVENT, CLOSE_EVENT, ARCHIVE_EVENT)
+	// handoverCommands added JOINED, confirming nodes took the seats JOIN offered
+	handoverCommands = with(eventCommands, JOINED)
+	// abortCommands added ABORT_JOIN, for nodes that couldn't take the seats JOIN offered
+	abortCommands = with(handoverCommands, ABORT_JOIN)
+)
+
+// protocolVersions go from oldest to newest, version 1 is the original three verbs and version 2 what
+// clients that don't say HELLO get
+var protocolVersions = []ProtocolVersion{
+	{1, originalCommands, false},
+	{2, helloCommands, false},
+	{3, helloCommands, true},
+	{4, authCommands, true},
+	{5, replicaCommands, true},
+	{6, partitionCommands, true},
+	{7, eventCommands, true},
+	{8, handoverCommands, true},
+	{9, abortCommands, true},
+}
+
+// Allows tells whether clients speaking the version can send the command
+func (v ProtocolVersion) Allows(command Command) bool {
+	for _, c := range v.Commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+// NegotiateVersion picks the newest version that isn't newer than the one requested, so clients built
+// against a newer server still get something they understand
+func NegotiateVersion(requested int) (ProtocolVersion, error) {
+	if requested < protocolVersions[0].Number {
+		return ProtocolVersion{}, inventory.NewKindError(ErrInvalidMessage, "unsupported protocol version [%d], oldest is [%d]", requested, protocolVersions[0].Number)
+	}
+
+	negotiated := protocolVersions[0]
+	for _, v := range protocolVersions {
+		if v.Number <= requested {
+			negotiated = v
+		}
+	}
+	return negotiated, nil
+}
+
+func DefaultProtocolVersion() ProtocolVersion {
+	version, _ := NegotiateVersion(DEFAULT_PROTOCOL_VERSION)
+	return version
+}
+
+// LatestProtocolVersion is the newest version, what servers speak to each other
+func LatestProtocolVersion() ProtocolVersion {
+	return protocolVersions[len(protocolVersions)-1]
+}
+
+// FormatHello answers HELLO with the version picked and what it allows, e.g.
+// OK 1 commands=BUY,QUERY,RESERVE errors=legacy
+func FormatHello(v ProtocolVersion) string {
+	commands := make([]string, len(v.Commands))
+	for i, c := range v.Commands {
+		commands[i] = string(c)
+	}
+	sort.Strings(commands)
+
+	errorMode := LEGACY_ERRORS
+	if v.ExtendedErrors {
+		errorMode = EXTENDED_ERRORS
+	}
+	return fmt.Sprintf("%s %d commands=%s errors=%s", OK, v.Number, strings.Join(commands, ","), errorMode)
+}
@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	t.Run("Picks the newest version not newer than requested", func(t *testing.T) {
+		expectations := map[int]int{1: 1, 2: 2, 3: 3, 8: 8, 9: 9, 99: 9}
+		for requested, expected := range expectations {
+			version, err := NegotiateVersion(requested)
+			if err != nil {
+				t.Fatalf("Unexpected error negotiating [%d]: %v", requested, err)
+			}
+			if version.Number != expected {
+				t.Errorf("Expected version [%d] when asking for [%d], got [%d]", expected, requested, version.Number)
+			}
+		}
+	})
+
+	t.Run("Rejects versions older than the first one", func(t *testing.T) {
+		if _, err := NegotiateVersion(0); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Expected invalid message error, got [%v]", err)
+		}
+	})
+
+	t.Run("Connections without HELLO keep the behaviour from before HELLO", func(t *testing.T) {
+		version := DefaultProtocolVersion()
+		if version.ExtendedErrors {
+			t.Errorf("Expected legacy errors by default")
+		}
+		for _, command := range []Command{RESERVE, BUY, QUERY, LIST, STATS, SUBSCRIBE, HISTORY, CAS, REFUND, ERRORS} {
+			if !version.Allows(command) {
+				t.Errorf("Expected [%s] to be allowed by default", command)
+			}
+		}
+		if version.Allows(AUTH) || version.Allows(USE) {
+			t.Errorf("Expected commands added after HELLO to need it, got %v", version.Commands)
+		}
+	})
+
+	t.Run("Versions keep the commands of older ones", func(t *testing.T) {
+		for i := 1; i < len(protocolVersions); i++ {
+			for _, command := range protocolVersions[i-1].Commands {
+				if !protocolVersions[i].Allows(command) {
+					t.Errorf("Expected version [%d] to allow [%s] like version [%d]", protocolVersions[i].Number, command, protocolVersions[i-1].Number)
+				}
+			}
+		}
+	})
+
+	t.Run("Version 1 only knows the original verbs", func(t *testing.T) {
+		version, _ := NegotiateVersion(1)
+		if version.Allows(LIST) || version.Allows(CAS) || !version.Allows(RESERVE) {
+			t.Errorf("Unexpected commands for version 1: %v", version.Commands)
+		}
+	})
+}
+
+func TestFormatHello(t *testing.T) {
+	t.Run("Describes the version picked", func(t *testing.T) {
+		version, _ := NegotiateVersion(1)
+		expected := "OK 1 commands=BUY,QUERY,RESERVE errors=legacy"
+		if actual := FormatHello(version); actual != expected {
+			t.Errorf("Expected [%s], got [%s]", expected, actual)
+		}
+
+		version, _ = NegotiateVersion(3)
+		expected = "OK 3 commands=BUY,CAS,ERRORS,HISTORY,LIST,QUERY,REFUND,RESERVE,STATS,SUBSCRIBE errors=extended"
+		if actual := FormatHello(version); actual != expected {
+			t.Errorf("Expected [%s], got [%s]", expected, actual)
+		}
+	})
+}
@@ -0,0 +1,822 @@
+// Package raft keeps inventories on several nodes in agreement with the Raft consensus algorithm. Nodes
+// elect a leader, which puts every move clients ask for in a log it replicates to the others. A move is
+// only applied, on every node and in log order, once a majority of the nodes stored it, so a cluster keeps
+// selling seats as long as a majority of its nodes can talk to each other, and never sells one twice.
+// Followers pass the moves they are asked for on to the leader. Reads are answered by whichever node the
+// client is connected to and can be behind the leader. The nodes in a cluster are fixed.
+package raft
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+)
+
+const (
+	DEFAULT_ELECTION_TIMEOUT   = 300 * time.Millisecond
+	DEFAULT_HEARTBEAT_INTERVAL = 50 * time.Millisecond
+	DEFAULT_SNAPSHOT_THRESHOLD = 1000
+	DEFAULT_PROPOSE_TIMEOUT    = 5 * time.Second
+	// MAX_ENTRIES_PER_APPEND caps how much of the log is sent to a follower at once
+	MAX_ENTRIES_PER_APPEND = 512
+	// RESULTS_KEPT is how many of the last moves applied have their result kept, for proposals that only
+	// start waiting after their move was applied
+	RESULTS_KEPT = 4096
+)
+
+// Config is how a node finds the rest of the cluster and how impatient it is
+type Config struct {
+	// ID is how the other nodes reach this one through the transport
+	ID    string
+	Peers []string
+	// ElectionTimeout is how long followers wait to hear from a leader before calling an election, each
+	// waits a random time between it and twice it so they don't all call one at once
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	// SnapshotThreshold is how many log entries are applied before they are replaced by a snapshot of the seats
+	SnapshotThreshold int
+	ProposeTimeout    time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.ElectionTimeout == 0 {
+		c.ElectionTimeout = DEFAULT_ELECTION_TIMEOUT
+	}
+	if c.HeartbeatInterval == 0 {
+		c.HeartbeatInterval = DEFAULT_HEARTBEAT_INTERVAL
+	}
+	if c.SnapshotThreshold == 0 {
+		c.SnapshotThreshold = DEFAULT_SNAPSHOT_THRESHOLD
+	}
+	if c.ProposeTimeout == 0 {
+		c.ProposeTimeout = DEFAULT_PROPOSE_TIMEOUT
+	}
+	return c
+}
+
+// Entry is a move in the log, with the term of the leader that added it. Leaders start their term with an
+// entry without a move, so that entries of earlier terms get committed.
+type Entry struct {
+	Term int64
+	Move inventory.Move
+}
+
+// Snapshot replaces the log up to and including LastIndex with the seats it left behind
+type Snapshot struct {
+	LastIndex int64
+	LastTerm  int64
+	Seats     []inventory.SeatListing
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+func (r role) String() string {
+	return [...]string{"follower", "candidate", "leader"}[r]
+}
+
+// result is what applying the move at an index returned
+type result struct {
+	term int64
+	err  error
+}
+
+// waiter is a proposal waiting for the move it put at an index to be applied
+type waiter struct {
+	term   int64
+	result chan error
+}
+
+// Node is one member of the cluster, applying the moves it agrees on with the others to its inventory
+type Node struct {
+	config        Config
+	transport     Transport
+	storage       Storage
+	seatInventory *inventory.Inventory
+	logger        *logging.Logger
+
+	lock sync.Mutex
+	// term, votedFor, snapshot and log are saved to the storage before anybody is told about them
+	term     int64
+	votedFor string
+	snapshot Snapshot
+	// log holds the entries after the snapshot, log[0] is at index snapshot.LastIndex+1
+	log []Entry
+
+	role             role
+	leader           string
+	electionDeadline time.Time
+	commitIndex      int64
+	lastApplied      int64
+	// nextIndex and matchIndex are, for leaders, the next entry to send each follower and the last one
+	// each is known to have
+	nextIndex  map[string]int64
+	matchIndex map[string]int64
+	sending    map[string]bool
+	resend     map[string]bool
+
+	waiters map[int64][]waiter
+	results map[int64]result
+	changed *sync.Cond
+	stopped chan struct{}
+	stop    sync.Once
+}
+
+// lastIndex is the index of the last entry, in the log or the snapshot
+func (n *Node) lastIndex() int64 {
+	return n.snapshot.LastIndex + int64(len(n.log))
+}
+
+// termAt is the term of the entry at the index, which must be in the log or be the last one snapshotted
+func (n *Node) termAt(index int64) int64 {
+	if index == n.snapshot.LastIndex {
+		return n.snapshot.LastTerm
+	}
+	return n.log[index-n.snapshot.LastIndex-1].Term
+}
+
+func (n *Node) entry(index int64) Entry {
+	return n.log[index-n.snapshot.LastIndex-1]
+}
+
+func (n *Node) persist() error {
+	return n.storage.Save(State{Term: n.term, VotedFor: n.votedFor, Snapshot: n.snapshot, Log: n.log})
+}
+
+func (n *Node) resetElectionDeadline() {
+	timeout := n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+// isPeer tells whether the node is one of the others in the cluster, requests from any other are ignored
+func (n *Node) isPeer(id string) bool {
+	for _, peer := range n.config.Peers {
+		if peer == id {
+			return true
+		}
+	}
+	return false
+}
+
+// majority is how many nodes, counting this one, make a majority of the cluster
+func (n *Node) majority() int {
+	return (len(n.config.Peers)+1)/2 + 1
+}
+
+// observeTerm steps down to follower if another node is in a later term
+func (n *Node) observeTerm(term int64) {
+	if term <= n.term {
+		return
+	}
+	if n.role != follower {
+		n.logger.Infof("Node [%s] steps down, term [%d] is over", n.config.ID, n.term)
+	}
+	n.term, n.votedFor, n.role, n.leader = term, "", follower, ""
+	n.persist()
+}
+
+// Status is the term the node is in, its role and the leader it knows of
+func (n *Node) Status() (int64, string, string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.term, n.role.String(), n.leader
+}
+
+// Propose gets the move applied by every node, returning once it was applied to this node's inventory with
+// what applying it returned. Followers hand the move to the leader.
+func (n *Node) Propose(move inventory.Move) error {
+	n.lock.Lock()
+	if n.role == leader {
+		index, err := n.append(move)
+		if err != nil {
+			n.lock.Unlock()
+			return inventory.NewKindError(inventory.ErrNotApplied, "could not add move of seat [%s] to the log: %v", move.Seat, err)
+		}
+		result := n.wait(index, n.term)
+		n.lock.Unlock()
+		n.replicateAll()
+		return n.await(move, result)
+	}
+	leaderID := n.leader
+	n.lock.Unlock()
+
+	if leaderID == "" {
+		return inventory.NewKindError(inventory.ErrNotApplied, "no leader to agree on moving seat [%s] with", move.Seat)
+	}
+	if move.Privileged && !inventory.AlwaysPrivileged(move.Command) {
+		return inventory.NewKindError(inventory.ErrNotApplied, "privileged [%s] of seat [%s] must be sent to leader [%s], it can't check who asked for it here", move.Command, move.Seat, leaderID)
+	}
+	reply, err := n.transport.Forward(leaderID, ForwardRequest{From: n.config.ID, Move: move})
+	if err != nil {
+		return inventory.NewKindError(inventory.ErrOutcomeUnknown, "lost track of moving seat [%s] through leader [%s]: %v", move.Seat, leaderID, err)
+	}
+	if !reply.Accepted {
+		return inventory.NewKindError(inventory.ErrNotApplied, "[%s] is no longer the leader, seat [%s] was not moved", leaderID, move.Seat)
+	}
+
+	n.lock.Lock()
+	result := n.wait(reply.Index, reply.Term)
+	n.lock.Unlock()
+	return n.await(move, result)
+}
+
+// append adds the move to a leader's log
+func (n *Node) append(move inventory.Move) (int64, error) {
+	n.log = append(n.log, Entry{Term: n.term, Move: move})
+	if err := n.persist(); err != nil {
+		n.log = n.log[:len(n.log)-1]
+		return 0, err
+	}
+	index := n.lastIndex()
+	n.matchIndex[n.config.ID] = index
+	n.advanceCommit()
+	return index, nil
+}
+
+// wait returns where the result of applying the entry at the index will be delivered
+func (n *Node) wait(index int64, term int64) chan error {
+	result := make(chan error, 1)
+	if index > n.lastApplied {
+		n.waiters[index] = append(n.waiters[index], waiter{term, result})
+		return result
+	}
+
+	//Already applied, the proposal was slower than the cluster
+	applied, kept := n.results[index]
+	switch {
+	case !kept:
+		result <- inventory.NewKindError(inventory.ErrOutcomeUnknown, "entry [%d] was applied too long ago to know how it went", index)
+	case applied.term != term:
+		result <- inventory.NewKindError(inventory.ErrNotApplied, "entry [%d] was replaced by a later leader", index)
+	default:
+		result <- applied.err
+	}
+	return result
+}
+
+func (n *Node) await(move inventory.Move, result chan error) error {
+	timeout := time.NewTimer(n.config.ProposeTimeout)
+	defer timeout.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timeout.C:
+		return inventory.NewKindError(inventory.ErrOutcomeUnknown, "timed out waiting for the cluster to move seat [%s]", move.Seat)
+	case <-n.stopped:
+		return inventory.NewKindError(inventory.ErrOutcomeUnknown, "node stopped before seat [%s] was moved", move.Seat)
+	}
+}
+
+// HandleVote answers a candidate asking for this node's vote
+func (n *Node) HandleVote(request VoteRequest) VoteReply {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if !n.isPeer(request.Candidate) {
+		return VoteReply{Term: n.term}
+	}
+	n.observeTerm(request.Term)
+	if request.Term < n.term || (n.votedFor != "" && n.votedFor != request.Candidate) {
+		return VoteReply{Term: n.term}
+	}
+
+	//Only candidates with every entry this node has can win, entries of a majority are never lost
+	lastIndex, lastTerm := n.lastIndex(), n.termAt(n.lastIndex())
+	if request.LastLogTerm < lastTerm || (request.LastLogTerm == lastTerm && request.LastLogIndex < lastIndex) {
+		return VoteReply{Term: n.term}
+	}
+
+	n.votedFor = request.Candidate
+	if err := n.persist(); err != nil {
+		n.votedFor = ""
+		return VoteReply{Term: n.term}
+	}
+	n.resetElectionDeadline()
+	return VoteReply{Term: n.term, Granted: true}
+}
+
+// HandleAppend stores the entries a leader sends, if they follow on from what this node has
+func (n *Node) HandleAppend(request AppendRequest) AppendReply {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if !n.isPeer(request.Leader) {
+		return AppendReply{Term: n.term}
+	}
+	n.observeTerm(request.Term)
+	if request.Term < n.term {
+		return AppendReply{Term: n.term}
+	}
+	n.role, n.leader = follower, request.Leader
+	n.resetElectionDeadline()
+
+	//Entries already in the snapshot were committed, they can only be what this node has
+	prevIndex, prevTerm, entries := request.PrevLogIndex, request.PrevLogTerm, request.Entries
+	if prevIndex < n.snapshot.LastIndex {
+		skip := n.snapshot.LastIndex - prevIndex
+		if skip > int64(len(entries)) {
+			skip = int64(len(entries))
+		}
+		prevIndex, prevTerm, entries = n.snapshot.LastIndex, n.snapshot.LastTerm, entries[skip:]
+	}
+
+	if prevIndex > n.lastIndex() {
+		return AppendReply{Term: n.term, ConflictIndex: n.lastIndex() + 1}
+	}
+	if term := n.termAt(prevIndex); term != prevTerm {
+		//Skip back over the whole term in conflict instead of one entry per round trip
+		conflict := prevIndex
+		for conflict > n.snapshot.LastIndex+1 && n.termAt(conflict-1) == term {
+			conflict--
+		}
+		return AppendReply{Term: n.term, ConflictIndex: conflict}
+	}
+
+	changed := false
+	for i, entry := range entries {
+		index := prevIndex + 1 + int64(i)
+		if index <= n.lastIndex() {
+			if n.termAt(index) == entry.Term {
+				continue
+			}
+			n.log = n.log[:index-n.snapshot.LastIndex-1]
+		}
+		n.log = append(n.log, entries[i:]...)
+		changed = true
+		break
+	}
+	if changed {
+		if err := n.persist(); err != nil {
+			n.logger.Errorf("Node [%s] could not save entries: %v", n.config.ID, err)
+			return AppendReply{Term: n.term, ConflictIndex: prevIndex + 1}
+		}
+	}
+
+	lastNew := prevIndex + int64(len(entries))
+	if request.LeaderCommit > n.commitIndex {
+		n.commitIndex = request.LeaderCommit
+		if n.commitIndex > lastNew {
+			n.commitIndex = lastNew
+		}
+		n.changed.Broadcast()
+	}
+	return AppendReply{Term: n.term, Success: true}
+}
+
+// HandleSnapshot replaces what this node has with a leader's snapshot, unless it has everything in it
+func (n *Node) HandleSnapshot(request SnapshotRequest) SnapshotReply {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if !n.isPeer(request.Leader) {
+		return SnapshotReply{Term: n.term}
+	}
+	n.observeTerm(request.Term)
+	if request.Term < n.term {
+		return SnapshotReply{Term: n.term}
+	}
+	n.role, n.leader = follower, request.Leader
+	n.resetElectionDeadline()
+
+	snapshot := request.Snapshot
+	if snapshot.LastIndex <= n.snapshot.LastIndex || snapshot.LastIndex <= n.commitIndex {
+		return SnapshotReply{Term: n.term}
+	}
+
+	//Entries after the snapshot are kept if they follow on from it
+	if snapshot.LastIndex < n.lastIndex() && n.termAt(snapshot.LastIndex) == snapshot.LastTerm {
+		n.log = append([]Entry(nil), n.log[snapshot.LastIndex-n.snapshot.LastIndex:]...)
+	} else {
+		n.log = nil
+	}
+	n.snapshot = snapshot
+	if err := n.persist(); err != nil {
+		n.logger.Errorf("Node [%s] could not save snapshot: %v", n.config.ID, err)
+	}
+	n.commitIndex = snapshot.LastIndex
+	n.changed.Broadcast()
+	n.logger.Infof("Node [%s] installed snapshot up to entry [%d]", n.config.ID, snapshot.LastIndex)
+	return SnapshotReply{Term: n.term}
+}
+
+// HandleForward adds a follower's move to the log if this node is the leader, without waiting for it. The
+// move keeps the client that asked the follower for it as its actor, but not the privileges it claims.
+func (n *Node) HandleForward(request ForwardRequest) ForwardReply {
+	if !n.isPeer(request.From) {
+		return ForwardReply{}
+	}
+	move := request.Move
+	move.Privileged = inventory.AlwaysPrivileged(move.Command)
+
+	n.lock.Lock()
+	if n.role != leader {
+		n.lock.Unlock()
+		return ForwardReply{}
+	}
+	index, err := n.append(move)
+	term := n.term
+	n.lock.Unlock()
+	if err != nil {
+		return ForwardReply{}
+	}
+
+	n.replicateAll()
+	return ForwardReply{Accepted: true, Index: index, Term: term}
+}
+
+// tick calls elections when the leader is silent and sends heartbeats when this node is the leader
+func (n *Node) tick() {
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		n.lock.Lock()
+		isLeader := n.role == leader
+		electionDue := !isLeader && time.Now().After(n.electionDeadline)
+		n.lock.Unlock()
+
+		if isLeader {
+			n.replicateAll()
+		} else if electionDue {
+			n.campaign()
+		}
+	}
+}
+
+// campaign asks every other node for its vote, becoming leader with a majority of them
+func (n *Node) campaign() {
+	n.lock.Lock()
+	n.role = candidate
+	n.term++
+	n.votedFor = n.config.ID
+	n.leader = ""
+	n.resetElectionDeadline()
+	if err := n.persist(); err != nil {
+		n.logger.Errorf("Node [%s] could not save its vote: %v", n.config.ID, err)
+		n.lock.Unlock()
+		return
+	}
+	term := n.term
+	request := VoteRequest{Term: term, Candidate: n.config.ID, LastLogIndex: n.lastIndex(), LastLogTerm: n.termAt(n.lastIndex())}
+	n.logger.Infof("Node [%s] calls an election for term [%d]", n.config.ID, term)
+	n.lock.Unlock()
+
+	//A cluster of one elects itself
+	if n.majority() == 1 {
+		n.lock.Lock()
+		if n.role == candidate && n.term == term {
+			n.lead()
+		}
+		n.lock.Unlock()
+		return
+	}
+
+	votes := 1
+	for _, peer := range n.config.Peers {
+		go func(peer string) {
+			reply, err := n.transport.RequestVote(peer, request)
+			if err != nil {
+				return
+			}
+
+			n.lock.Lock()
+			defer n.lock.Unlock()
+			n.observeTerm(reply.Term)
+			if !reply.Granted || n.role != candidate || n.term != term {
+				return
+			}
+			votes++
+			if votes == n.majority() {
+				n.lead()
+			}
+		}(peer)
+	}
+}
+
+// lead makes a candidate that won the leader, callers hold the lock
+func (n *Node) lead() {
+	n.logger.Infof("Node [%s] leads term [%d]", n.config.ID, n.term)
+	n.role, n.leader = leader, n.config.ID
+	n.nextIndex, n.matchIndex = map[string]int64{}, map[string]int64{}
+	for _, peer := range n.config.Peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+	}
+	//Entries of earlier terms only count as committed once one of this term is
+	if _, err := n.append(inventory.Move{}); err != nil {
+		n.logger.Errorf("Node [%s] could not start its term: %v", n.config.ID, err)
+	}
+	go n.replicateAll()
+}
+
+func (n *Node) replicateAll() {
+	for _, peer := range n.config.Peers {
+		n.lock.Lock()
+		if n.role != leader {
+			n.lock.Unlock()
+			return
+		}
+		//One request at a time per follower, whatever piles up meanwhile goes in the next one
+		if n.sending[peer] {
+			n.resend[peer] = true
+			n.lock.Unlock()
+			continue
+		}
+		n.sending[peer] = true
+		n.lock.Unlock()
+		go n.replicate(peer)
+	}
+}
+
+// replicate sends the follower what it is missing until it has everything
+func (n *Node) replicate(peer string) {
+	for {
+		n.lock.Lock()
+		n.resend[peer] = false
+		if n.role != leader {
+			n.sending[peer] = false
+			n.lock.Unlock()
+			return
+		}
+		term := n.term
+		next := n.nextIndex[peer]
+
+		var caughtUp bool
+		if next <= n.snapshot.LastIndex {
+			request := SnapshotRequest{Term: term, Leader: n.config.ID, Snapshot: n.snapshot}
+			n.lock.Unlock()
+			reply, err := n.transport.InstallSnapshot(peer, request)
+			caughtUp = n.snapshotSent(peer, term, request, reply, err)
+		} else {
+			end := n.lastIndex() + 1
+			if end-next > MAX_ENTRIES_PER_APPEND {
+				end = next + MAX_ENTRIES_PER_APPEND
+			}
+			entries := append([]Entry(nil), n.log[next-n.snapshot.LastIndex-1:end-n.snapshot.LastIndex-1]...)
+			request := AppendRequest{
+				Term:         term,
+				Leader:       n.config.ID,
+				PrevLogIndex: next - 1,
+				PrevLogTerm:  n.termAt(next - 1),
+				Entries:      entries,
+				LeaderCommit: n.commitIndex,
+			}
+			n.lock.Unlock()
+			reply, err := n.transport.AppendEntries(peer, request)
+			caughtUp = n.appendSent(peer, term, request, reply, err)
+		}
+
+		n.lock.Lock()
+		if caughtUp && !n.resend[peer] {
+			n.sending[peer] = false
+			n.lock.Unlock()
+			return
+		}
+		n.lock.Unlock()
+	}
+}
+
+// appendSent takes in what the follower answered, telling whether there is nothing left to send it now
+func (n *Node) appendSent(peer string, term int64, request AppendRequest, reply AppendReply, err error) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if err != nil {
+		//Unreachable, the next heartbeat tries again
+		return true
+	}
+	n.observeTerm(reply.Term)
+	if n.role != leader || n.term != term {
+		return true
+	}
+
+	if !reply.Success {
+		n.nextIndex[peer] = reply.ConflictIndex
+		if n.nextIndex[peer] < 1 {
+			n.nextIndex[peer] = 1
+		}
+		return false
+	}
+
+	match := request.PrevLogIndex + int64(len(request.Entries))
+	if match > n.matchIndex[peer] {
+		n.matchIndex[peer] = match
+	}
+	n.nextIndex[peer] = match + 1
+	n.advanceCommit()
+	return n.nextIndex[peer] > n.lastIndex()
+}
+
+func (n *Node) snapshotSent(peer string, term int64, request SnapshotRequest, reply SnapshotReply, err error) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if err != nil {
+		return true
+	}
+	n.observeTerm(reply.Term)
+	if n.role != leader || n.term != term {
+		return true
+	}
+	if request.Snapshot.LastIndex > n.matchIndex[peer] {
+		n.matchIndex[peer] = request.Snapshot.LastIndex
+	}
+	n.nextIndex[peer] = request.Snapshot.LastIndex + 1
+	return false
+}
+
+// advanceCommit commits the entries a majority has, callers hold the lock
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex && index > n.snapshot.LastIndex; index-- {
+		//Only entries of the leader's own term are committed by counting, earlier ones come with them
+		if n.termAt(index) != n.term {
+			break
+		}
+		count := 1
+		for _, peer := range n.config.Peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count >= n.majority() {
+			n.commitIndex = index
+			n.changed.Broadcast()
+			return
+		}
+	}
+}
+
+// applyCommitted applies committed entries to the inventory in order, one at a time, and takes snapshots
+func (n *Node) applyCommitted() {
+	for {
+		n.lock.Lock()
+		for n.lastApplied >= n.commitIndex && n.lastApplied >= n.snapshot.LastIndex {
+			select {
+			case <-n.stopped:
+				n.lock.Unlock()
+				return
+			default:
+			}
+			n.changed.Wait()
+		}
+
+		if n.lastApplied < n.snapshot.LastIndex {
+			n.restore()
+			n.lock.Unlock()
+			continue
+		}
+
+		from := n.lastApplied + 1
+		entries := make([]Entry, 0, n.commitIndex-n.lastApplied)
+		for index := from; index <= n.commitIndex; index++ {
+			entries = append(entries, n.entry(index))
+		}
+		n.lock.Unlock()
+
+		//Only this goroutine moves seats, so the inventory is exactly what the entries applied so far left
+		for i, entry := range entries {
+			var err error
+			if entry.Move.Command != "" {
+				err = n.seatInventory.Apply(entry.Move)
+			}
+			n.lock.Lock()
+			n.applied(from+int64(i), entry.Term, err)
+			n.lock.Unlock()
+		}
+
+		n.compact()
+	}
+}
+
+// restore replaces the seats with the snapshot's, callers hold the lock
+func (n *Node) restore() {
+	snapshot := n.snapshot
+	n.lock.Unlock()
+	err := n.seatInventory.Restore(snapshot.Seats, inventory.Actor{RemoteAddress: n.config.ID})
+	n.lock.Lock()
+	if err != nil {
+		n.logger.Errorf("Node [%s] could not restore snapshot, retrying: %v", n.config.ID, err)
+		return
+	}
+
+	for index := n.lastApplied + 1; index <= snapshot.LastIndex; index++ {
+		for _, w := range n.waiters[index] {
+			w.result <- inventory.NewKindError(inventory.ErrOutcomeUnknown, "entry [%d] arrived in a snapshot, there is no telling how it went", index)
+		}
+		delete(n.waiters, index)
+	}
+	n.lastApplied = snapshot.LastIndex
+	if n.commitIndex < snapshot.LastIndex {
+		n.commitIndex = snapshot.LastIndex
+	}
+}
+
+// applied hands the result to whoever proposed the entry, callers hold the lock
+func (n *Node) applied(index int64, term int64, err error) {
+	n.lastApplied = index
+	n.results[index] = result{term, err}
+	delete(n.results, index-RESULTS_KEPT)
+
+	for _, w := range n.waiters[index] {
+		if w.term == term {
+			w.result <- err
+		} else {
+			w.result <- inventory.NewKindError(inventory.ErrNotApplied, "entry [%d] was replaced by a later leader", index)
+		}
+	}
+	delete(n.waiters, index)
+}
+
+// compact replaces applied entries with a snapshot once there are enough of them
+func (n *Node) compact() {
+	n.lock.Lock()
+	index := n.lastApplied
+	if index-n.snapshot.LastIndex < int64(n.config.SnapshotThreshold) {
+		n.lock.Unlock()
+		return
+	}
+	term := n.termAt(index)
+	n.lock.Unlock()
+
+	//Seats are only moved by the goroutine calling this, they are what the entries up to index left
+	seats, err := n.seatInventory.Seats()
+	if err != nil {
+		n.logger.Errorf("Node [%s] could not take a snapshot: %v", n.config.ID, err)
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if index <= n.snapshot.LastIndex {
+		return
+	}
+	previousSnapshot, previousLog := n.snapshot, n.log
+	n.log = append([]Entry(nil), n.log[index-n.snapshot.LastIndex:]...)
+	n.snapshot = Snapshot{LastIndex: index, LastTerm: term, Seats: seats}
+	if err := n.persist(); err != nil {
+		n.logger.Errorf("Node [%s] could not save snapshot: %v", n.config.ID, err)
+		n.snapshot, n.log = previousSnapshot, previousLog
+		return
+	}
+	n.logger.Infof("Node [%s] took a snapshot up to entry [%d]", n.config.ID, index)
+}
+
+// Start begins taking part in the cluster
+func (n *Node) Start() {
+	go n.tick()
+	go n.applyCommitted()
+}
+
+// Stop leaves the cluster, proposals waiting on the node give up
+func (n *Node) Stop() {
+	n.stop.Do(func() {
+		close(n.stopped)
+		n.lock.Lock()
+		n.changed.Broadcast()
+		n.lock.Unlock()
+	})
+}
+
+// NewNode makes the inventory go through the cluster for every move, starting from what the storage has.
+// The inventory must be empty, applying the log and snapshots is what fills it.
+func NewNode(config Config, seatInventory *inventory.Inventory, transport Transport, storage Storage, logger *logging.Logger) (*Node, error) {
+	state, err := storage.Load()
+	if err != nil {
+		return nil, fmt.Errorf("could not load raft state: %v", err)
+	}
+
+	n := &Node{
+		config:        config.withDefaults(),
+		transport:     transport,
+		storage:       storage,
+		seatInventory: seatInventory,
+		logger:        logger,
+		term:          state.Term,
+		votedFor:      state.VotedFor,
+		snapshot:      state.Snapshot,
+		log:           state.Log,
+		commitIndex:   state.Snapshot.LastIndex,
+		nextIndex:     map[string]int64{},
+		matchIndex:    map[string]int64{},
+		sending:       map[string]bool{},
+		resend:        map[string]bool{},
+		waiters:       map[int64][]waiter{},
+		results:       map[int64]result{},
+		stopped:       make(chan struct{}),
+	}
+	n.changed = sync.NewCond(&n.lock)
+	n.resetElectionDeadline()
+	seatInventory.SetMoveLog(n)
+	return n, nil
+}
@@ -0,0 +1,487 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+)
+
+var testActor = inventory.Actor{ConnectionID: 1, RemoteAddress: "127.0.0.1:5000"}
+
+type cluster struct {
+	t           *testing.T
+	network     *MemoryNetwork
+	ids         []string
+	nodes       map[string]*Node
+	inventories map[string]*inventory.Inventory
+	storages    map[string]*MemoryStorage
+	config      Config
+}
+
+// newCluster starts nodes that talk over a memory network, with timeouts short enough for tests
+func newCluster(t *testing.T, size int, snapshotThreshold int) *cluster {
+	c := &cluster{
+		t:           t,
+		network:     NewMemoryNetwork(),
+		nodes:       map[string]*Node{},
+		inventories: map[string]*inventory.Inventory{},
+		storages:    map[string]*MemoryStorage{},
+		config: Config{
+			ElectionTimeout:   100 * time.Millisecond,
+			HeartbeatInterval: 20 * time.Millisecond,
+			SnapshotThreshold: snapshotThreshold,
+			ProposeTimeout:    2 * time.Second,
+		},
+	}
+	for i := 0; i < size; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("n%d", i+1))
+	}
+	for _, id := range c.ids {
+		c.storages[id] = NewMemoryStorage()
+		c.start(id)
+	}
+	return c
+}
+
+// start runs the node with a new inventory and whatever its storage has, as if the process restarted
+func (c *cluster) start(id string) {
+	config := c.config
+	config.ID = id
+	for _, peer := range c.ids {
+		if peer != id {
+			config.Peers = append(config.Peers, peer)
+		}
+	}
+	seatInventory := inventory.NewInventory()
+	node, err := NewNode(config, seatInventory, c.network.Transport(id), c.storages[id], logging.NewLogger(false))
+	if err != nil {
+		c.t.Fatalf("Unexpected error starting node [%s]: %v", id, err)
+	}
+	c.nodes[id], c.inventories[id] = node, seatInventory
+	c.network.Join(id, node)
+	node.Start()
+}
+
+func (c *cluster) crash(id string) {
+	c.network.Leave(id)
+	c.nodes[id].Stop()
+}
+
+func (c *cluster) stop() {
+	for _, id := range c.ids {
+		c.crash(id)
+	}
+}
+
+// leader waits for exactly one of the nodes to lead, and for the others among them to know it
+func (c *cluster) leader(among ...string) string {
+	if len(among) == 0 {
+		among = c.ids
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		leaders := map[int64][]string{}
+		known := map[string]bool{}
+		latest := int64(0)
+		for _, id := range among {
+			term, role, leader := c.nodes[id].Status()
+			if role == "leader" {
+				leaders[term] = append(leaders[term], id)
+			}
+			if term > latest {
+				latest = term
+			}
+			known[leader] = true
+		}
+		if len(leaders[latest]) > 1 {
+			c.t.Fatalf("Expected one leader in term [%d], got %v", latest, leaders[latest])
+		}
+		if len(leaders[latest]) == 1 && len(known) == 1 {
+			return leaders[latest][0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatalf("Expected a leader among %v", among)
+	return ""
+}
+
+func (c *cluster) others(id string) []string {
+	var others []string
+	for _, other := range c.ids {
+		if other != id {
+			others = append(others, other)
+		}
+	}
+	return others
+}
+
+// expectEventually waits for every node given to have the seats in the statuses
+func (c *cluster) expectEventually(ids []string, expected map[inventory.Seat]inventory.SeatStatus) {
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range ids {
+		for {
+			actual := map[inventory.Seat]inventory.SeatStatus{}
+			matches := true
+			for seat, status := range expected {
+				actual[seat], _ = c.inventories[id].Get(seat)
+				matches = matches && actual[seat] == status
+			}
+			if matches {
+				break
+			}
+			if time.Now().After(deadline) {
+				c.t.Fatalf("Expected seats on [%s] to be %v, got %v", id, expected, actual)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func mustMove(t *testing.T, err error) {
+	if err != nil {
+		t.Fatalf("Unexpected error moving seat: %v", err)
+	}
+}
+
+func TestNode(t *testing.T) {
+	t.Run("Elects a single leader", func(t *testing.T) {
+		c := newCluster(t, 3, 0)
+		defer c.stop()
+
+		c.leader()
+	})
+
+	t.Run("Applies moves made on the leader to every node", func(t *testing.T) {
+		c := newCluster(t, 3, 0)
+		defer c.stop()
+
+		leader := c.inventories[c.leader()]
+		mustMove(t, leader.Reserve("A1", testActor))
+		mustMove(t, leader.Buy("A1", testActor))
+		mustMove(t, leader.Reserve("A2", testActor))
+		mustMove(t, leader.Refund("A1", inventory.QUARANTINED, "damaged", testActor))
+
+		c.expectEventually(c.ids, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.QUARANTINED, "A2": inventory.RESERVED})
+		if err := leader.Buy("A3", testActor); !errors.Is(err, inventory.ErrWrongStatus) {
+			t.Errorf("Expected the result of applying the move, got %v", err)
+		}
+	})
+
+	t.Run("Followers pass moves on to the leader", func(t *testing.T) {
+		c := newCluster(t, 3, 0)
+		defer c.stop()
+
+		follower := c.inventories[c.others(c.leader())[0]]
+		mustMove(t, follower.Reserve("A1", testActor))
+		//Applied on the follower by the time it returns
+		if status, _ := follower.Get("A1"); status != inventory.RESERVED {
+			t.Errorf("Expected follower to have applied the move, seat [A1] is [%s]", status)
+		}
+		if err := follower.Reserve("A1", testActor); !errors.Is(err, inventory.ErrWrongStatus) {
+			t.Errorf("Expected the result of applying the move, got %v", err)
+		}
+		c.expectEventually(c.ids, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.RESERVED})
+	})
+
+	t.Run("Keeps going without a leader cut off from the majority, which catches up once back", func(t *testing.T) {
+		c := newCluster(t, 5, 0)
+		defer c.stop()
+
+		oldLeader := c.leader()
+		mustMove(t, c.inventories[oldLeader].Reserve("A1", testActor))
+		majority := c.others(oldLeader)[:3]
+		cutOff := []string{oldLeader, c.others(oldLeader)[3]}
+		c.network.Partition(majority, cutOff)
+
+		//The old leader can't get a majority to agree, the move is lost or never happens
+		moved := make(chan error)
+		go func() { moved <- c.inventories[oldLeader].Buy("A1", testActor) }()
+
+		newLeader := c.leader(majority...)
+		mustMove(t, c.inventories[newLeader].Reserve("A2", testActor))
+		mustMove(t, c.inventories[majority[0]].Buy("A2", testActor))
+		c.expectEventually(majority, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.RESERVED, "A2": inventory.SOLD})
+
+		c.network.Heal()
+		err := <-moved
+		if !errors.Is(err, inventory.ErrNotApplied) && !errors.Is(err, inventory.ErrOutcomeUnknown) {
+			t.Errorf("Expected the move on the cut off leader to fail, got %v", err)
+		}
+		c.expectEventually(c.ids, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.RESERVED, "A2": inventory.SOLD})
+		if status, _ := c.inventories[oldLeader].Get("A1"); status != inventory.RESERVED {
+			t.Errorf("Expected the move the majority never saw to be dropped, seat [A1] is [%s]", status)
+		}
+	})
+
+	t.Run("Refuses moves when no majority can be reached", func(t *testing.T) {
+		c := newCluster(t, 3, 0)
+		defer c.stop()
+
+		leader := c.leader()
+		c.network.Partition([]string{leader}, c.others(leader))
+		//Wait for the leader to be replaced, so that the cut off node knows it isn't one anymore
+		c.leader(c.others(leader)...)
+		c.crash(c.others(leader)[0])
+		c.crash(c.others(leader)[1])
+
+		err := c.inventories[leader].Reserve("A1", testActor)
+		if !errors.Is(err, inventory.ErrNotApplied) && !errors.Is(err, inventory.ErrOutcomeUnknown) {
+			t.Errorf("Expected the move to fail, got %v", err)
+		}
+		if status, _ := c.inventories[leader].Get("A1"); status != inventory.FREE {
+			t.Errorf("Expected the move not to be applied, seat [A1] is [%s]", status)
+		}
+	})
+
+	t.Run("Never sells a seat twice while the network splits and heals", func(t *testing.T) {
+		c := newCluster(t, 5, 20)
+		defer c.stop()
+		c.leader()
+
+		var lock sync.Mutex
+		sold := map[inventory.Seat][]string{}
+		stopBuying := make(chan struct{})
+		var buyers sync.WaitGroup
+		for _, id := range c.ids {
+			buyers.Add(1)
+			go func(id string) {
+				defer buyers.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-stopBuying:
+						return
+					default:
+					}
+					seat := inventory.Seat(fmt.Sprintf("A%d", i%10))
+					c.inventories[id].Reserve(seat, testActor)
+					if err := c.inventories[id].Buy(seat, testActor); err == nil {
+						lock.Lock()
+						sold[seat] = append(sold[seat], id)
+						lock.Unlock()
+					}
+				}
+			}(id)
+		}
+
+		for i := 0; i < 4; i++ {
+			leader := c.leader()
+			others := c.others(leader)
+			c.network.Partition([]string{leader, others[0]}, others[1:])
+			time.Sleep(300 * time.Millisecond)
+			c.network.Heal()
+			time.Sleep(300 * time.Millisecond)
+		}
+		close(stopBuying)
+		buyers.Wait()
+
+		for seat, buyers := range sold {
+			if len(buyers) > 1 {
+				t.Errorf("Expected seat [%s] to be sold once, sold by %v", seat, buyers)
+			}
+		}
+		expected := map[inventory.Seat]inventory.SeatStatus{}
+		for i := 0; i < 10; i++ {
+			seat := inventory.Seat(fmt.Sprintf("A%d", i))
+			expected[seat], _ = c.inventories[c.leader()].Get(seat)
+		}
+		c.expectEventually(c.ids, expected)
+	})
+
+	t.Run("Sends a snapshot to followers too far behind for the log", func(t *testing.T) {
+		c := newCluster(t, 3, 5)
+		defer c.stop()
+
+		leader := c.leader()
+		lagging := c.others(leader)[0]
+		c.network.Partition([]string{lagging}, c.others(lagging))
+		for i := 0; i < 20; i++ {
+			mustMove(t, c.inventories[leader].Reserve(inventory.Seat(fmt.Sprintf("A%d", i)), testActor))
+		}
+		c.nodes[leader].lock.Lock()
+		snapshotted := c.nodes[leader].snapshot.LastIndex
+		c.nodes[leader].lock.Unlock()
+		if snapshotted == 0 {
+			t.Fatalf("Expected the leader to have taken a snapshot")
+		}
+
+		c.network.Heal()
+		c.expectEventually([]string{lagging}, map[inventory.Seat]inventory.SeatStatus{"A0": inventory.RESERVED, "A19": inventory.RESERVED})
+	})
+
+	t.Run("Nodes restart from what they saved", func(t *testing.T) {
+		c := newCluster(t, 3, 5)
+		defer c.stop()
+
+		leader := c.leader()
+		for i := 0; i < 8; i++ {
+			mustMove(t, c.inventories[leader].Reserve(inventory.Seat(fmt.Sprintf("A%d", i)), testActor))
+		}
+		c.expectEventually(c.ids, map[inventory.Seat]inventory.SeatStatus{"A7": inventory.RESERVED})
+
+		for _, id := range c.ids {
+			c.crash(id)
+		}
+		for _, id := range c.ids {
+			c.start(id)
+		}
+		mustMove(t, c.inventories[c.leader()].Buy("A0", testActor))
+		c.expectEventually(c.ids, map[inventory.Seat]inventory.SeatStatus{"A0": inventory.SOLD, "A7": inventory.RESERVED})
+	})
+
+	t.Run("A cluster of one moves seats on its own", func(t *testing.T) {
+		c := newCluster(t, 1, 0)
+		defer c.stop()
+
+		mustMove(t, c.inventories[c.leader()].Reserve("A1", testActor))
+	})
+
+	t.Run("Leaders dont trust the privileges of forwarded moves", func(t *testing.T) {
+		c := newCluster(t, 3, 0)
+		defer c.stop()
+
+		leaderID := c.leader()
+		followerID := c.others(leaderID)[0]
+		leader := c.inventories[leaderID]
+		mustMove(t, leader.Reserve("A1", testActor))
+		mustMove(t, leader.Buy("A1", testActor))
+
+		if err := c.inventories[followerID].CompareAndSet("A1", inventory.SOLD, inventory.FREE, true, testActor); !errors.Is(err, inventory.ErrNotApplied) {
+			t.Errorf("Expected a privileged CAS on a follower not to be forwarded, got %v", err)
+		}
+		forged := inventory.Move{Command: inventory.CAS, Seat: "A1", From: inventory.SOLD, To: inventory.FREE, Privileged: true, Actor: testActor}
+		if reply := c.nodes[leaderID].HandleForward(ForwardRequest{From: "intruder", Move: forged}); reply.Accepted {
+			t.Errorf("Expected a move forwarded by a node outside the cluster to be refused")
+		}
+		if reply := c.nodes[leaderID].HandleForward(ForwardRequest{From: followerID, Move: forged}); !reply.Accepted {
+			t.Errorf("Expected the leader to log the forwarded move")
+		}
+		//Moves apply in order, once A2 is reserved the forged move was applied or refused
+		mustMove(t, leader.Reserve("A2", testActor))
+		c.expectEventually(c.ids, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.SOLD, "A2": inventory.RESERVED})
+	})
+
+	t.Run("Leaders keep who asked followers for forwarded moves", func(t *testing.T) {
+		c := newCluster(t, 3, 0)
+		defer c.stop()
+
+		leaderID := c.leader()
+		followerID := c.others(leaderID)[0]
+		mustMove(t, c.inventories[followerID].Reserve("A1", testActor))
+
+		//The leader applies the move on its own time
+		var history []inventory.AuditEntry
+		for deadline := time.Now().Add(5 * time.Second); len(history) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			var err error
+			if history, err = c.inventories[leaderID].History("A1"); err != nil {
+				t.Fatalf("Unexpected error reading history: %v", err)
+			}
+		}
+		if len(history) != 1 || history[0].RemoteAddress != testActor.RemoteAddress || history[0].ConnectionID != testActor.ConnectionID {
+			t.Errorf("Expected the leader to record the client that asked [%s] for the move, got %+v", followerID, history)
+		}
+	})
+
+	t.Run("Ignores nodes outside the cluster", func(t *testing.T) {
+		c := newCluster(t, 3, 0)
+		defer c.stop()
+
+		leaderID := c.leader()
+		term, _, _ := c.nodes[leaderID].Status()
+		if reply := c.nodes[leaderID].HandleVote(VoteRequest{Term: term + 100, Candidate: "intruder", LastLogIndex: 100, LastLogTerm: term + 100}); reply.Granted {
+			t.Errorf("Expected no vote for a node outside the cluster")
+		}
+		c.nodes[leaderID].HandleAppend(AppendRequest{Term: term + 100, Leader: "intruder"})
+		if _, role, _ := c.nodes[leaderID].Status(); role != "leader" {
+			t.Errorf("Expected a node outside the cluster not to take over, [%s] is now a [%s]", leaderID, role)
+		}
+	})
+}
+
+func TestServe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer listener.Close()
+	id := listener.Addr().String()
+	node, err := NewNode(Config{ID: id}, inventory.NewInventory(), NewTCPTransport(DEFAULT_RPC_TIMEOUT, "s3cr3t"), NewMemoryStorage(), logging.NewLogger(false))
+	if err != nil {
+		t.Fatalf("Unexpected error creating node: %v", err)
+	}
+	go Serve(listener, node, "s3cr3t", logging.NewLogger(false))
+
+	if _, err := NewTCPTransport(DEFAULT_RPC_TIMEOUT, "guess").RequestVote(id, VoteRequest{Term: 1, Candidate: "intruder"}); err == nil {
+		t.Errorf("Expected a node with the wrong secret to be dropped")
+	}
+	if _, err := NewTCPTransport(DEFAULT_RPC_TIMEOUT, "s3cr3t").RequestVote(id, VoteRequest{Term: 1, Candidate: "n2"}); err != nil {
+		t.Errorf("Unexpected error from a node with the secret: %v", err)
+	}
+}
+
+func TestTCPTransport(t *testing.T) {
+	var listeners []net.Listener
+	var ids []string
+	for i := 0; i < 3; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error listening: %v", err)
+		}
+		defer listener.Close()
+		listeners = append(listeners, listener)
+		ids = append(ids, listener.Addr().String())
+	}
+
+	var inventories []*inventory.Inventory
+	for i, id := range ids {
+		var peers []string
+		for _, peer := range ids {
+			if peer != id {
+				peers = append(peers, peer)
+			}
+		}
+		config := Config{ID: id, Peers: peers, ElectionTimeout: 100 * time.Millisecond, HeartbeatInterval: 20 * time.Millisecond}
+		seatInventory := inventory.NewInventory()
+		node, err := NewNode(config, seatInventory, NewTCPTransport(DEFAULT_RPC_TIMEOUT, "s3cr3t"), NewMemoryStorage(), logging.NewLogger(false))
+		if err != nil {
+			t.Fatalf("Unexpected error starting node: %v", err)
+		}
+		defer node.Stop()
+		go Serve(listeners[i], node, "s3cr3t", logging.NewLogger(false))
+		node.Start()
+		inventories = append(inventories, seatInventory)
+	}
+
+	//Any node will do, followers pass the move on once there is a leader
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := inventories[0].Reserve("A1", testActor)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, inventory.ErrNotApplied) || time.Now().After(deadline) {
+			t.Fatalf("Unexpected error moving seat: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for {
+		matches := true
+		for _, seatInventory := range inventories {
+			status, _ := seatInventory.Get("A1")
+			matches = matches && status == inventory.RESERVED
+		}
+		if matches {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected every node to have seat [A1] reserved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,189 @@
+package raft
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+)
+
+// DEFAULT_RPC_TIMEOUT is how long a request to another node can take before it counts as unreachable
+const DEFAULT_RPC_TIMEOUT = time.Second
+
+// MAX_SECRET_SIZE is the longest cluster secret, a connection sending more before a new line is dropped
+const MAX_SECRET_SIZE = 256
+
+// ErrWrongSecret is why connections from something that isn't a node of the cluster are dropped
+var ErrWrongSecret = errors.New("wrong cluster secret")
+
+// Service answers other nodes' requests over net/rpc
+type Service struct {
+	node *Node
+}
+
+func (s *Service) RequestVote(request VoteRequest, reply *VoteReply) error {
+	*reply = s.node.HandleVote(request)
+	return nil
+}
+
+func (s *Service) AppendEntries(request AppendRequest, reply *AppendReply) error {
+	*reply = s.node.HandleAppend(request)
+	return nil
+}
+
+func (s *Service) InstallSnapshot(request SnapshotRequest, reply *SnapshotReply) error {
+	*reply = s.node.HandleSnapshot(request)
+	return nil
+}
+
+func (s *Service) Forward(request ForwardRequest, reply *ForwardReply) error {
+	*reply = s.node.HandleForward(request)
+	return nil
+}
+
+// Serve answers the other nodes on the listener until it is closed. Connections must start with the
+// cluster secret on a line of its own, anything else could vote, lead or append moves.
+func Serve(listener net.Listener, node *Node, secret string, logger *logging.Logger) error {
+	if secret == "" {
+		return errors.New("raft nodes need a cluster secret")
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("Raft", &Service{node}); err != nil {
+		return err
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func(conn net.Conn) {
+			if err := authenticate(conn, secret); err != nil {
+				logger.Errorf("Dropping connection from [%s]: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			logger.Infof("Node [%s] connected", conn.RemoteAddr())
+			server.ServeConn(conn)
+		}(conn)
+	}
+}
+
+// authenticate reads the secret a byte at a time, so nothing the rpc server needs after it is buffered away
+func authenticate(conn net.Conn, secret string) error {
+	conn.SetReadDeadline(time.Now().Add(DEFAULT_RPC_TIMEOUT))
+	defer conn.SetReadDeadline(time.Time{})
+
+	sent := make([]byte, 0, len(secret))
+	next := make([]byte, 1)
+	for {
+		if _, err := conn.Read(next); err != nil {
+			return err
+		}
+		if next[0] == '\n' {
+			break
+		}
+		if len(sent) == MAX_SECRET_SIZE {
+			return ErrWrongSecret
+		}
+		sent = append(sent, next[0])
+	}
+	if subtle.ConstantTimeCompare(sent, []byte(secret)) != 1 {
+		return ErrWrongSecret
+	}
+	return nil
+}
+
+// TCPTransport reaches other nodes over TCP, their IDs are the addresses they Serve on
+type TCPTransport struct {
+	timeout time.Duration
+	secret  string
+	lock    sync.Mutex
+	clients map[string]*rpc.Client
+}
+
+// NewTCPTransport sends the cluster secret the other nodes Serve with on every connection
+func NewTCPTransport(timeout time.Duration, secret string) *TCPTransport {
+	return &TCPTransport{timeout: timeout, secret: secret, clients: map[string]*rpc.Client{}}
+}
+
+func (t *TCPTransport) client(to string) (*rpc.Client, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if client, found := t.clients[to]; found {
+		return client, nil
+	}
+	conn, err := net.DialTimeout("tcp", to, t.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Now().Add(t.timeout))
+	if _, err := conn.Write([]byte(t.secret + "\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Time{})
+	client := rpc.NewClient(conn)
+	t.clients[to] = client
+	return client, nil
+}
+
+// forget drops the connection to a node that failed a request, the next one dials again
+func (t *TCPTransport) forget(to string, client *rpc.Client) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.clients[to] == client {
+		delete(t.clients, to)
+	}
+	client.Close()
+}
+
+func (t *TCPTransport) call(to string, method string, request interface{}, reply interface{}) error {
+	client, err := t.client(to)
+	if err != nil {
+		return fmt.Errorf("%v: %v", ErrUnreachable, err)
+	}
+
+	timeout := time.NewTimer(t.timeout)
+	defer timeout.Stop()
+	call := client.Go("Raft."+method, request, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			t.forget(to, client)
+			return fmt.Errorf("%v: %v", ErrUnreachable, call.Error)
+		}
+		return nil
+	case <-timeout.C:
+		t.forget(to, client)
+		return fmt.Errorf("%v: [%s] to [%s] timed out", ErrUnreachable, method, to)
+	}
+}
+
+func (t *TCPTransport) RequestVote(to string, request VoteRequest) (VoteReply, error) {
+	var reply VoteReply
+	err := t.call(to, "RequestVote", request, &reply)
+	return reply, err
+}
+
+func (t *TCPTransport) AppendEntries(to string, request AppendRequest) (AppendReply, error) {
+	var reply AppendReply
+	err := t.call(to, "AppendEntries", request, &reply)
+	return reply, err
+}
+
+func (t *TCPTransport) InstallSnapshot(to string, request SnapshotRequest) (SnapshotReply, error) {
+	var reply SnapshotReply
+	err := t.call(to, "InstallSnapshot", request, &reply)
+	return reply, err
+}
+
+func (t *TCPTransport) Forward(to string, request ForwardRequest) (ForwardReply, error) {
+	var reply ForwardReply
+	err := t.call(to, "Forward", request, &reply)
+	return reply, err
+}
@@ -0,0 +1,304 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// State is what a node must not forget across restarts
+type State struct {
+	Term     int64
+	VotedFor string
+	Snapshot Snapshot
+	Log      []Entry
+}
+
+// Storage keeps a node's state, Save only returns once the state would survive a crash
+type Storage interface {
+	Save(state State) error
+	Load() (State, error)
+}
+
+// MemoryStorage keeps state for as long as the process runs, for nodes restarted in tests
+type MemoryStorage struct {
+	lock  sync.Mutex
+	state State
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (m *MemoryStorage) Save(state State) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	state.Log = append([]Entry(nil), state.Log...)
+	m.state = state
+	return nil
+}
+
+func (m *MemoryStorage) Load() (State, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	state := m.state
+	state.Log = append([]Entry(nil), state.Log...)
+	return state, nil
+}
+
+// FileStorage keeps the term, vote and log in a file each save appends the new entries to, syncing only them.
+// The file is only rewritten when a snapshot replaces entries, entries are dropped for the leader's or the
+// node restarted. The snapshot goes in a file of its own, only written when there is a new one.
+type FileStorage struct {
+	path          string
+	lock          sync.Mutex
+	snapshotSaved int64
+	// log is the file appended to, nil until it is rewritten. Its records are encoded one after the other so
+	// gob only describes their types once, at the start of the file.
+	log     *os.File
+	encoder *gob.Encoder
+	encoded bytes.Buffer
+	// What the log file has, to tell what a save adds to it
+	savedEntries  int
+	savedLastTerm int64
+	savedTerm     int64
+	savedVote     string
+}
+
+// logRecord is what a save appends to the log file, the term and vote then and the entries following the
+// ones already in the file. The first record of the file also has where the log starts.
+type logRecord struct {
+	Term     int64
+	VotedFor string
+	Snapshot Snapshot
+	Entries  []Entry
+}
+
+// RECORD_HEADER_SIZE is the length and checksum before each record, so a record a crash cut short is noticed
+const RECORD_HEADER_SIZE = 8
+
+func NewFileStorage(path string) *FileStorage {
+	return &FileStorage{path: path, snapshotSaved: -1}
+}
+
+// Save writes the snapshot before the log that follows it, a crash in between leaves a log that starts
+// before the snapshot and Load drops the entries in it
+func (f *FileStorage) Save(state State) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.log == nil || state.Snapshot.LastIndex != f.snapshotSaved || !f.extends(state.Log) {
+		return f.rewrite(state)
+	}
+	if state.Term == f.savedTerm && state.VotedFor == f.savedVote && len(state.Log) == f.savedEntries {
+		return nil
+	}
+
+	err := f.writeRecord(f.log, logRecord{Term: state.Term, VotedFor: state.VotedFor, Entries: state.Log[f.savedEntries:]})
+	if err == nil {
+		err = f.log.Sync()
+	}
+	if err != nil {
+		//What was written might be half a record, the file is rewritten rather than appended after it
+		f.closeLog()
+		return err
+	}
+	f.saved(state)
+	return nil
+}
+
+// extends tells whether the log only adds entries to the ones saved, entries with the same index and term
+// are the same entries with the same entries before them
+func (f *FileStorage) extends(log []Entry) bool {
+	return len(log) >= f.savedEntries && (f.savedEntries == 0 || log[f.savedEntries-1].Term == f.savedLastTerm)
+}
+
+func (f *FileStorage) saved(state State) {
+	f.savedEntries = len(state.Log)
+	f.savedLastTerm = 0
+	if len(state.Log) > 0 {
+		f.savedLastTerm = state.Log[len(state.Log)-1].Term
+	}
+	f.savedTerm = state.Term
+	f.savedVote = state.VotedFor
+}
+
+func (f *FileStorage) rewrite(state State) error {
+	if state.Snapshot.LastIndex != f.snapshotSaved {
+		if err := writeAtomically(f.path+".snapshot", state.Snapshot); err != nil {
+			return err
+		}
+		f.snapshotSaved = state.Snapshot.LastIndex
+	}
+
+	f.closeLog()
+	f.encoder = gob.NewEncoder(&f.encoded)
+	first := logRecord{
+		Term:     state.Term,
+		VotedFor: state.VotedFor,
+		Snapshot: Snapshot{LastIndex: state.Snapshot.LastIndex, LastTerm: state.Snapshot.LastTerm},
+		Entries:  state.Log,
+	}
+	err := replaceFile(f.path, func(file io.Writer) error { return f.writeRecord(file, first) })
+	if err != nil {
+		return err
+	}
+	if f.log, err = os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0); err != nil {
+		return err
+	}
+	f.saved(state)
+	return nil
+}
+
+// writeRecord writes the record after its length and checksum in a single write
+func (f *FileStorage) writeRecord(file io.Writer, record logRecord) error {
+	f.encoded.Reset()
+	f.encoded.Write(make([]byte, RECORD_HEADER_SIZE))
+	if err := f.encoder.Encode(record); err != nil {
+		return err
+	}
+	written := f.encoded.Bytes()
+	binary.BigEndian.PutUint32(written[0:4], uint32(len(written)-RECORD_HEADER_SIZE))
+	binary.BigEndian.PutUint32(written[4:8], crc32.ChecksumIEEE(written[RECORD_HEADER_SIZE:]))
+	_, err := file.Write(written)
+	return err
+}
+
+func (f *FileStorage) closeLog() {
+	if f.log != nil {
+		f.log.Close()
+		f.log = nil
+	}
+}
+
+// Load returns an empty state if nothing was saved yet. Records a crash cut short were never saved, they are
+// dropped when the first save rewrites the file.
+func (f *FileStorage) Load() (State, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.closeLog()
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return State{}, err
+	}
+
+	var state State
+	var payloads bytes.Buffer
+	decoder := gob.NewDecoder(&payloads)
+	reader := bufio.NewReader(file)
+	header := make([]byte, RECORD_HEADER_SIZE)
+	for records, remaining := 0, info.Size(); ; records++ {
+		if _, err := io.ReadFull(reader, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return State{}, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		if remaining -= RECORD_HEADER_SIZE + length; remaining < 0 {
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return State{}, err
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			break
+		}
+
+		var record logRecord
+		payloads.Write(payload)
+		if err := decoder.Decode(&record); err != nil {
+			return State{}, err
+		}
+		if records == 0 {
+			state.Snapshot = record.Snapshot
+		}
+		state.Term, state.VotedFor = record.Term, record.VotedFor
+		state.Log = append(state.Log, record.Entries...)
+	}
+
+	var snapshot Snapshot
+	if _, err := read(f.path+".snapshot", &snapshot); err != nil {
+		return State{}, err
+	}
+
+	//The log saved with the previous snapshot may have entries the snapshot has too
+	if skip := snapshot.LastIndex - state.Snapshot.LastIndex; skip > 0 {
+		if skip > int64(len(state.Log)) {
+			skip = int64(len(state.Log))
+		}
+		state.Log = state.Log[skip:]
+	}
+	state.Snapshot = snapshot
+	f.snapshotSaved = snapshot.LastIndex
+	return state, nil
+}
+
+// Close closes the log file, saving again rewrites it
+func (f *FileStorage) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closeLog()
+	return nil
+}
+
+// writeAtomically writes to a temporary file and renames it over the old one, a crash leaves one or the other
+func writeAtomically(path string, value interface{}) error {
+	return replaceFile(path, func(file io.Writer) error { return gob.NewEncoder(file).Encode(value) })
+}
+
+func replaceFile(path string, write func(io.Writer) error) error {
+	temporary, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = write(temporary)
+	if err == nil {
+		err = temporary.Sync()
+	}
+	closeErr := temporary.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporary.Name())
+		return err
+	}
+	if err := os.Rename(temporary.Name(), path); err != nil {
+		return err
+	}
+
+	directory, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+	return directory.Sync()
+}
+
+func read(path string, value interface{}) (bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	return true, gob.NewDecoder(file).Decode(value)
+}
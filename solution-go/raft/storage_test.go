@@ -0,0 +1,146 @@
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+)
+
+func TestFileStorage(t *testing.T) {
+	directory, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "state")
+
+	t.Run("Starts empty", func(t *testing.T) {
+		state, err := NewFileStorage(path).Load()
+		if err != nil || !reflect.DeepEqual(state, State{}) {
+			t.Errorf("Expected an empty state, got %v and error %v", state, err)
+		}
+	})
+
+	t.Run("Loads what was saved", func(t *testing.T) {
+		saved := State{
+			Term:     3,
+			VotedFor: "n2",
+			Snapshot: Snapshot{LastIndex: 2, LastTerm: 1, Seats: []inventory.SeatListing{{Seat: "A1", Status: inventory.SOLD}}},
+			Log:      []Entry{{Term: 3, Move: inventory.Move{Command: inventory.RESERVE, Seat: "A2", To: inventory.RESERVED, Actor: testActor}}},
+		}
+		if err := NewFileStorage(path).Save(saved); err != nil {
+			t.Fatalf("Unexpected error saving: %v", err)
+		}
+
+		loaded, err := NewFileStorage(path).Load()
+		if err != nil || !reflect.DeepEqual(loaded, saved) {
+			t.Errorf("Expected %v, got %v and error %v", saved, loaded, err)
+		}
+	})
+
+	t.Run("Drops entries a newer snapshot has when the log wasn't saved after it", func(t *testing.T) {
+		storage := NewFileStorage(path)
+		log := []Entry{{Term: 1}, {Term: 1}, {Term: 2}}
+		if err := storage.Save(State{Term: 2, Log: log}); err != nil {
+			t.Fatalf("Unexpected error saving: %v", err)
+		}
+		//As if the process crashed between writing the snapshot and the log
+		snapshot := Snapshot{LastIndex: 2, LastTerm: 1, Seats: []inventory.SeatListing{{Seat: "A1", Status: inventory.RESERVED}}}
+		if err := writeAtomically(path+".snapshot", snapshot); err != nil {
+			t.Fatalf("Unexpected error saving snapshot: %v", err)
+		}
+
+		loaded, err := NewFileStorage(path).Load()
+		if err != nil {
+			t.Fatalf("Unexpected error loading: %v", err)
+		}
+		if !reflect.DeepEqual(loaded.Snapshot, snapshot) || !reflect.DeepEqual(loaded.Log, log[2:]) {
+			t.Errorf("Expected snapshot %v and log %v, got %v", snapshot, log[2:], loaded)
+		}
+	})
+
+	t.Run("Only appends what changed", func(t *testing.T) {
+		os.Remove(path + ".snapshot")
+		storage := NewFileStorage(path)
+		defer storage.Close()
+		var log []Entry
+		for i := 0; i < 100; i++ {
+			log = append(log, Entry{Term: 1, Move: inventory.Move{Command: inventory.RESERVE, Seat: inventory.Seat(fmt.Sprintf("A%d", i)), To: inventory.RESERVED}})
+		}
+		if err := storage.Save(State{Term: 1, Log: log}); err != nil {
+			t.Fatalf("Unexpected error saving: %v", err)
+		}
+		before, _ := ioutil.ReadFile(path)
+
+		saved := State{Term: 2, VotedFor: "n3", Log: append(log, Entry{Term: 2})}
+		if err := storage.Save(saved); err != nil {
+			t.Fatalf("Unexpected error saving: %v", err)
+		}
+		after, _ := ioutil.ReadFile(path)
+		if !bytes.HasPrefix(after, before) || len(after)-len(before) > len(before)/10 {
+			t.Errorf("Expected the new entry to be appended to the [%d] bytes saved, the file has [%d] bytes now", len(before), len(after))
+		}
+
+		loaded, err := NewFileStorage(path).Load()
+		if err != nil || !reflect.DeepEqual(loaded, saved) {
+			t.Errorf("Expected %v, got %v and error %v", saved, loaded, err)
+		}
+	})
+
+	t.Run("Rewrites the log when entries are dropped for others", func(t *testing.T) {
+		storage := NewFileStorage(path)
+		defer storage.Close()
+		if err := storage.Save(State{Term: 1, Log: []Entry{{Term: 1}, {Term: 1}, {Term: 1}}}); err != nil {
+			t.Fatalf("Unexpected error saving: %v", err)
+		}
+		//As long as before, but not the same entries
+		saved := State{Term: 2, Log: []Entry{{Term: 1}, {Term: 2}, {Term: 2}}}
+		if err := storage.Save(saved); err != nil {
+			t.Fatalf("Unexpected error saving: %v", err)
+		}
+
+		loaded, err := NewFileStorage(path).Load()
+		if err != nil || !reflect.DeepEqual(loaded, saved) {
+			t.Errorf("Expected %v, got %v and error %v", saved, loaded, err)
+		}
+	})
+
+	t.Run("Drops what a crash cut short", func(t *testing.T) {
+		storage := NewFileStorage(path)
+		log := []Entry{{Term: 1}, {Term: 1}}
+		if err := storage.Save(State{Term: 1, Log: log}); err != nil {
+			t.Fatalf("Unexpected error saving: %v", err)
+		}
+		saved, _ := ioutil.ReadFile(path)
+		if err := storage.Save(State{Term: 1, Log: append(log, Entry{Term: 1})}); err != nil {
+			t.Fatalf("Unexpected error saving: %v", err)
+		}
+		storage.Close()
+		//As if the process crashed halfway through appending the last entry
+		written, _ := ioutil.ReadFile(path)
+		if err := os.Truncate(path, int64(len(saved)+(len(written)-len(saved))/2)); err != nil {
+			t.Fatalf("Unexpected error truncating: %v", err)
+		}
+
+		restarted := NewFileStorage(path)
+		defer restarted.Close()
+		loaded, err := restarted.Load()
+		if err != nil || !reflect.DeepEqual(loaded, State{Term: 1, Log: log}) {
+			t.Fatalf("Expected the entries saved before the crash, got %v and error %v", loaded, err)
+		}
+		saved2 := State{Term: 1, Log: append(log, Entry{Term: 1}, Entry{Term: 1})}
+		if err := restarted.Save(saved2); err != nil {
+			t.Fatalf("Unexpected error saving: %v", err)
+		}
+		loaded, err = NewFileStorage(path).Load()
+		if err != nil || !reflect.DeepEqual(loaded, saved2) {
+			t.Errorf("Expected %v, got %v and error %v", saved2, loaded, err)
+		}
+	})
+}
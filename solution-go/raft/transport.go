@@ -0,0 +1,178 @@
+package raft
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+)
+
+// ErrUnreachable is returned by transports when the node can't be reached
+var ErrUnreachable = errors.New("node unreachable")
+
+type VoteRequest struct {
+	Term         int64
+	Candidate    string
+	LastLogIndex int64
+	LastLogTerm  int64
+}
+
+type VoteReply struct {
+	Term    int64
+	Granted bool
+}
+
+type AppendRequest struct {
+	Term         int64
+	Leader       string
+	PrevLogIndex int64
+	PrevLogTerm  int64
+	Entries      []Entry
+	LeaderCommit int64
+}
+
+// AppendReply tells a leader whose entries didn't follow on from the follower's log where to send from next
+type AppendReply struct {
+	Term          int64
+	Success       bool
+	ConflictIndex int64
+}
+
+type SnapshotRequest struct {
+	Term     int64
+	Leader   string
+	Snapshot Snapshot
+}
+
+type SnapshotReply struct {
+	Term int64
+}
+
+// ForwardRequest is a move a follower passes on to the leader, still the move of the client that asked the
+// follower for it. The leader can't check that client's privileges, so it is only privileged if its command
+// always is.
+type ForwardRequest struct {
+	From string
+	Move inventory.Move
+}
+
+// ForwardReply is where the leader put the move in its log, if it still was the leader
+type ForwardReply struct {
+	Accepted bool
+	Index    int64
+	Term     int64
+}
+
+// Transport carries requests between the nodes of a cluster, identified by their IDs
+type Transport interface {
+	RequestVote(to string, request VoteRequest) (VoteReply, error)
+	AppendEntries(to string, request AppendRequest) (AppendReply, error)
+	InstallSnapshot(to string, request SnapshotRequest) (SnapshotReply, error)
+	Forward(to string, request ForwardRequest) (ForwardReply, error)
+}
+
+// MemoryNetwork connects nodes in the same process, and can cut them off from each other
+type MemoryNetwork struct {
+	lock  sync.Mutex
+	nodes map[string]*Node
+	// groups are which side of a partition each node is on, nodes only reach others in their group
+	groups map[string]int
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{nodes: map[string]*Node{}, groups: map[string]int{}}
+}
+
+// Join makes the node reachable as id
+func (m *MemoryNetwork) Join(id string, node *Node) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.nodes[id] = node
+}
+
+// Leave makes the node unreachable, as if it had crashed
+func (m *MemoryNetwork) Leave(id string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.nodes, id)
+}
+
+// Partition splits the network, nodes can only reach nodes on their own side. Nodes on no side are
+// together on a side of their own.
+func (m *MemoryNetwork) Partition(sides ...[]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.groups = map[string]int{}
+	for i, side := range sides {
+		for _, id := range side {
+			m.groups[id] = i + 1
+		}
+	}
+}
+
+// Heal lets every node reach every other again
+func (m *MemoryNetwork) Heal() {
+	m.Partition()
+}
+
+// Transport is how the node with the ID reaches the others
+func (m *MemoryNetwork) Transport(from string) Transport {
+	return &memoryTransport{network: m, from: from}
+}
+
+func (m *MemoryNetwork) reach(from string, to string) (*Node, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	node, found := m.nodes[to]
+	_, fromUp := m.nodes[from]
+	if !found || !fromUp || m.groups[from] != m.groups[to] {
+		return nil, ErrUnreachable
+	}
+	return node, nil
+}
+
+type memoryTransport struct {
+	network *MemoryNetwork
+	from    string
+}
+
+func (t *memoryTransport) RequestVote(to string, request VoteRequest) (VoteReply, error) {
+	node, err := t.network.reach(t.from, to)
+	if err != nil {
+		return VoteReply{}, err
+	}
+	reply := node.HandleVote(request)
+	//The answer can be lost on the way back too
+	_, err = t.network.reach(to, t.from)
+	return reply, err
+}
+
+func (t *memoryTransport) AppendEntries(to string, request AppendRequest) (AppendReply, error) {
+	node, err := t.network.reach(t.from, to)
+	if err != nil {
+		return AppendReply{}, err
+	}
+	reply := node.HandleAppend(request)
+	_, err = t.network.reach(to, t.from)
+	return reply, err
+}
+
+func (t *memoryTransport) InstallSnapshot(to string, request SnapshotRequest) (SnapshotReply, error) {
+	node, err := t.network.reach(t.from, to)
+	if err != nil {
+		return SnapshotReply{}, err
+	}
+	reply := node.HandleSnapshot(request)
+	_, err = t.network.reach(to, t.from)
+	return reply, err
+}
+
+func (t *memoryTransport) Forward(to string, request ForwardRequest) (ForwardReply, error) {
+	node, err := t.network.reach(t.from, to)
+	if err != nil {
+		return ForwardReply{}, err
+	}
+	reply := node.HandleForward(request)
+	_, err = t.network.reach(to, t.from)
+	return reply, err
+}
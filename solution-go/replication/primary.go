@@ -0,0 +1,148 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/protocol"
+)
+
+// HANDSHAKE_TIMEOUT is how long a replica has to say where it wants to resume from
+const HANDSHAKE_TIMEOUT = 10 * time.Second
+
+// Authorizer decides whether whoever is on the connection can replicate, given the token it sent, which is
+// empty if it sent none. Replicas get every seat, so it should only let through those trusted with them all.
+type Authorizer func(conn net.Conn, token string) error
+
+// Primary streams the transitions of an inventory to the replicas that connect to it. Sequences restart
+// with every process, so each one gets a new id and replicas of an earlier one are sent a snapshot.
+type Primary struct {
+	seatInventory *inventory.Inventory
+	authorize     Authorizer
+	id            string
+	logger        *logging.Logger
+}
+
+// ID tells replicas apart which process they are following
+func (p *Primary) ID() string {
+	return p.id
+}
+
+// Serve accepts replicas on the listener until accepting fails
+func (p *Primary) Serve(listener net.Listener) error {
+	p.logger.Infof("Accepting replicas at [%s] as primary [%s]", listener.Addr(), p.id)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			p.logger.Errorf("Error accepting replica: %v", err)
+			return err
+		}
+		go p.serve(conn)
+	}
+}
+
+func (p *Primary) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	conn.SetReadDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		p.logger.Errorf("Error reading replication request from [%s]: %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	primaryID, after, token, err := parseReplicate(line)
+	if err == nil {
+		err = p.authorize(conn, token)
+	}
+	if err != nil {
+		p.logger.Errorf("Refusing replica [%s]: %v", conn.RemoteAddr(), err)
+		fmt.Fprintf(writer, "%s\n", protocol.FAIL)
+		writer.Flush()
+		return
+	}
+
+	var subscription *inventory.Subscription
+	if primaryID == p.id {
+		subscription, _, err = p.seatInventory.Subscribe("", after)
+		if err == nil {
+			p.logger.Infof("Replica [%s] resumes after sequence [%d]", conn.RemoteAddr(), after)
+			fmt.Fprintf(writer, "%s %s %d\n", RESUME, p.id, after)
+		}
+	}
+	if subscription == nil {
+		seats, snapshotSubscription, latest, err := p.seatInventory.Snapshot()
+		if err != nil {
+			p.logger.Errorf("Error taking snapshot for replica [%s]: %v", conn.RemoteAddr(), err)
+			return
+		}
+		subscription = snapshotSubscription
+		p.logger.Infof("Sending replica [%s] a snapshot of [%d] seats at sequence [%d]", conn.RemoteAddr(), len(seats), latest)
+		fmt.Fprintf(writer, "%s %s %d %d\n", SNAPSHOT, p.id, latest, len(seats))
+		for _, listing := range seats {
+			fmt.Fprintf(writer, "%s %s\n", listing.Seat, listing.Status)
+		}
+	}
+	defer p.seatInventory.Unsubscribe(subscription)
+
+	p.stream(conn, reader, writer, subscription)
+}
+
+// stream writes transitions as they happen, until the replica hangs up or falls behind
+func (p *Primary) stream(conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, subscription *inventory.Subscription) {
+	//Replicas only listen, anything they send or hanging up ends replication
+	hungUp := make(chan bool)
+	go func() {
+		reader.ReadString('\n')
+		close(hungUp)
+	}()
+
+	for _, t := range subscription.Backlog() {
+		fmt.Fprintf(writer, "%s\n", protocol.FormatTransition(t))
+	}
+	for {
+		//Only flush once there is nothing else waiting, so bursts go out together
+		if len(subscription.Events()) == 0 {
+			if err := writer.Flush(); err != nil {
+				p.logger.Errorf("Error replicating to [%s]: %v", conn.RemoteAddr(), err)
+				return
+			}
+		}
+
+		select {
+		case t, open := <-subscription.Events():
+			if !open {
+				if subscription.Lagged() {
+					p.logger.Infof("Replica [%s] fell behind, closing replication", conn.RemoteAddr())
+					fmt.Fprintf(writer, "%s\n", protocol.LAGGED)
+					writer.Flush()
+				}
+				return
+			}
+			fmt.Fprintf(writer, "%s\n", protocol.FormatTransition(t))
+		case <-hungUp:
+			p.logger.Infof("Replica [%s] hung up", conn.RemoteAddr())
+			return
+		}
+	}
+}
+
+// NewPrimary lets the replicas the authorizer lets through follow the inventory
+func NewPrimary(seatInventory *inventory.Inventory, authorize Authorizer, logger *logging.Logger) *Primary {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &Primary{
+		seatInventory: seatInventory,
+		authorize:     authorize,
+		id:            hex.EncodeToString(id),
+		logger:        logger,
+	}
+}
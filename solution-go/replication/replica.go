@@ -0,0 +1,253 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/protocol"
+)
+
+const (
+	DIAL_TIMEOUT   = 5 * time.Second
+	RETRY_INTERVAL = time.Second
+)
+
+var errLagged = errors.New("replica fell behind the primary")
+
+// Replica keeps a read only inventory in step with a primary, reconnecting whenever the connection drops,
+// until an operator promotes it. Promoting doesn't stop the old primary, whoever promotes must make sure
+// clients stop writing to it, or seats could be sold twice.
+type Replica struct {
+	seatInventory *inventory.Inventory
+	primary       string
+	token         string
+	tlsConfig     *tls.Config
+	actor         inventory.Actor
+	logger        *logging.Logger
+
+	lock      sync.Mutex
+	primaryID string
+	sequence  int64
+	conn      net.Conn
+	following bool
+	promoted  bool
+	stopped   chan struct{}
+	done      chan struct{}
+}
+
+// Position is the primary the replica follows and the sequence of the last transition it applied from it
+func (r *Replica) Position() (string, int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.primaryID, r.sequence
+}
+
+// Follow replicates the primary until the replica is promoted
+func (r *Replica) Follow() {
+	r.lock.Lock()
+	if r.following || r.promoted {
+		r.lock.Unlock()
+		return
+	}
+	r.following = true
+	r.lock.Unlock()
+	defer close(r.done)
+
+	for {
+		err := r.follow()
+		select {
+		case <-r.stopped:
+			return
+		default:
+		}
+
+		r.logger.Errorf("Replication from [%s] stopped, retrying in [%v]: %v", r.primary, RETRY_INTERVAL, err)
+		select {
+		case <-r.stopped:
+			return
+		case <-time.After(RETRY_INTERVAL):
+		}
+	}
+}
+
+// Promote stops replicating and lets clients move seats. Transitions still on their way from the primary
+// are lost.
+func (r *Replica) Promote() error {
+	r.lock.Lock()
+	if r.promoted {
+		r.lock.Unlock()
+		return inventory.NewKindError(protocol.ErrNotReplica, "already promoted, stopped replicating from [%s]", r.primary)
+	}
+	r.promoted = true
+	close(r.stopped)
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	following := r.following
+	r.lock.Unlock()
+
+	//Nothing from the old primary can be applied once clients may be moving seats
+	if following {
+		<-r.done
+	}
+	r.seatInventory.SetReadOnly(false)
+	r.logger.Infof("Promoted, no longer replicating from [%s]", r.primary)
+	return nil
+}
+
+// follow connects to the primary once and applies what it sends until something goes wrong
+func (r *Replica) follow() error {
+	conn, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r.lock.Lock()
+	if r.promoted {
+		r.lock.Unlock()
+		return nil
+	}
+	r.conn = conn
+	primaryID, sequence := r.primaryID, r.sequence
+	r.lock.Unlock()
+
+	if primaryID == "" {
+		primaryID = NO_PRIMARY
+	}
+	_, err = fmt.Fprintf(conn, "%s: %s %d %s\n", REPLICATE, primaryID, sequence, r.token)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	err = r.sync(reader)
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == protocol.LAGGED {
+			return errLagged
+		}
+
+		t, err := parseTransition(line)
+		if err != nil {
+			return err
+		}
+		if err := r.apply(t); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Replica) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: DIAL_TIMEOUT}
+	if r.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", r.primary, r.tlsConfig)
+	}
+	return dialer.Dial("tcp", r.primary)
+}
+
+// sync reads the answer to a replication request, restoring the snapshot if the primary sent one
+func (r *Replica) sync(reader *bufio.Reader) error {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+
+	switch {
+	case len(fields) == 3 && fields[0] == RESUME:
+		sequence, err := strconv.ParseInt(fields[2], 10, 64)
+		_, expected := r.Position()
+		if err != nil || sequence != expected {
+			return fmt.Errorf("primary resumed after sequence [%s], expected [%d]", fields[2], expected)
+		}
+		r.logger.Infof("Resuming replication from [%s] after sequence [%d]", r.primary, sequence)
+		return nil
+
+	case len(fields) == 4 && fields[0] == SNAPSHOT:
+		sequence, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid snapshot header [%s]", strings.TrimSpace(line))
+		}
+		count, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return fmt.Errorf("invalid snapshot header [%s]", strings.TrimSpace(line))
+		}
+
+		seats := make([]inventory.SeatListing, 0, count)
+		for len(seats) < count {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			listing, err := parseListing(line)
+			if err != nil {
+				return err
+			}
+			seats = append(seats, listing)
+		}
+
+		r.logger.Infof("Restoring snapshot of [%d] seats from primary [%s] at sequence [%d]", count, fields[1], sequence)
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		//Until the snapshot is fully restored the seats match no position to resume from
+		r.primaryID, r.sequence = "", 0
+		if err := r.seatInventory.Restore(seats, r.actor); err != nil {
+			return err
+		}
+		r.primaryID, r.sequence = fields[1], sequence
+		return nil
+	}
+	return fmt.Errorf("unexpected answer [%s] to replication request", strings.TrimSpace(line))
+}
+
+func (r *Replica) apply(t inventory.Transition) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	//A gap means something was lost, a snapshot is the only way to catch up
+	if t.Sequence != r.sequence+1 {
+		r.primaryID = ""
+		return fmt.Errorf("expected transition [%d] from the primary, got [%d]", r.sequence+1, t.Sequence)
+	}
+	err := r.seatInventory.Replicate(t.Seat, t.To, r.actor)
+	if err != nil {
+		return err
+	}
+	r.sequence = t.Sequence
+	return nil
+}
+
+// NewReplica makes the inventory read only and replicates the primary listening for replicas at the
+// address into it once Follow is called. It sends the primary the token, and connects over TLS when given
+// a tlsConfig.
+func NewReplica(seatInventory *inventory.Inventory, primary string, token string, tlsConfig *tls.Config, logger *logging.Logger) *Replica {
+	seatInventory.SetReadOnly(true)
+	return &Replica{
+		seatInventory: seatInventory,
+		primary:       primary,
+		token:         token,
+		tlsConfig:     tlsConfig,
+		actor:         inventory.Actor{RemoteAddress: primary},
+		logger:        logger,
+		stopped:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
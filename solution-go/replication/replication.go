@@ -0,0 +1,79 @@
+// Package replication copies an inventory to replicas over TCP, or TLS. A replica connects to the primary
+// with "REPLICATE: <primary id> <sequence> <token>", naming the last transition it applied, or "-" and 0 the
+// first time, and sending the token or admin secret that lets it replicate. Replicas identified by a client
+// certificate can leave the token out, and those the primary doesn't let replicate are answered FAIL.
+// The primary answers "RESUME <id> <sequence>" if it still has every transition after that one, or else
+// "SNAPSHOT <id> <sequence> <seats>" followed by one "<seat> <status>" line per seat. Either way it then
+// streams one EVENT line per transition, as subscribers get them, until the replica hangs up or falls so far
+// behind it is sent LAGGED and has to reconnect.
+package replication
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/protocol"
+)
+
+const (
+	REPLICATE = "REPLICATE"
+	RESUME    = "RESUME"
+	SNAPSHOT  = "SNAPSHOT"
+	// NO_PRIMARY is the id replicas send before they ever synced with a primary
+	NO_PRIMARY = "-"
+)
+
+// parseReplicate reads the primary id and sequence a replica wants to resume from, and its token
+func parseReplicate(line string) (string, int64, string, error) {
+	fields := strings.Fields(strings.TrimPrefix(line, REPLICATE+":"))
+	if !strings.HasPrefix(line, REPLICATE+":") || len(fields) < 2 || len(fields) > 3 {
+		return "", 0, "", fmt.Errorf("invalid replication request [%s]", redactToken(line))
+	}
+	sequence, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || sequence < 0 {
+		return "", 0, "", fmt.Errorf("invalid sequence in replication request [%s]", redactToken(line))
+	}
+	token := ""
+	if len(fields) == 3 {
+		token = fields[2]
+	}
+	return fields[0], sequence, token, nil
+}
+
+// redactToken keeps tokens out of the logs, they are whatever comes after the sequence
+func redactToken(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) > 3 {
+		return strings.Join(fields[:3], " ") + " <redacted>"
+	}
+	return strings.TrimSpace(line)
+}
+
+// parseTransition reads an EVENT line as written by protocol.FormatTransition
+func parseTransition(line string) (inventory.Transition, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 5 || fields[0] != protocol.EVENT || !inventory.IsStoredStatus(inventory.Seat(fields[2]), fields[3]) || !inventory.IsStoredStatus(inventory.Seat(fields[2]), fields[4]) {
+		return inventory.Transition{}, fmt.Errorf("invalid transition [%s]", line)
+	}
+	sequence, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return inventory.Transition{}, fmt.Errorf("invalid sequence in transition [%s]", line)
+	}
+	return inventory.Transition{
+		Sequence: sequence,
+		Seat:     inventory.Seat(fields[2]),
+		From:     inventory.SeatStatus(fields[3]),
+		To:       inventory.SeatStatus(fields[4]),
+	}, nil
+}
+
+// parseListing reads a "<seat> <status>" line of a snapshot
+func parseListing(line string) (inventory.SeatListing, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 || !inventory.IsStoredStatus(inventory.Seat(fields[0]), fields[1]) {
+		return inventory.SeatListing{}, fmt.Errorf("invalid seat [%s] in snapshot", line)
+	}
+	return inventory.SeatListing{Seat: inventory.Seat(fields[0]), Status: inventory.SeatStatus(fields[1])}, nil
+}
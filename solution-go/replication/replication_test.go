@@ -0,0 +1,293 @@
+package replication
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/protocol"
+)
+
+var testActor = inventory.Actor{ConnectionID: 1, RemoteAddress: "127.0.0.1:5000"}
+
+// serve lets replicas follow the inventory at a loopback address, or at the address given if not empty
+func serve(t *testing.T, seatInventory *inventory.Inventory, address string) (*Primary, net.Listener) {
+	if address == "" {
+		address = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("Error listening for replicas: %v", err)
+	}
+	primary := NewPrimary(seatInventory, authorizeToken, logging.NewLogger(false))
+	go primary.Serve(listener)
+	return primary, listener
+}
+
+// authorizeToken only lets replicas sending s3cr3t replicate
+func authorizeToken(conn net.Conn, token string) error {
+	if token != "s3cr3t" {
+		return errors.New("wrong token")
+	}
+	return nil
+}
+
+func follow(t *testing.T, address string) (*inventory.Inventory, *Replica) {
+	seatInventory := inventory.NewInventory()
+	replica := NewReplica(seatInventory, address, "s3cr3t", nil, logging.NewLogger(false))
+	go replica.Follow()
+	return seatInventory, replica
+}
+
+// expectEventually waits for the seats to reach the statuses, replication takes a while
+func expectEventually(t *testing.T, seatInventory *inventory.Inventory, expected map[inventory.Seat]inventory.SeatStatus) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		actual := map[inventory.Seat]inventory.SeatStatus{}
+		matches := true
+		for seat, status := range expected {
+			actual[seat], _ = seatInventory.Get(seat)
+			matches = matches && actual[seat] == status
+		}
+		if matches {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected seats to be %v, got %v", expected, actual)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func mustMove(t *testing.T, err error) {
+	if err != nil {
+		t.Fatalf("Unexpected error moving seat: %v", err)
+	}
+}
+
+// request sends a replication request with the token and returns the connection and the first line of the answer
+func request(t *testing.T, address string, primaryID string, sequence int64, token string) (net.Conn, *bufio.Reader, string) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Error connecting to primary: %v", err)
+	}
+	fmt.Fprintf(conn, "%s: %s %d %s\n", REPLICATE, primaryID, sequence, token)
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Error reading answer to replication request: %v", err)
+	}
+	return conn, reader, strings.TrimSpace(line)
+}
+
+func TestPrimary(t *testing.T) {
+	t.Run("Sends new replicas a snapshot then every transition", func(t *testing.T) {
+		seatInventory := inventory.NewInventory()
+		mustMove(t, seatInventory.Reserve("A1", testActor))
+		mustMove(t, seatInventory.Reserve("A2", testActor))
+		mustMove(t, seatInventory.Buy("A2", testActor))
+		primary, listener := serve(t, seatInventory, "")
+		defer listener.Close()
+
+		conn, reader, line := request(t, listener.Addr().String(), NO_PRIMARY, 0, "s3cr3t")
+		defer conn.Close()
+		if expected := fmt.Sprintf("%s %s 3 2", SNAPSHOT, primary.ID()); line != expected {
+			t.Fatalf("Expected [%s], got [%s]", expected, line)
+		}
+
+		mustMove(t, seatInventory.Reserve("A3", testActor))
+		for _, expected := range []string{"A1 RESERVED", "A2 SOLD", "EVENT 4 A3 FREE RESERVED"} {
+			if line, _ := reader.ReadString('\n'); strings.TrimSpace(line) != expected {
+				t.Errorf("Expected [%s], got [%s]", expected, line)
+			}
+		}
+	})
+
+	t.Run("Resumes replicas it still has the transitions for", func(t *testing.T) {
+		seatInventory := inventory.NewInventory()
+		mustMove(t, seatInventory.Reserve("A1", testActor))
+		mustMove(t, seatInventory.Buy("A1", testActor))
+		primary, listener := serve(t, seatInventory, "")
+		defer listener.Close()
+
+		conn, reader, line := request(t, listener.Addr().String(), primary.ID(), 1, "s3cr3t")
+		defer conn.Close()
+		if expected := fmt.Sprintf("%s %s 1", RESUME, primary.ID()); line != expected {
+			t.Fatalf("Expected [%s], got [%s]", expected, line)
+		}
+		if line, _ := reader.ReadString('\n'); strings.TrimSpace(line) != "EVENT 2 A1 RESERVED SOLD" {
+			t.Errorf("Expected the transition after the one resumed from, got [%s]", line)
+		}
+	})
+
+	t.Run("Sends a snapshot to replicas of another primary", func(t *testing.T) {
+		seatInventory := inventory.NewInventory()
+		mustMove(t, seatInventory.Reserve("A1", testActor))
+		_, listener := serve(t, seatInventory, "")
+		defer listener.Close()
+
+		conn, _, line := request(t, listener.Addr().String(), "0123456789abcdef", 1, "s3cr3t")
+		defer conn.Close()
+		if !strings.HasPrefix(line, SNAPSHOT+" ") {
+			t.Errorf("Expected a snapshot, got [%s]", line)
+		}
+	})
+
+	t.Run("Refuses replicas it doesn't authorize", func(t *testing.T) {
+		seatInventory := inventory.NewInventory()
+		mustMove(t, seatInventory.Reserve("A1", testActor))
+		_, listener := serve(t, seatInventory, "")
+		defer listener.Close()
+
+		for _, token := range []string{"", "guess"} {
+			conn, _, line := request(t, listener.Addr().String(), NO_PRIMARY, 0, token)
+			conn.Close()
+			if line != protocol.FAIL {
+				t.Errorf("Expected [%s] for token [%s], got [%s]", protocol.FAIL, token, line)
+			}
+		}
+	})
+
+	t.Run("Refuses requests it doesn't understand", func(t *testing.T) {
+		_, listener := serve(t, inventory.NewInventory(), "")
+		defer listener.Close()
+
+		conn, _, line := request(t, listener.Addr().String(), "", -1, "s3cr3t")
+		defer conn.Close()
+		if line != protocol.FAIL {
+			t.Errorf("Expected [%s], got [%s]", protocol.FAIL, line)
+		}
+	})
+}
+
+func TestReplica(t *testing.T) {
+	t.Run("Catches up with seats moved before and after it connects", func(t *testing.T) {
+		primaryInventory := inventory.NewInventory()
+		mustMove(t, primaryInventory.Reserve("A1", testActor))
+		mustMove(t, primaryInventory.Buy("A1", testActor))
+		_, listener := serve(t, primaryInventory, "")
+		defer listener.Close()
+
+		replicaInventory, replica := follow(t, listener.Addr().String())
+		defer replica.Promote()
+		mustMove(t, primaryInventory.Reserve("A2", testActor))
+		mustMove(t, primaryInventory.Refund("A1", inventory.QUARANTINED, "damaged", testActor))
+
+		expectEventually(t, replicaInventory, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.QUARANTINED, "A2": inventory.RESERVED})
+	})
+
+	t.Run("Refuses clients moving seats", func(t *testing.T) {
+		_, listener := serve(t, inventory.NewInventory(), "")
+		defer listener.Close()
+
+		replicaInventory, replica := follow(t, listener.Addr().String())
+		defer replica.Promote()
+		if err := replicaInventory.Reserve("A1", testActor); !errors.Is(err, inventory.ErrReadOnly) {
+			t.Errorf("Expected a read only error reserving on a replica, got %v", err)
+		}
+		if err := replicaInventory.CheckWritable(inventory.BUY, "A1"); !errors.Is(err, inventory.ErrReadOnly) {
+			t.Errorf("Expected a replica not to be writable, got %v", err)
+		}
+	})
+
+	t.Run("Resumes where it was after reconnecting", func(t *testing.T) {
+		primaryInventory := inventory.NewInventory()
+		primary, listener := serve(t, primaryInventory, "")
+		defer listener.Close()
+
+		replicaInventory, replica := follow(t, listener.Addr().String())
+		defer replica.Promote()
+		mustMove(t, primaryInventory.Reserve("A1", testActor))
+		expectEventually(t, replicaInventory, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.RESERVED})
+
+		replica.lock.Lock()
+		replica.conn.Close()
+		replica.lock.Unlock()
+		mustMove(t, primaryInventory.Buy("A1", testActor))
+
+		expectEventually(t, replicaInventory, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.SOLD})
+		if id, sequence := replica.Position(); id != primary.ID() || sequence != 2 {
+			t.Errorf("Expected replica to be at sequence [2] of [%s], got [%d] of [%s]", primary.ID(), sequence, id)
+		}
+	})
+
+	t.Run("Starts over from a snapshot when the primary restarts", func(t *testing.T) {
+		primaryInventory := inventory.NewInventory()
+		mustMove(t, primaryInventory.Reserve("A1", testActor))
+		mustMove(t, primaryInventory.Reserve("A2", testActor))
+		_, listener := serve(t, primaryInventory, "")
+
+		replicaInventory, replica := follow(t, listener.Addr().String())
+		defer replica.Promote()
+		expectEventually(t, replicaInventory, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.RESERVED, "A2": inventory.RESERVED})
+
+		//The restarted primary lost A1 and moved A3 since
+		listener.Close()
+		restartedInventory := inventory.NewInventory()
+		mustMove(t, restartedInventory.Reserve("A2", testActor))
+		mustMove(t, restartedInventory.Buy("A2", testActor))
+		mustMove(t, restartedInventory.Reserve("A3", testActor))
+		restarted, listener := serve(t, restartedInventory, listener.Addr().String())
+		defer listener.Close()
+		replica.lock.Lock()
+		replica.conn.Close()
+		replica.lock.Unlock()
+
+		expectEventually(t, replicaInventory, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.FREE, "A2": inventory.SOLD, "A3": inventory.RESERVED})
+		if id, _ := replica.Position(); id != restarted.ID() {
+			t.Errorf("Expected replica to follow [%s], follows [%s]", restarted.ID(), id)
+		}
+	})
+
+	t.Run("Takes clients' moves and stops replicating once promoted", func(t *testing.T) {
+		primaryInventory := inventory.NewInventory()
+		_, listener := serve(t, primaryInventory, "")
+		defer listener.Close()
+
+		replicaInventory, replica := follow(t, listener.Addr().String())
+		mustMove(t, primaryInventory.Reserve("A1", testActor))
+		expectEventually(t, replicaInventory, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.RESERVED})
+
+		if err := replica.Promote(); err != nil {
+			t.Fatalf("Unexpected error promoting replica: %v", err)
+		}
+		mustMove(t, replicaInventory.Buy("A1", testActor))
+		mustMove(t, primaryInventory.Reserve("A2", testActor))
+
+		//Give a replica that didn't stop time to apply the move
+		time.Sleep(100 * time.Millisecond)
+		if status, _ := replicaInventory.Get("A2"); status != inventory.FREE {
+			t.Errorf("Expected promoted replica to stop replicating, seat [A2] is [%s]", status)
+		}
+		if err := replica.Promote(); !errors.Is(err, protocol.ErrNotReplica) {
+			t.Errorf("Expected promoting twice to fail, got %v", err)
+		}
+	})
+
+	t.Run("Chained replicas follow the replica that gets promoted", func(t *testing.T) {
+		primaryInventory := inventory.NewInventory()
+		_, primaryListener := serve(t, primaryInventory, "")
+		defer primaryListener.Close()
+
+		middleInventory, middle := follow(t, primaryListener.Addr().String())
+		_, middleListener := serve(t, middleInventory, "")
+		defer middleListener.Close()
+		lastInventory, last := follow(t, middleListener.Addr().String())
+		defer last.Promote()
+
+		mustMove(t, primaryInventory.Reserve("A1", testActor))
+		expectEventually(t, lastInventory, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.RESERVED})
+
+		if err := middle.Promote(); err != nil {
+			t.Fatalf("Unexpected error promoting replica: %v", err)
+		}
+		mustMove(t, middleInventory.Buy("A1", testActor))
+		expectEventually(t, lastInventory, map[inventory.Seat]inventory.SeatStatus{"A1": inventory.SOLD})
+	})
+}
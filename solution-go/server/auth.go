@@ -0,0 +1,231 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/protocol"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/replication"
+)
+
+// Permission is something a role lets principals do
+type Permission string
+
+const (
+	PERMISSION_QUERY   Permission = "query"
+	PERMISSION_RESERVE Permission = "reserve"
+	PERMISSION_BUY     Permission = "buy"
+	PERMISSION_ADMIN   Permission = "admin"
+)
+
+// ANONYMOUS is the name of principals that didn't identify themselves
+const ANONYMOUS = "anonymous"
+
+// commandPermissions says what a principal needs to run each command. Commands not listed are always
+// allowed, CAS needs whatever the move it makes needs and admin commands also accept the admin secret.
+var commandPermissions = map[protocol.Command]Permission{
+	protocol.QUERY:     PERMISSION_QUERY,
+	protocol.LIST:      PERMISSION_QUERY,
+	protocol.SUBSCRIBE: PERMISSION_QUERY,
+	protocol.RESERVE:   PERMISSION_RESERVE,
+	protocol.BUY:       PERMISSION_BUY,
+}
+
+// casPermissions says what a CAS needs depending on where it moves the seat, moving it anywhere else is a
+// privileged move that needs admin
+var casPermissions = map[inventory.SeatStatus]Permission{
+	inventory.RESERVED: PERMISSION_RESERVE,
+	inventory.SOLD:     PERMISSION_BUY,
+}
+
+// Principal is who is on the other side of a connection, and what they are allowed to do
+type Principal struct {
+	Name        string
+	Permissions []Permission
+}
+
+// Can tells whether the principal was granted the permission
+func (p Principal) Can(permission Permission) bool {
+	for _, granted := range p.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow refuses commands the principal has no permission for
+func (p Principal) Allow(command protocol.Command) error {
+	permission, restricted := commandPermissions[command]
+	if restricted && !p.Can(permission) {
+		return inventory.NewKindError(inventory.ErrNotPrivileged, "principal [%s] has no [%s] permission to run [%s]", p.Name, permission, command)
+	}
+	return nil
+}
+
+// AllowCompareAndSet refuses CAS to a status the principal couldn't move the seat to with other commands
+func (p Principal) AllowCompareAndSet(to inventory.SeatStatus) error {
+	permission, restricted := casPermissions[to]
+	if !restricted {
+		permission = PERMISSION_ADMIN
+	}
+	if !p.Can(permission) {
+		return inventory.NewKindError(inventory.ErrNotPrivileged, "principal [%s] has no [%s] permission to move seats to [%s]", p.Name, permission, to)
+	}
+	return nil
+}
+
+type credential struct {
+	Name        string `json:"name"`
+	Token       string `json:"token"`
+	Certificate string `json:"certificate"`
+	Role        string `json:"role"`
+}
+
+// Credentials map tokens sent with AUTH, and common names of client certificates, to principals. Connections
+// that don't identify themselves get the default role.
+type Credentials struct {
+	DefaultRole string                  `json:"default_role"`
+	Roles       map[string][]Permission `json:"roles"`
+	Principals  []credential            `json:"principals"`
+}
+
+func (c *Credentials) principal(name string, role string) Principal {
+	return Principal{name, c.Roles[role]}
+}
+
+// Anonymous is the principal of connections that don't identify themselves
+func (c *Credentials) Anonymous() Principal {
+	return c.principal(ANONYMOUS, c.DefaultRole)
+}
+
+// Authenticate finds who the token belongs to. Every token is compared in constant time, so how long it
+// takes doesn't tell how close a guess was.
+func (c *Credentials) Authenticate(token string) (Principal, error) {
+	found := -1
+	for i, p := range c.Principals {
+		if p.Token != "" && subtle.ConstantTimeCompare([]byte(p.Token), []byte(token)) == 1 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return Principal{}, inventory.NewKindError(protocol.ErrUnauthenticated, "no principal has the token given")
+	}
+	return c.principal(c.Principals[found].Name, c.Principals[found].Role), nil
+}
+
+// ForCertificate finds who a client certificate, already verified by TLS, belongs to by its common name
+func (c *Credentials) ForCertificate(commonName string) (Principal, bool) {
+	for _, p := range c.Principals {
+		if p.Certificate != "" && p.Certificate == commonName {
+			return c.principal(p.Name, p.Role), true
+		}
+	}
+	return Principal{}, false
+}
+
+func (c *Credentials) validate() error {
+	if _, known := c.Roles[c.DefaultRole]; !known {
+		return fmt.Errorf("default role [%s] is not defined", c.DefaultRole)
+	}
+	for role, permissions := range c.Roles {
+		for _, permission := range permissions {
+			switch permission {
+			case PERMISSION_QUERY, PERMISSION_RESERVE, PERMISSION_BUY, PERMISSION_ADMIN:
+			default:
+				return fmt.Errorf("role [%s] has unknown permission [%s]", role, permission)
+			}
+		}
+	}
+	for _, p := range c.Principals {
+		if _, known := c.Roles[p.Role]; !known {
+			return fmt.Errorf("principal [%s] has undefined role [%s]", p.Name, p.Role)
+		}
+		if p.Token == "" && p.Certificate == "" {
+			return fmt.Errorf("principal [%s] has neither a token nor a certificate", p.Name)
+		}
+	}
+	return nil
+}
+
+// LoadCredentials reads principals from a JSON file like:
+//
+//	{
+//	  "default_role": "visitor",
+//	  "roles": {"visitor": ["query"], "box-office": ["query", "reserve", "buy"], "ops": ["query", "admin"]},
+//	  "principals": [
+//	    {"name": "kiosk-1", "certificate": "kiosk-1.example.com", "role": "box-office"},
+//	    {"name": "alice", "token": "s3cr3t", "role": "ops"}
+//	  ]
+//	}
+func LoadCredentials(path string) (*Credentials, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := &Credentials{}
+	err = json.Unmarshal(content, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse credentials in [%s]: %v", path, err)
+	}
+
+	err = credentials.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials in [%s]: %v", path, err)
+	}
+	return credentials, nil
+}
+
+// PEER is the name of other nodes forwarding their clients' commands
+const PEER = "peer"
+
+// NewPeerCredentials is what nodes use on the address other nodes forward commands to. Nodes AUTH with the
+// secret they share and can then do anything, they already checked what their clients are allowed to do.
+// Connections without it can do nothing.
+func NewPeerCredentials(secret string) *Credentials {
+	return &Credentials{
+		DefaultRole: ANONYMOUS,
+		Roles: map[string][]Permission{
+			ANONYMOUS: {},
+			PEER:      {PERMISSION_QUERY, PERMISSION_RESERVE, PERMISSION_BUY, PERMISSION_ADMIN},
+		},
+		Principals: []credential{{Name: PEER, Token: secret, Role: PEER}},
+	}
+}
+
+// NewOpenCredentials is what the server uses without a credentials file, everybody can do everything but
+// admin commands, like before principals existed
+func NewOpenCredentials() *Credentials {
+	return &Credentials{
+		DefaultRole: ANONYMOUS,
+		Roles:       map[string][]Permission{ANONYMOUS: {PERMISSION_QUERY, PERMISSION_RESERVE, PERMISSION_BUY}},
+	}
+}
+
+// NewReplicaAuthorizer lets replicas follow this server if they are admins, identified like clients by
+// their certificate or the token they send, or if they send the admin secret. Replicas get every seat.
+func NewReplicaAuthorizer(credentials *Credentials, adminSecret string) replication.Authorizer {
+	return func(conn net.Conn, token string) error {
+		if isAdmin(adminSecret, token) {
+			return nil
+		}
+		principal, err := identify(conn, credentials)
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			if principal, err = credentials.Authenticate(token); err != nil {
+				return err
+			}
+		}
+		if !principal.Can(PERMISSION_ADMIN) {
+			return inventory.NewKindError(inventory.ErrNotPrivileged, "principal [%s] has no [%s] permission to replicate", principal.Name, PERMISSION_ADMIN)
+		}
+		return nil
+	}
+}
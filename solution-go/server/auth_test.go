@@ -0,0 +1,230 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/protocol"
+)
+
+const testCredentials = `{
+  "default_role": "visitor",
+  "roles": {
+    "visitor": ["query"],
+    "box-office": ["query", "reserve", "buy"],
+    "ops": ["query", "reserve", "buy", "admin"]
+  },
+  "principals": [
+    {"name": "kiosk-1", "certificate": "kiosk-1", "role": "box-office"},
+    {"name": "alice", "token": "alice-token", "role": "ops"},
+    {"name": "bob", "token": "bob-token", "role": "box-office"}
+  ]
+}`
+
+func writeCredentials(t *testing.T, content string) string {
+	path := filepath.Join(tempDir(t), "credentials.json")
+	writeFile(t, path, []byte(content), time.Now())
+	return path
+}
+
+// talk sends each message through a handler of a fresh inventory and returns the first line of each response
+func talk(t *testing.T, credentials *Credentials, adminSecret string, messages ...string) []string {
+	handler := NewHandler(inventory.NewInventory(), inventory.NewIdempotencyCache(time.Minute, 10), NewStats(), credentials, adminSecret, nil, nil)
+	return talkTo(t, handler, messages...)
+}
+
+func TestLoadCredentials(t *testing.T) {
+	t.Run("Reads roles and principals", func(t *testing.T) {
+		credentials, err := LoadCredentials(writeCredentials(t, testCredentials))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		anonymous := credentials.Anonymous()
+		if !anonymous.Can(PERMISSION_QUERY) || anonymous.Can(PERMISSION_RESERVE) {
+			t.Errorf("Expected anonymous principal to only query, got %+v", anonymous)
+		}
+
+		alice, err := credentials.Authenticate("alice-token")
+		if err != nil || alice.Name != "alice" || !alice.Can(PERMISSION_ADMIN) {
+			t.Errorf("Expected alice to be an admin, got %+v and error [%v]", alice, err)
+		}
+
+		kiosk, found := credentials.ForCertificate("kiosk-1")
+		if !found || kiosk.Name != "kiosk-1" || !kiosk.Can(PERMISSION_BUY) || kiosk.Can(PERMISSION_ADMIN) {
+			t.Errorf("Expected kiosk to buy but not be an admin, got %+v", kiosk)
+		}
+	})
+
+	t.Run("Refuses unknown tokens and certificates", func(t *testing.T) {
+		credentials, _ := LoadCredentials(writeCredentials(t, testCredentials))
+
+		for _, token := range []string{"", "alice", "alice-token2", "kiosk-1"} {
+			if _, err := credentials.Authenticate(token); !errors.Is(err, protocol.ErrUnauthenticated) {
+				t.Errorf("Expected token [%s] to be refused, got [%v]", token, err)
+			}
+		}
+		if _, found := credentials.ForCertificate("alice"); found {
+			t.Errorf("Expected principals with only a token not to match certificates")
+		}
+	})
+
+	t.Run("Rejects inconsistent files", func(t *testing.T) {
+		invalid := []string{
+			`{"default_role": "nobody", "roles": {"visitor": ["query"]}}`,
+			`{"default_role": "visitor", "roles": {"visitor": ["fly"]}}`,
+			`{"default_role": "visitor", "roles": {"visitor": []}, "principals": [{"name": "x", "token": "t", "role": "ops"}]}`,
+			`{"default_role": "visitor", "roles": {"visitor": []}, "principals": [{"name": "x", "role": "visitor"}]}`,
+			`not json`,
+		}
+		for _, content := range invalid {
+			if _, err := LoadCredentials(writeCredentials(t, content)); err == nil {
+				t.Errorf("Expected error loading %s", content)
+			}
+		}
+	})
+}
+
+func TestPrincipalPermissions(t *testing.T) {
+	credentials, _ := LoadCredentials(writeCredentials(t, testCredentials))
+
+	t.Run("Unauthenticated connections get the default role", func(t *testing.T) {
+		responses := talk(t, credentials, "", "ERRORS: extended", "QUERY: A1", "RESERVE: A1", "CAS: A1 FREE RESERVED")
+		if responses[1] != "FREE" {
+			t.Errorf("Expected visitors to query, got [%s]", responses[1])
+		}
+		for _, response := range responses[2:] {
+			if !strings.HasPrefix(response, "FAIL NOT_PRIVILEGED ") {
+				t.Errorf("Expected visitors not to reserve, got [%s]", response)
+			}
+		}
+	})
+
+	t.Run("AUTH switches to the principal of the token", func(t *testing.T) {
+		responses := talk(t, credentials, "", "HELLO: 4", "ERRORS: legacy", "AUTH: bob-token", "RESERVE: A1", "BUY: A1", "STATS: anything", "REFUND: A1 FREE anything oops")[2:]
+		expected := []string{"OK", "OK", "OK", "FAIL", "FAIL"}
+		if strings.Join(responses, "|") != strings.Join(expected, "|") {
+			t.Errorf("Expected %v, got %v", expected, responses)
+		}
+	})
+
+	t.Run("Wrong tokens keep the connection as it was", func(t *testing.T) {
+		responses := talk(t, credentials, "", "HELLO: 4", "AUTH: guess", "RESERVE: A1")
+		if !strings.HasPrefix(responses[1], "FAIL UNAUTHENTICATED ") || !strings.HasPrefix(responses[2], "FAIL NOT_PRIVILEGED ") {
+			t.Errorf("Unexpected responses %v", responses)
+		}
+	})
+
+	t.Run("Admins run admin commands and privileged moves without the secret", func(t *testing.T) {
+		responses := talk(t, credentials, "", "HELLO: 4", "AUTH: alice-token", "RESERVE: A1", "CAS: A1 RESERVED FREE", "RESERVE: A1", "BUY: A1", "REFUND: A1 QUARANTINED x chargeback")[1:]
+		for i, response := range responses {
+			if response != protocol.OK {
+				t.Errorf("Expected [OK] for message [%d], got [%s]", i, response)
+			}
+		}
+		if stats := talk(t, credentials, "", "HELLO: 4", "AUTH: alice-token", "STATS: x")[2]; !strings.HasPrefix(stats, "reserved=") {
+			t.Errorf("Expected stats, got [%s]", stats)
+		}
+	})
+
+	t.Run("The admin secret still works for admin commands", func(t *testing.T) {
+		responses := talk(t, NewOpenCredentials(), "s3cr3t", "STATS: s3cr3t", "CAS: A1 FREE RESERVED", "CAS: A1 RESERVED FREE", "CAS: A1 RESERVED FREE guess", "CAS: A1 RESERVED FREE s3cr3t")
+		if !strings.HasPrefix(responses[0], "reserved=") || responses[1] != protocol.OK || responses[2] != protocol.FAIL || responses[3] != protocol.FAIL || responses[4] != protocol.OK {
+			t.Errorf("Unexpected responses %v", responses)
+		}
+		if redacted := redactSecrets("CAS: A1 RESERVED FREE s3cr3t"); redacted != "CAS: A1 RESERVED FREE <redacted>" {
+			t.Errorf("Expected the secret to be redacted, got [%s]", redacted)
+		}
+	})
+
+	t.Run("Only admins see who moved seats", func(t *testing.T) {
+		responses := talk(t, NewOpenCredentials(), "s3cr3t", "ERRORS: extended", "RESERVE: A1", "HISTORY: A1", "HISTORY: A1 guess", "HISTORY: A1 s3cr3t")
+		expectResponses(t, responses, []string{"OK", "OK", "FAIL NOT_PRIVILEGED", "FAIL NOT_PRIVILEGED", "20"})
+		if redacted := redactSecrets("HISTORY: A1 s3cr3t"); redacted != "HISTORY: A1 <redacted>" {
+			t.Errorf("Expected the secret to be redacted, got [%s]", redacted)
+		}
+	})
+
+	t.Run("Client certificates identify principals", func(t *testing.T) {
+		server := issueCertificate(t, "server", nil)
+		clientCa := issueCertificate(t, "client-ca", nil)
+		kiosk := issueCertificate(t, "kiosk-1", clientCa)
+		unknown := issueCertificate(t, "kiosk-2", clientCa)
+
+		reloader, err := NewCertificateReloader(writeServerFiles(t, tempDir(t), server, clientCa, time.Now()), logging.NewLogger(false))
+		if err != nil {
+			t.Fatalf("Unexpected error loading certificates: %v", err)
+		}
+		listener := serveTls(t, reloader.Config(), credentials)
+		defer listener.Close()
+
+		known := &tls.Config{RootCAs: trusting(server), Certificates: []tls.Certificate{kiosk.keyPair(t)}}
+		if response, _, err := reserveOverTls(listener, known, "A1"); err != nil || response != protocol.OK {
+			t.Errorf("Expected kiosk-1 to reserve, got [%s] and error [%v]", response, err)
+		}
+
+		stranger := &tls.Config{RootCAs: trusting(server), Certificates: []tls.Certificate{unknown.keyPair(t)}}
+		if response, _, err := reserveOverTls(listener, stranger, "A2"); err != nil || response != protocol.FAIL {
+			t.Errorf("Expected kiosk-2 to only get the default role, got [%s] and error [%v]", response, err)
+		}
+	})
+}
+
+func TestReplicaAuthorizer(t *testing.T) {
+	credentials, _ := LoadCredentials(writeCredentials(t, testCredentials))
+	authorize := NewReplicaAuthorizer(credentials, "s3cr3t")
+
+	t.Run("Only admins and the admin secret replicate", func(t *testing.T) {
+		conn, other := net.Pipe()
+		defer conn.Close()
+		defer other.Close()
+
+		for _, token := range []string{"alice-token", "s3cr3t"} {
+			if err := authorize(conn, token); err != nil {
+				t.Errorf("Expected token [%s] to replicate, got [%v]", token, err)
+			}
+		}
+		if err := authorize(conn, "bob-token"); !errors.Is(err, inventory.ErrNotPrivileged) {
+			t.Errorf("Expected bob not to replicate, got [%v]", err)
+		}
+		for _, token := range []string{"", "guess"} {
+			if err := authorize(conn, token); err == nil {
+				t.Errorf("Expected token [%s] not to replicate", token)
+			}
+		}
+	})
+
+	t.Run("Client certificates identify replicas", func(t *testing.T) {
+		server := issueCertificate(t, "server", nil)
+		clientCa := issueCertificate(t, "client-ca", nil)
+		kiosk := issueCertificate(t, "kiosk-1", clientCa)
+
+		reloader, err := NewCertificateReloader(writeServerFiles(t, tempDir(t), server, clientCa, time.Now()), logging.NewLogger(false))
+		if err != nil {
+			t.Fatalf("Unexpected error loading certificates: %v", err)
+		}
+
+		for token, allowed := range map[string]bool{"": false, "alice-token": true} {
+			serverConn, clientConn := net.Pipe()
+			replica := tls.Client(clientConn, &tls.Config{RootCAs: trusting(server), ServerName: "localhost", Certificates: []tls.Certificate{kiosk.keyPair(t)}})
+			go replica.Handshake()
+
+			err := authorize(tls.Server(serverConn, reloader.Config()), token)
+			if allowed && err != nil {
+				t.Errorf("Expected kiosk-1 with token [%s] to replicate, got [%v]", token, err)
+			}
+			if !allowed && !errors.Is(err, inventory.ErrNotPrivileged) {
+				t.Errorf("Expected kiosk-1 with token [%s] not to replicate, got [%v]", token, err)
+			}
+			serverConn.Close()
+			clientConn.Close()
+		}
+	})
+}
@@ -0,0 +1,435 @@
+package server
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/partition"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/protocol"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/replication"
+)
+
+func isAdmin(adminSecret string, givenSecret string) bool {
+	if adminSecret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(adminSecret), []byte(givenSecret)) == 1
+}
+
+// AdminRefusedError is returned when a client without the admin secret tries an admin command
+type AdminRefusedError struct {
+	Command protocol.Command
+}
+
+func (e *AdminRefusedError) Error() string {
+	return fmt.Sprintf("admin command [%s] refused, wrong or no secret configured", e.Command)
+}
+
+func (e *AdminRefusedError) Unwrap() error {
+	return inventory.ErrNotPrivileged
+}
+
+// redactSecrets and redactArgument keep admin secrets and tokens out of the logs
+func redactSecrets(line string) string {
+	for _, command := range []string{protocol.STATS, protocol.AUTH, protocol.PROMOTE, protocol.JOIN, protocol.JOINED, protocol.ABORT_JOIN} {
+		if strings.HasPrefix(line, command+":") {
+			return command + ": <redacted>"
+		}
+	}
+	for _, command := range []protocol.Command{protocol.CAS, protocol.REFUND, protocol.HISTORY, protocol.CREATE_EVENT, protocol.CLOSE_EVENT, protocol.ARCHIVE_EVENT} {
+		if strings.HasPrefix(line, string(command)+": ") {
+			return string(command) + ": " + string(redactArgument(command, inventory.Seat(strings.TrimPrefix(line, string(command)+": "))))
+		}
+	}
+	return line
+}
+
+func redactArgument(command protocol.Command, seat inventory.Seat) inventory.Seat {
+	switch command {
+	case protocol.STATS, protocol.AUTH, protocol.PROMOTE, protocol.JOIN, protocol.JOINED, protocol.ABORT_JOIN:
+		return "<redacted>"
+	case protocol.CAS:
+		//The secret is the optional fourth word
+		if split := strings.Split(string(seat), " "); len(split) > 3 {
+			split[3] = "<redacted>"
+			return inventory.Seat(strings.Join(split, " "))
+		}
+	case protocol.REFUND:
+		//The secret is the third word, the seat, status and reason are worth keeping
+		split := strings.SplitN(string(seat), " ", 4)
+		if len(split) > 2 {
+			split[2] = "<redacted>"
+		}
+		return inventory.Seat(strings.Join(split, " "))
+	case protocol.HISTORY:
+		if target, secret := protocol.ParseHistory(seat); secret != "" {
+			return inventory.Seat(string(target) + " <redacted>")
+		}
+	case protocol.CREATE_EVENT, protocol.CLOSE_EVENT, protocol.ARCHIVE_EVENT:
+		//The event is worth keeping, the secret follows it
+		if event, secret := protocol.ParseEventAdmin(seat); secret != "" {
+			return inventory.Seat(string(event) + " <redacted>")
+		}
+	}
+	return seat
+}
+
+// mutate applies a RESERVE or BUY, only once per idempotency key if the client sent one
+func mutate(seatInventory *inventory.Inventory, idempotency *inventory.IdempotencyCache, command protocol.Command, argument inventory.Seat, actor inventory.Actor) error {
+	seat, key := protocol.ParseMutation(argument)
+	//Replicas refuse without remembering the key, the client can still use it with the primary
+	if err := seatInventory.CheckWritable(inventory.Command(command), seat); err != nil {
+		return err
+	}
+	apply := func() error {
+		if command == protocol.RESERVE {
+			return seatInventory.Reserve(seat, actor)
+		}
+		return seatInventory.Buy(seat, actor)
+	}
+
+	if key == "" {
+		return apply()
+	}
+	return idempotency.Do(key, inventory.Command(command), seat, apply)
+}
+
+// authorize refuses commands the principal can't run, before they are run here or forwarded to another node
+func authorize(principal Principal, adminSecret string, command protocol.Command, argument inventory.Seat) error {
+	if err := principal.Allow(command); err != nil {
+		return err
+	}
+	switch command {
+	case protocol.CAS:
+		_, _, to, secret, err := protocol.ParseCompareAndSet(argument)
+		if err != nil {
+			return err
+		}
+		if isAdmin(adminSecret, secret) {
+			return nil
+		}
+		return principal.AllowCompareAndSet(to)
+	case protocol.REFUND:
+		_, _, secret, _, err := protocol.ParseRefund(argument)
+		if err != nil {
+			return err
+		}
+		if !principal.Can(PERMISSION_ADMIN) && !isAdmin(adminSecret, secret) {
+			return &AdminRefusedError{command}
+		}
+	case protocol.CREATE_EVENT, protocol.CLOSE_EVENT, protocol.ARCHIVE_EVENT:
+		if _, secret := protocol.ParseEventAdmin(argument); !principal.Can(PERMISSION_ADMIN) && !isAdmin(adminSecret, secret) {
+			return &AdminRefusedError{command}
+		}
+	case protocol.HISTORY:
+		//Histories show who moved each seat, from which address
+		if _, secret := protocol.ParseHistory(argument); !principal.Can(PERMISSION_ADMIN) && !isAdmin(adminSecret, secret) {
+			return &AdminRefusedError{command}
+		}
+	}
+	return nil
+}
+
+// route sends the command to the node owning its seats, telling the caller to run it here when this node
+// does. Servers that aren't partitioned run everything.
+func route(router *partition.Router, command protocol.Command, argument inventory.Seat, line string) (func(), string, error) {
+	if router == nil {
+		return func() {}, "", nil
+	}
+	return router.Route(command, argument, line)
+}
+
+// identify finds the principal of a client certificate, it is only there if TLS verified it against the client CA
+func identify(conn net.Conn, credentials *Credentials) (Principal, error) {
+	tlsConn, isTls := conn.(*tls.Conn)
+	if !isTls {
+		return credentials.Anonymous(), nil
+	}
+
+	err := tlsConn.Handshake()
+	if err != nil {
+		return Principal{}, err
+	}
+
+	certificates := tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) > 0 {
+		if principal, found := credentials.ForCertificate(certificates[0].Subject.CommonName); found {
+			return principal, nil
+		}
+	}
+	return credentials.Anonymous(), nil
+}
+
+// NewHandler speaks the protocol on each connection, running commands against the inventory. Replicas
+// pass the replica PROMOTE promotes, primaries pass nil. Partitioned servers pass the router that forwards
+// commands for seats other nodes own.
+func NewHandler(seatInventory *inventory.Inventory, idempotency *inventory.IdempotencyCache, stats *Stats, credentials *Credentials, adminSecret string, replica *replication.Replica, router *partition.Router) Handler {
+	return func(conn net.Conn, connectionID int64, logger *logging.Logger) {
+		defer func() {
+			logger.Infof("Closing connection")
+			conn.Close()
+		}()
+
+		actor := inventory.Actor{ConnectionID: connectionID, RemoteAddress: conn.RemoteAddr().String()}
+		principal, err := identify(conn, credentials)
+		if err != nil {
+			//Clients that fail the TLS handshake only lose their own connection
+			logger.Errorf("%v", err)
+			return
+		}
+		logger.Infof("Connection from [%s] acting as [%s]", actor.RemoteAddress, principal.Name)
+		version := protocol.DefaultProtocolVersion()
+		extendedErrors := version.ExtendedErrors
+		firstMessage := true
+		//Seats named without an event are the event's once the client said USE
+		var event inventory.Event
+
+		rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		for true {
+
+			payload, err := rw.ReadString('\n')
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				//Clients that hang up abruptly only lose their own connection
+				logger.Errorf("%v", err)
+				return
+			}
+			line := strings.TrimSpace(payload)
+			logger.Infof("Received message [%s]\n", redactSecrets(line))
+
+			var errorExecutingCommand error
+			responseFromCommand := protocol.OK
+
+			command, seat, err := protocol.ParseMessage(line)
+			if err == nil && event != "" {
+				seat, err = protocol.Qualify(command, seat, event)
+				line = fmt.Sprintf("%s: %s", command, seat)
+			}
+			if err != nil {
+				logger.Errorf("%v", err)
+				errorExecutingCommand = err
+			} else if command != protocol.HELLO && !version.Allows(command) {
+				errorExecutingCommand = inventory.NewKindError(protocol.ErrUnknownCommand, "command [%s] is not part of protocol version [%d]", command, version.Number)
+			} else if err := authorize(principal, adminSecret, command, seat); err != nil {
+				errorExecutingCommand = err
+			} else if done, response, err := route(router, command, seat, line); done == nil {
+				responseFromCommand, errorExecutingCommand = response, err
+			} else {
+				logger.Infof("Executing command [%s] to seat[%s]", command, redactArgument(command, seat))
+				switch command {
+				case protocol.RESERVE, protocol.BUY:
+					errorExecutingCommand = mutate(seatInventory, idempotency, command, seat, actor)
+				case protocol.CAS:
+					target, from, to, secret, _ := protocol.ParseCompareAndSet(seat)
+					privileged := principal.Can(PERMISSION_ADMIN) || isAdmin(adminSecret, secret)
+					errorExecutingCommand = seatInventory.CompareAndSet(target, from, to, privileged, actor)
+				case protocol.REFUND:
+					target, to, _, reason, _ := protocol.ParseRefund(seat)
+					errorExecutingCommand = seatInventory.Refund(target, to, reason, actor)
+				case protocol.HELLO:
+					requested, _ := strconv.Atoi(string(seat))
+					if !firstMessage {
+						errorExecutingCommand = inventory.NewKindError(protocol.ErrInvalidMessage, "[%s] must be the first message of a connection", protocol.HELLO)
+					} else if negotiated, err := protocol.NegotiateVersion(requested); err != nil {
+						errorExecutingCommand = err
+					} else {
+						logger.Infof("Speaking protocol version [%d]", negotiated.Number)
+						version = negotiated
+						extendedErrors = version.ExtendedErrors
+						responseFromCommand = protocol.FormatHello(version)
+					}
+				case protocol.AUTH:
+					authenticated, err := credentials.Authenticate(string(seat))
+					if err != nil {
+						errorExecutingCommand = err
+					} else {
+						logger.Infof("Authenticated as [%s]", authenticated.Name)
+						principal = authenticated
+					}
+				case protocol.ERRORS:
+					extendedErrors = seat == protocol.EXTENDED_ERRORS
+				case protocol.USE:
+					//The event may be owned by another node, commands for its seats find out whether it exists
+					event = inventory.Event(seat)
+				case protocol.CREATE_EVENT:
+					created, _ := protocol.ParseEventAdmin(seat)
+					errorExecutingCommand = seatInventory.CreateEvent(created, actor)
+				case protocol.CLOSE_EVENT:
+					closed, _ := protocol.ParseEventAdmin(seat)
+					errorExecutingCommand = seatInventory.CloseEvent(closed, actor)
+				case protocol.ARCHIVE_EVENT:
+					archived, _ := protocol.ParseEventAdmin(seat)
+					errorExecutingCommand = seatInventory.ArchiveEvent(archived, actor)
+				case protocol.QUERY:
+					seats, err := protocol.ParseSeats(seat)
+					if err != nil {
+						errorExecutingCommand = err
+					} else if statuses, err := seatInventory.GetMany(seats); err != nil {
+						errorExecutingCommand = err
+					} else {
+						responseFromCommand = protocol.FormatStatuses(statuses)
+					}
+				case protocol.HISTORY:
+					target, _ := protocol.ParseHistory(seat)
+					if history, err := seatInventory.History(target); err != nil {
+						errorExecutingCommand = err
+					} else {
+						responseFromCommand = protocol.FormatHistory(history)
+					}
+				case protocol.LIST:
+					options, err := protocol.ParseListOptions(seat)
+					if err != nil {
+						errorExecutingCommand = err
+					} else if listings, cursor, err := seatInventory.List(options.Prefix, options.Status, options.Cursor, options.Limit); err != nil {
+						errorExecutingCommand = err
+					} else {
+						responseFromCommand = protocol.FormatListing(listings, cursor)
+					}
+				case protocol.SUBSCRIBE:
+					options, err := protocol.ParseSubscribeOptions(seat)
+					if err != nil {
+						errorExecutingCommand = err
+					} else {
+						subscription, latest, err := seatInventory.Subscribe(options.Prefix, options.After)
+						if err != nil {
+							errorExecutingCommand = err
+						} else {
+							logger.Infof("Streaming transitions of seats prefixed by [%s] after sequence [%d]", options.Prefix, options.After)
+							streamTransitions(rw, seatInventory, subscription, latest, logger)
+							return
+						}
+					}
+				case protocol.STATS:
+					if !principal.Can(PERMISSION_ADMIN) && !isAdmin(adminSecret, string(seat)) {
+						errorExecutingCommand = &AdminRefusedError{command}
+					} else {
+						responseFromCommand, errorExecutingCommand = stats.Report(seatInventory)
+					}
+				case protocol.PROMOTE:
+					if !principal.Can(PERMISSION_ADMIN) && !isAdmin(adminSecret, string(seat)) {
+						errorExecutingCommand = &AdminRefusedError{command}
+					} else if replica == nil {
+						errorExecutingCommand = inventory.NewKindError(protocol.ErrNotReplica, "this server is a primary, there is nothing to promote")
+					} else {
+						errorExecutingCommand = replica.Promote()
+					}
+				case protocol.JOIN:
+					node, secret := protocol.ParseJoin(seat)
+					if !principal.Can(PERMISSION_ADMIN) && !isAdmin(adminSecret, secret) {
+						errorExecutingCommand = &AdminRefusedError{command}
+					} else if router == nil {
+						errorExecutingCommand = inventory.NewKindError(protocol.ErrNotPartitioned, "this server keeps every seat, there is nothing to join")
+					} else if offered, err := router.Offer(node); err != nil {
+						errorExecutingCommand = err
+					} else {
+						responseFromCommand = protocol.FormatListing(offered, "")
+					}
+				case protocol.JOINED:
+					node, secret := protocol.ParseJoin(seat)
+					if !principal.Can(PERMISSION_ADMIN) && !isAdmin(adminSecret, secret) {
+						errorExecutingCommand = &AdminRefusedError{command}
+					} else if router == nil {
+						errorExecutingCommand = inventory.NewKindError(protocol.ErrNotPartitioned, "this server keeps every seat, there is nothing to join")
+					} else {
+						errorExecutingCommand = router.Admit(node)
+					}
+				case protocol.ABORT_JOIN:
+					node, secret := protocol.ParseJoin(seat)
+					if !principal.Can(PERMISSION_ADMIN) && !isAdmin(adminSecret, secret) {
+						errorExecutingCommand = &AdminRefusedError{command}
+					} else if router == nil {
+						errorExecutingCommand = inventory.NewKindError(protocol.ErrNotPartitioned, "this server keeps every seat, there is nothing to join")
+					} else {
+						errorExecutingCommand = router.Withdraw(node)
+					}
+				default:
+					errorExecutingCommand = inventory.NewKindError(protocol.ErrUnknownCommand, "unknown command [%s] in message [%s]", command, line)
+				}
+				//Subscriptions return before this, done does nothing for them
+				done()
+			}
+
+			response := ""
+			if errorExecutingCommand != nil {
+				logger.Errorf("Error executing command [%s]: %v", protocol.ErrorCode(errorExecutingCommand), errorExecutingCommand)
+				response = protocol.FormatFailure(errorExecutingCommand, extendedErrors)
+			} else {
+				response = responseFromCommand
+			}
+
+			logger.Infof("Sending response [%s]", response)
+			_, err = rw.WriteString(fmt.Sprintf("%s\n", response))
+			if err != nil {
+				logger.Errorf("%v", err)
+				return
+			}
+			rw.Flush()
+			stats.CommandServed()
+			firstMessage = false
+		}
+
+	}
+}
+
+// streamTransitions turns the connection into a one way stream of events, until the client hangs up
+func streamTransitions(rw *bufio.ReadWriter, seatInventory *inventory.Inventory, subscription *inventory.Subscription, latest int64, logger *logging.Logger) {
+	defer seatInventory.Unsubscribe(subscription)
+
+	//Subscribers only listen, anything they send or hanging up ends the subscription
+	hungUp := make(chan bool)
+	go func() {
+		rw.ReadString('\n')
+		close(hungUp)
+	}()
+
+	lines := []string{fmt.Sprintf("%s %d", protocol.OK, latest)}
+	for _, t := range subscription.Backlog() {
+		lines = append(lines, protocol.FormatTransition(t))
+	}
+
+	for {
+		for _, line := range lines {
+			_, err := rw.WriteString(line + "\n")
+			if err != nil {
+				logger.Errorf("Error streaming transitions: %v", err)
+				return
+			}
+		}
+		lines = lines[:0]
+
+		//Only flush once there is nothing else waiting, so bursts go out together
+		if len(subscription.Events()) == 0 {
+			err := rw.Flush()
+			if err != nil {
+				logger.Errorf("Error streaming transitions: %v", err)
+				return
+			}
+		}
+
+		select {
+		case t, open := <-subscription.Events():
+			if !open {
+				if subscription.Lagged() {
+					logger.Infof("Subscriber fell behind, closing subscription")
+					rw.WriteString(protocol.LAGGED + "\n")
+					rw.Flush()
+				}
+				return
+			}
+			lines = append(lines, protocol.FormatTransition(t))
+		case <-hungUp:
+			logger.Infof("Subscriber hung up")
+			return
+		}
+	}
+}
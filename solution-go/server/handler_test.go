@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/replication"
+)
+
+// talkTo sends each message through the handler and returns the first line of each response
+func talkTo(t *testing.T, handler Handler, messages ...string) []string {
+	client, server := net.Pipe()
+	defer client.Close()
+	go handler(server, 1, logging.NewLogger(false))
+
+	reader := bufio.NewReader(client)
+	var responses []string
+	for _, message := range messages {
+		fmt.Fprintln(client, message)
+		response, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading response to [%s]: %v", message, err)
+		}
+		responses = append(responses, strings.TrimSpace(response))
+	}
+	return responses
+}
+
+func expectResponses(t *testing.T, actual []string, expected []string) {
+	for i := range expected {
+		if !strings.HasPrefix(actual[i], expected[i]) {
+			t.Errorf("Expected response [%d] to start with [%s], got [%s]", i, expected[i], actual[i])
+		}
+	}
+}
+
+func TestReplicaCommands(t *testing.T) {
+	t.Run("Replicas refuse moves until promoted", func(t *testing.T) {
+		seatInventory := inventory.NewInventory()
+		//Nothing listens there, the replica never gets to replicate anything
+		replica := replication.NewReplica(seatInventory, "127.0.0.1:1", "", nil, logging.NewLogger(false))
+		handler := NewHandler(seatInventory, inventory.NewIdempotencyCache(time.Minute, 10), NewStats(), NewOpenCredentials(), "s3cr3t", replica, nil)
+
+		responses := talkTo(t, handler, "HELLO: 5", "RESERVE: A1 key=k1", "CAS: A1 FREE RESERVED", "QUERY: A1", "PROMOTE: guess", "PROMOTE: s3cr3t", "RESERVE: A1 key=k1", "PROMOTE: s3cr3t")
+		expectResponses(t, responses, []string{"OK", "FAIL READ_ONLY", "FAIL READ_ONLY", "FREE", "FAIL NOT_PRIVILEGED", "OK", "OK", "FAIL NOT_REPLICA"})
+	})
+
+	t.Run("Primaries have nothing to promote", func(t *testing.T) {
+		responses := talk(t, NewOpenCredentials(), "s3cr3t", "HELLO: 5", "PROMOTE: s3cr3t", "RESERVE: A1")
+		expectResponses(t, responses, []string{"OK", "FAIL NOT_REPLICA", "OK"})
+	})
+}
+
+func TestEventCommands(t *testing.T) {
+	t.Run("Only admins create, close and archive events", func(t *testing.T) {
+		responses := talk(t, NewOpenCredentials(), "s3cr3t", "HELLO: 7", "CREATE_EVENT: EVT1", "CREATE_EVENT: EVT1 guess", "CREATE_EVENT: EVT1 s3cr3t", "CREATE_EVENT: EVT1 s3cr3t", "CLOSE_EVENT: EVT1 s3cr3t", "ARCHIVE_EVENT: EVT1 s3cr3t", "CLOSE_EVENT: EVT2 s3cr3t")
+		expectResponses(t, responses, []string{"OK", "FAIL NOT_PRIVILEGED", "FAIL NOT_PRIVILEGED", "OK", "FAIL EVENT_EXISTS", "OK", "OK", "FAIL UNKNOWN_EVENT"})
+	})
+
+	t.Run("Seats named without an event are the event's after USE", func(t *testing.T) {
+		responses := talk(t, NewOpenCredentials(), "s3cr3t", "HELLO: 7", "CREATE_EVENT: EVT1 s3cr3t", "RESERVE: A1", "USE: EVT1", "RESERVE: A1", "BUY: A1", "QUERY: A1,EVT1/A2", "CLOSE_EVENT: EVT1 s3cr3t", "RESERVE: A2", "USE: EVT2", "QUERY: A1")
+		expectResponses(t, responses, []string{"OK", "OK", "OK", "OK", "OK", "OK", "SOLD,FREE", "OK", "FAIL EVENT_CLOSED", "OK", "FAIL UNKNOWN_EVENT"})
+	})
+
+	t.Run("Keeps admin secrets of event commands out of the logs", func(t *testing.T) {
+		if redacted := redactSecrets("CLOSE_EVENT: EVT1 s3cr3t"); redacted != "CLOSE_EVENT: EVT1 <redacted>" {
+			t.Errorf("Expected the secret to be redacted, got [%s]", redacted)
+		}
+	})
+}
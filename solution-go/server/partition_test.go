@@ -0,0 +1,455 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/partition"
+)
+
+type testNode struct {
+	inventory *inventory.Inventory
+	router    *partition.Router
+	listener  net.Listener
+	handler   Handler
+	// self is what the other nodes call it, where they reach it
+	self        string
+	idempotency *inventory.IdempotencyCache
+}
+
+func (n *testNode) name() string {
+	return n.self
+}
+
+func (n *testNode) stop() {
+	n.listener.Close()
+	n.router.Close()
+}
+
+// listenForNodes opens the loopback address other nodes forward to, nodes are named after it
+func listenForNodes(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening for other nodes: %v", err)
+	}
+	return listener
+}
+
+// startNode serves the partition of the seats the node owns, joining the others first if asked to
+func startNode(t *testing.T, listener net.Listener, others []string, join bool) *testNode {
+	node := newNode(listener.Addr().String(), listener, others, inventory.NewInventory())
+	if join {
+		if err := node.router.Join(); err != nil {
+			t.Fatalf("Unexpected error joining: %v", err)
+		}
+	}
+	node.serve()
+	return node
+}
+
+// newNode is named self on the ring, which is an address reaching the listener
+func newNode(self string, listener net.Listener, others []string, seatInventory *inventory.Inventory) *testNode {
+	nodes := append([]string{self}, others...)
+	router := partition.NewRouter(self, nodes, "p33r", seatInventory, logging.NewLogger(false))
+	idempotency := inventory.NewIdempotencyCache(time.Minute, 10)
+	handler := NewHandler(seatInventory, idempotency, NewStats(), NewOpenCredentials(), "s3cr3t", nil, router)
+	return &testNode{seatInventory, router, listener, handler, self, idempotency}
+}
+
+func (n *testNode) serve() {
+	peerHandler := NewHandler(n.inventory, n.idempotency, NewStats(), NewPeerCredentials("p33r"), "", nil, n.router)
+	go NewServer(0, peerHandler, NewStats(), nil, logging.NewLogger(false)).Serve(n.listener)
+}
+
+func startNodes(t *testing.T, count int) []*testNode {
+	var listeners []net.Listener
+	var names []string
+	for i := 0; i < count; i++ {
+		listeners = append(listeners, listenForNodes(t))
+		names = append(names, listeners[i].Addr().String())
+	}
+
+	var nodes []*testNode
+	for i, listener := range listeners {
+		others := append(append([]string(nil), names[:i]...), names[i+1:]...)
+		nodes = append(nodes, startNode(t, listener, others, false))
+	}
+	return nodes
+}
+
+// seatOwnedBy finds a seat the node owns, skipping the ones given
+func seatOwnedBy(node *testNode, skip int) string {
+	for i := 0; ; i++ {
+		seat := fmt.Sprintf("A%d", i)
+		if node.router.Owner(inventory.Seat(seat)) == node.name() {
+			if skip == 0 {
+				return seat
+			}
+			skip--
+		}
+	}
+}
+
+func TestPartitionedCommands(t *testing.T) {
+	nodes := startNodes(t, 3)
+	for _, node := range nodes {
+		defer node.stop()
+	}
+	first, second, third := seatOwnedBy(nodes[0], 0), seatOwnedBy(nodes[1], 0), seatOwnedBy(nodes[2], 0)
+
+	t.Run("Forwards commands to the node owning the seat", func(t *testing.T) {
+		responses := talkTo(t, nodes[0].handler, "ERRORS: extended", "RESERVE: "+third+" key=k1", "RESERVE: "+third+" key=k1", "BUY: "+third, "BUY: "+third)
+		expectResponses(t, responses, []string{"OK", "OK", "OK", "OK", "FAIL SEAT_SOLD BUY of seat [" + third + "] from [SOLD]"})
+
+		if status, _ := nodes[2].inventory.Get(inventory.Seat(third)); status != inventory.SOLD {
+			t.Errorf("Expected owner to have seat [%s] sold, it is [%s]", third, status)
+		}
+		if status, _ := nodes[0].inventory.Get(inventory.Seat(third)); status != inventory.FREE {
+			t.Errorf("Expected seat [%s] not to be kept by the node forwarding it, it is [%s]", third, status)
+		}
+	})
+
+	t.Run("Answers queries spanning nodes with every owner's statuses", func(t *testing.T) {
+		talkTo(t, nodes[1].handler, "RESERVE: "+second)
+
+		responses := talkTo(t, nodes[0].handler, fmt.Sprintf("QUERY: %s,%s,%s", third, first, second), "HISTORY: "+second+" s3cr3t")
+		expectResponses(t, responses, []string{"SOLD,FREE,RESERVED", "20"})
+		if !strings.HasSuffix(responses[1], "FREE RESERVED 1 pipe") {
+			t.Errorf("Expected the owner's history of seat [%s], got [%s]", second, responses[1])
+		}
+	})
+
+	t.Run("Checks what clients are allowed before forwarding", func(t *testing.T) {
+		responses := talkTo(t, nodes[0].handler, "ERRORS: extended", "REFUND: "+third+" FREE guess oops", "CAS: "+third+" SOLD FREE")
+		expectResponses(t, responses, []string{"OK", "FAIL NOT_PRIVILEGED", "FAIL NOT_PRIVILEGED"})
+		if status, _ := nodes[2].inventory.Get(inventory.Seat(third)); status != inventory.SOLD {
+			t.Errorf("Expected seat [%s] to stay sold, it is [%s]", third, status)
+		}
+	})
+
+	t.Run("Refuses listing seats spread over every node", func(t *testing.T) {
+		responses := talkTo(t, nodes[0].handler, "ERRORS: extended", "LIST: prefix=A", "SUBSCRIBE: prefix=A")
+		expectResponses(t, responses, []string{"OK", "FAIL CROSS_PARTITION", "FAIL CROSS_PARTITION"})
+	})
+
+	t.Run("Keeps an event's seats on the node owning the event", func(t *testing.T) {
+		event := "EVT0"
+		for i := 1; nodes[0].router.Owner(inventory.EventRecord(inventory.Event(event))) != nodes[2].name(); i++ {
+			event = fmt.Sprintf("EVT%d", i)
+		}
+
+		responses := talkTo(t, nodes[0].handler, "HELLO: 7", "CREATE_EVENT: "+event+" s3cr3t", "USE: "+event, "RESERVE: A1", "RESERVE: A2", "SUBSCRIBE: prefix=A", "LIST: prefix=A")
+		expectResponses(t, responses, []string{"OK", "OK", "OK", "OK", "OK", "FAIL CROSS_PARTITION", event + "/A1 RESERVED"})
+		for _, seat := range []string{"A1", "A2"} {
+			if status, _ := nodes[2].inventory.Get(inventory.Seat(event + "/" + seat)); status != inventory.RESERVED {
+				t.Errorf("Expected the event's owner to have seat [%s] reserved, it is [%s]", seat, status)
+			}
+		}
+	})
+
+	t.Run("Refuses joins without the admin secret", func(t *testing.T) {
+		responses := talkTo(t, nodes[0].handler, "HELLO: 6", "JOIN: 127.0.0.1:1 guess")
+		expectResponses(t, responses, []string{"OK", "FAIL NOT_PRIVILEGED"})
+	})
+
+	t.Run("Only serves nodes with the peer secret on the peer address", func(t *testing.T) {
+		peerHandler := NewHandler(nodes[2].inventory, inventory.NewIdempotencyCache(time.Minute, 10), NewStats(), NewPeerCredentials("p33r"), "", nil, nodes[2].router)
+		responses := talkTo(t, peerHandler, "HELLO: 6", "JOIN: 127.0.0.1:1", "BUY: "+third, "AUTH: guess", "AUTH: p33r", "QUERY: "+third)
+		expectResponses(t, responses, []string{"OK", "FAIL NOT_PRIVILEGED", "FAIL NOT_PRIVILEGED", "FAIL UNAUTHENTICATED", "OK", "SOLD"})
+		if nodes[2].router.Owner("A0") == "127.0.0.1:1" || len(nodes[2].router.Nodes()) != 3 {
+			t.Errorf("Expected no node to join without the peer secret, ring is %v", nodes[2].router.Nodes())
+		}
+	})
+}
+
+func TestUnreachablePartition(t *testing.T) {
+	//Nothing listens on the other node's address
+	gone := listenForNodes(t)
+	gone.Close()
+	node := startNode(t, listenForNodes(t), []string{gone.Addr().String()}, false)
+	defer node.stop()
+
+	for i := 0; ; i++ {
+		seat := fmt.Sprintf("A%d", i)
+		if node.router.Owner(inventory.Seat(seat)) != node.name() {
+			responses := talkTo(t, node.handler, "ERRORS: extended", "RESERVE: "+seat)
+			expectResponses(t, responses, []string{"OK", "FAIL NOT_APPLIED could not reach node"})
+			return
+		}
+	}
+}
+
+func TestRebalancing(t *testing.T) {
+	nodes := startNodes(t, 2)
+	for _, node := range nodes {
+		defer node.stop()
+	}
+
+	var seats []string
+	for i := 0; i < 200; i++ {
+		seat := fmt.Sprintf("B%d", i)
+		seats = append(seats, seat)
+		responses := talkTo(t, nodes[i%2].handler, "RESERVE: "+seat)
+		expectResponses(t, responses, []string{"OK"})
+	}
+	ownersBefore := map[string]string{}
+	for _, seat := range seats {
+		ownersBefore[seat] = nodes[0].router.Owner(inventory.Seat(seat))
+	}
+
+	joining := startNode(t, listenForNodes(t), []string{nodes[0].name(), nodes[1].name()}, true)
+	defer joining.stop()
+	nodes = append(nodes, joining)
+
+	moved := 0
+	for _, seat := range seats {
+		owner := joining.router.Owner(inventory.Seat(seat))
+		if owner != ownersBefore[seat] {
+			moved++
+			if owner != joining.name() {
+				t.Errorf("Expected seat [%s] to stay with [%s] or move to the new node, moved to [%s]", seat, ownersBefore[seat], owner)
+			}
+		}
+		for _, node := range nodes {
+			expected := inventory.FREE
+			if node.name() == owner {
+				expected = inventory.RESERVED
+			}
+			if status, _ := node.inventory.Get(inventory.Seat(seat)); status != expected {
+				t.Errorf("Expected seat [%s] to be [%s] on node [%s], it is [%s]", seat, expected, node.name(), status)
+			}
+		}
+	}
+	if moved == 0 {
+		t.Errorf("Expected some seats to move to the new node")
+	}
+
+	//Every node, new or old, answers for every seat
+	for _, node := range nodes {
+		responses := talkTo(t, node.handler, "QUERY: "+strings.Join(seats, ","), "BUY: "+seats[len(seats)-1])
+		expectResponses(t, responses, []string{strings.Repeat("RESERVED,", len(seats)-1) + "RESERVED", "OK"})
+		seats = seats[:len(seats)-1]
+	}
+}
+
+// dropFirstJoinResponse passes connections on to the address, but hangs up instead of answering the first
+// JOIN it passes on
+func dropFirstJoinResponse(proxy net.Listener, address string) {
+	var joins int32
+	for {
+		client, err := proxy.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", address)
+		if err != nil {
+			client.Close()
+			continue
+		}
+		dropping := make(chan struct{})
+		go func() {
+			defer server.Close()
+			reader := bufio.NewReader(client)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if strings.HasPrefix(line, "JOIN:") && atomic.AddInt32(&joins, 1) == 1 {
+					close(dropping)
+				}
+				if _, err := io.WriteString(server, line); err != nil {
+					return
+				}
+			}
+		}()
+		go func() {
+			defer client.Close()
+			reader := bufio.NewReader(server)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				select {
+				case <-dropping:
+					server.Close()
+					return
+				default:
+				}
+				if _, err := io.WriteString(client, line); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestLostHandover(t *testing.T) {
+	//The old node is reached through a proxy losing the first answer to JOIN
+	listener, proxy := listenForNodes(t), listenForNodes(t)
+	defer proxy.Close()
+	go dropFirstJoinResponse(proxy, listener.Addr().String())
+	old := newNode(proxy.Addr().String(), listener, nil, inventory.NewInventory())
+	old.serve()
+	defer old.stop()
+
+	var seats []string
+	for i := 0; i < 100; i++ {
+		seat := fmt.Sprintf("C%d", i)
+		seats = append(seats, seat)
+		expectResponses(t, talkTo(t, old.handler, "RESERVE: "+seat), []string{"OK"})
+	}
+
+	joiningListener := listenForNodes(t)
+	joining := newNode(joiningListener.Addr().String(), joiningListener, []string{old.name()}, inventory.NewInventory())
+	defer joining.stop()
+	if err := joining.router.Join(); err == nil {
+		t.Fatalf("Expected joining to fail without the answer to JOIN")
+	}
+	for _, seat := range seats {
+		if owner := old.router.Owner(inventory.Seat(seat)); owner != old.name() {
+			t.Fatalf("Expected seat [%s] to stay with the old node, it is [%s]'s", seat, owner)
+		}
+		if status, _ := old.inventory.Get(inventory.Seat(seat)); status != inventory.RESERVED {
+			t.Fatalf("Expected the old node to keep seat [%s] reserved, it is [%s]", seat, status)
+		}
+	}
+
+	//Seats moved since they were offered are handed over as they are now
+	expectResponses(t, talkTo(t, old.handler, "BUY: "+seats[0]), []string{"OK"})
+	if err := joining.router.Join(); err != nil {
+		t.Fatalf("Unexpected error joining again: %v", err)
+	}
+	moved := 0
+	for i, seat := range seats {
+		owner := joining.router.Owner(inventory.Seat(seat))
+		if owner != old.router.Owner(inventory.Seat(seat)) {
+			t.Fatalf("Expected both nodes to agree who owns seat [%s]", seat)
+		}
+		expected := inventory.RESERVED
+		if i == 0 {
+			expected = inventory.SOLD
+		}
+		owning, other := old, joining
+		if owner == joining.name() {
+			owning, other = joining, old
+			moved++
+		}
+		if status, _ := owning.inventory.Get(inventory.Seat(seat)); status != expected {
+			t.Errorf("Expected seat [%s] to be [%s] on its owner, it is [%s]", seat, expected, status)
+		}
+		if status, _ := other.inventory.Get(inventory.Seat(seat)); status != inventory.FREE {
+			t.Errorf("Expected seat [%s] to be FREE on the node not owning it, it is [%s]", seat, status)
+		}
+	}
+	if moved == 0 {
+		t.Errorf("Expected some seats to move to the new node")
+	}
+}
+
+// failOnceStore fails the swap numbered failAt, like a disk filling up for a moment
+type failOnceStore struct {
+	inventory.SeatStore
+	swaps  int32
+	failAt int32
+}
+
+func (s *failOnceStore) CompareAndSwap(seat inventory.Seat, old inventory.SeatStatus, status inventory.SeatStatus) (bool, error) {
+	if atomic.AddInt32(&s.swaps, 1) == s.failAt {
+		return false, errors.New("disk full")
+	}
+	return s.SeatStore.CompareAndSwap(seat, old, status)
+}
+
+func TestFailedHandover(t *testing.T) {
+	olds := startNodes(t, 2)
+	for _, node := range olds {
+		defer node.stop()
+	}
+	var seats []string
+	for i := 0; i < 200; i++ {
+		seat := fmt.Sprintf("D%d", i)
+		seats = append(seats, seat)
+		expectResponses(t, talkTo(t, olds[0].handler, "RESERVE: "+seat), []string{"OK"})
+	}
+	owners := map[string]string{}
+	for _, seat := range seats {
+		owners[seat] = olds[0].router.Owner(inventory.Seat(seat))
+	}
+
+	//The joining node can't keep the second seat the second node it joins offers
+	store := &failOnceStore{SeatStore: inventory.NewMapStore()}
+	joiningListener := listenForNodes(t)
+	joining := newNode(joiningListener.Addr().String(), joiningListener, []string{olds[0].name(), olds[1].name()}, inventory.NewStoredInventory(store, inventory.NewAuditTrail(nil)))
+	defer joining.stop()
+	var contacted []string
+	for _, node := range joining.router.Nodes() {
+		if node != joining.name() {
+			contacted = append(contacted, node)
+		}
+	}
+	offered := map[string]int32{}
+	for _, seat := range seats {
+		if joining.router.Owner(inventory.Seat(seat)) == joining.name() {
+			offered[owners[seat]]++
+		}
+	}
+	if offered[contacted[0]] == 0 || offered[contacted[1]] < 2 {
+		t.Fatalf("Expected both nodes to offer seats, they offer %v", offered)
+	}
+	store.failAt = offered[contacted[0]] + 2
+
+	if err := joining.router.Join(); err == nil {
+		t.Fatalf("Expected joining to fail when a seat offered can't be taken")
+	}
+	for _, seat := range seats {
+		owner := olds[0]
+		if owners[seat] == olds[1].name() {
+			owner = olds[1]
+		}
+		if now := owner.router.Owner(inventory.Seat(seat)); now != owners[seat] {
+			t.Fatalf("Expected seat [%s] to stay with node [%s], it is [%s]'s", seat, owners[seat], now)
+		}
+		if status, _ := owner.inventory.Get(inventory.Seat(seat)); status != inventory.RESERVED {
+			t.Fatalf("Expected node [%s] to keep seat [%s] reserved, it is [%s]", owners[seat], seat, status)
+		}
+		if status, _ := joining.inventory.Get(inventory.Seat(seat)); status != inventory.FREE {
+			t.Fatalf("Expected the joining node to give seat [%s] back, it is [%s]", seat, status)
+		}
+	}
+	for _, old := range olds {
+		responses := talkTo(t, old.handler, "HELLO: 9", "ERRORS: extended", "JOINED: "+joining.name()+" s3cr3t")
+		expectResponses(t, responses, []string{"OK", "OK", "FAIL NOT_APPLIED"})
+	}
+
+	if err := joining.router.Join(); err != nil {
+		t.Fatalf("Unexpected error joining again: %v", err)
+	}
+	for _, seat := range seats {
+		owner := joining.router.Owner(inventory.Seat(seat))
+		for _, old := range olds {
+			if now := old.router.Owner(inventory.Seat(seat)); now != owner {
+				t.Fatalf("Expected every node to agree seat [%s] is [%s]'s, node [%s] says [%s]'s", seat, owner, old.name(), now)
+			}
+		}
+		for _, node := range append([]*testNode{joining}, olds...) {
+			expected := inventory.FREE
+			if node.name() == owner {
+				expected = inventory.RESERVED
+			}
+			if status, _ := node.inventory.Get(inventory.Seat(seat)); status != expected {
+				t.Errorf("Expected seat [%s] to be [%s] on node [%s], it is [%s]", seat, expected, node.name(), status)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Package server accepts TCP or TLS connections and runs the commands clients send against an inventory,
+// checking what each principal is allowed to do.
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+)
+
+// Handler serves a connection until it is done with it, the server closes nothing on its behalf
+type Handler func(conn net.Conn, connectionID int64, logger *logging.Logger)
+
+// Server accepts connections and hands each one to the handler in its own goroutine
+type Server struct {
+	logger           *logging.Logger
+	port             int
+	handler          Handler
+	stats            *Stats
+	tlsConfig        *tls.Config
+	lastConnectionID int64
+}
+
+// Start listens on the port and serves connections, it only returns if accepting them fails
+func (s *Server) Start() error {
+
+	address := fmt.Sprintf(":%d", s.port)
+	s.logger.Infof("starting server at [%s]", address)
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		s.logger.Errorf("error while opening socket: %v", err)
+		return err
+	}
+	if s.tlsConfig != nil {
+		s.logger.Infof("connections must use TLS")
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves connections from a listener opened elsewhere, it only returns if accepting them fails
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		s.logger.Infof("ready to accept connections")
+		conn, err := ln.Accept()
+		if err != nil {
+			s.logger.Errorf("error accepting connection: %v", err)
+			return err
+		}
+		s.logger.Debugf("conn Accepted")
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	s.stats.ConnectionOpened()
+	defer s.stats.ConnectionClosed()
+
+	connectionID := atomic.AddInt64(&s.lastConnectionID, 1)
+	s.handler(conn, connectionID, s.logger)
+}
+
+// NewServer creates a server that accepts plain TCP connections, or only TLS ones when given a tlsConfig
+func NewServer(port int, handler Handler, stats *Stats, tlsConfig *tls.Config, logger *logging.Logger) *Server {
+	return &Server{
+		logger:    logger,
+		port:      port,
+		handler:   handler,
+		stats:     stats,
+		tlsConfig: tlsConfig,
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+)
+
+// Stats counts what the server is doing, for STATS to report
+type Stats struct {
+	startedAt         time.Time
+	activeConnections int64
+	commandsServed    int64
+}
+
+func (s *Stats) ConnectionOpened() {
+	atomic.AddInt64(&s.activeConnections, 1)
+}
+
+func (s *Stats) ConnectionClosed() {
+	atomic.AddInt64(&s.activeConnections, -1)
+}
+
+func (s *Stats) CommandServed() {
+	atomic.AddInt64(&s.commandsServed, 1)
+}
+
+// Report renders the stats as space separated key=value pairs, so they fit in a single response line. Free
+// seats aren't reported, seats never moved aren't kept anywhere so only the ones moved back could be counted.
+func (s *Stats) Report(seatInventory *inventory.Inventory) (string, error) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	counts, err := seatInventory.Counts()
+	if err != nil {
+		return "", err
+	}
+	pairs := []string{
+		fmt.Sprintf("reserved=%d", counts[inventory.RESERVED]),
+		fmt.Sprintf("sold=%d", counts[inventory.SOLD]),
+		fmt.Sprintf("quarantined=%d", counts[inventory.QUARANTINED]),
+		fmt.Sprintf("connections=%d", atomic.LoadInt64(&s.activeConnections)),
+		fmt.Sprintf("uptime_seconds=%d", int64(time.Since(s.startedAt).Seconds())),
+		fmt.Sprintf("commands=%d", atomic.LoadInt64(&s.commandsServed)),
+		fmt.Sprintf("goroutines=%d", runtime.NumGoroutine()),
+		fmt.Sprintf("heap_bytes=%d", memStats.HeapAlloc),
+	}
+	return strings.Join(pairs, " "), nil
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		startedAt: time.Now(),
+	}
+}
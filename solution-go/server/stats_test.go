@@ -0,0 +1,53 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/protocol"
+)
+
+func TestStats(t *testing.T) {
+	t.Run("Reports inventory counts and server activity", func(t *testing.T) {
+		seatInventory := inventory.NewInventory()
+		if err := seatInventory.Reserve("A1", inventory.Actor{ConnectionID: 1, RemoteAddress: "127.0.0.1:5000"}); err != nil {
+			t.Fatalf("Unexpected error when reserving seat [A1]: %v", err)
+		}
+
+		stats := NewStats()
+		stats.ConnectionOpened()
+		stats.ConnectionOpened()
+		stats.ConnectionClosed()
+		stats.CommandServed()
+		stats.CommandServed()
+
+		report, err := stats.Report(seatInventory)
+		if err != nil {
+			t.Fatalf("Unexpected error reporting stats: %v", err)
+		}
+		if strings.Contains(report, "free=") {
+			t.Errorf("Expected report [%s] not to count free seats", report)
+		}
+		for _, expected := range []string{"reserved=1", "sold=0", "quarantined=0", "connections=1", "uptime_seconds=0", "commands=2", "goroutines=", "heap_bytes="} {
+			if !strings.Contains(report, expected) {
+				t.Errorf("Expected report [%s] to contain [%s]", report, expected)
+			}
+		}
+	})
+
+	t.Run("Only admins with the configured secret can see stats", func(t *testing.T) {
+		if isAdmin("", "") {
+			t.Errorf("Expected admin commands to be disabled when no secret is configured")
+		}
+		if isAdmin("s3cr3t", "guess") {
+			t.Errorf("Expected wrong secret to be refused")
+		}
+		if !isAdmin("s3cr3t", "s3cr3t") {
+			t.Errorf("Expected right secret to be accepted")
+		}
+		if code := protocol.ErrorCode(&AdminRefusedError{protocol.STATS}); code != "NOT_PRIVILEGED" {
+			t.Errorf("Expected refused admin commands to fail with [NOT_PRIVILEGED], got [%s]", code)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+)
+
+// TlsFiles are the PEM files the server reads its certificate from. ClientCaFile is optional, when given
+// clients must present a certificate signed by one of its CAs.
+type TlsFiles struct {
+	CertFile     string
+	KeyFile      string
+	ClientCaFile string
+}
+
+func (f TlsFiles) paths() []string {
+	paths := []string{f.CertFile, f.KeyFile}
+	if f.ClientCaFile != "" {
+		paths = append(paths, f.ClientCaFile)
+	}
+	return paths
+}
+
+// CertificateReloader keeps the TLS configuration in sync with the files it came from, so certificates
+// can be rotated without restarting the server. Connections already open keep what they negotiated.
+type CertificateReloader struct {
+	files    TlsFiles
+	config   *tls.Config
+	modTimes []time.Time
+	logger   *logging.Logger
+	lock     sync.RWMutex
+}
+
+// Config is what the listener should use, every handshake picks up the latest certificates
+func (r *CertificateReloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.lock.RLock()
+			defer r.lock.RUnlock()
+			return r.config, nil
+		},
+	}
+}
+
+// ReloadIfChanged reads the files again if any of them was modified since last time. A broken file is
+// reported and the previous certificates are kept, half written files shouldn't take the server down.
+func (r *CertificateReloader) ReloadIfChanged() error {
+	modTimes, err := modificationTimes(r.files.paths())
+	if err != nil {
+		return err
+	}
+
+	r.lock.RLock()
+	changed := !sameTimes(modTimes, r.modTimes)
+	r.lock.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	config, err := loadTlsConfig(r.files)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.config = config
+	r.modTimes = modTimes
+	r.logger.Infof("Loaded TLS certificate from [%s]", r.files.CertFile)
+	return nil
+}
+
+// Watch checks the files every interval, forever
+func (r *CertificateReloader) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := r.ReloadIfChanged()
+		if err != nil {
+			r.logger.Errorf("Could not reload TLS certificate, still using the previous one: %v", err)
+		}
+	}
+}
+
+func modificationTimes(paths []string) ([]time.Time, error) {
+	times := make([]time.Time, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		times[i] = info.ModTime()
+	}
+	return times, nil
+}
+
+func sameTimes(a []time.Time, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func loadTlsConfig(files TlsFiles) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load certificate [%s] and key [%s]: %v", files.CertFile, files.KeyFile, err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	if files.ClientCaFile != "" {
+		pem, err := ioutil.ReadFile(files.ClientCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read client CA certificates from [%s]: %v", files.ClientCaFile, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid PEM certificates found in [%s]", files.ClientCaFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// NewCertificateReloader fails if the files can't be loaded right away
+func NewCertificateReloader(files TlsFiles, logger *logging.Logger) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{
+		files:  files,
+		logger: logger,
+	}
+
+	err := reloader.ReloadIfChanged()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
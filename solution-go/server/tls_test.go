@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcalcado/seatgeek-challenge/solution-go/inventory"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/logging"
+	"github.com/pcalcado/seatgeek-challenge/solution-go/protocol"
+)
+
+type testCertificate struct {
+	leaf    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+var lastSerialNumber int64
+
+// issueCertificate signs a certificate for localhost with the parent, or makes it self signed if there is no
+// parent. Every certificate can sign others, so the same helper makes CAs, servers and clients.
+func issueCertificate(t *testing.T, commonName string, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	lastSerialNumber++
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(lastSerialNumber),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.leaf, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error encoding key: %v", err)
+	}
+
+	return &testCertificate{
+		leaf:    leaf,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func (c *testCertificate) keyPair(t *testing.T) tls.Certificate {
+	pair, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatalf("Error loading key pair: %v", err)
+	}
+	return pair
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+	return dir
+}
+
+// writeFile also moves the modification time forward, so rewrites within the same second are noticed
+func writeFile(t *testing.T, path string, content []byte, modified time.Time) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("Error writing [%s]: %v", path, err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatalf("Error touching [%s]: %v", path, err)
+	}
+}
+
+func writeServerFiles(t *testing.T, dir string, server *testCertificate, clientCa *testCertificate, modified time.Time) TlsFiles {
+	files := TlsFiles{CertFile: filepath.Join(dir, "server.pem"), KeyFile: filepath.Join(dir, "server.key")}
+	writeFile(t, files.CertFile, server.certPEM, modified)
+	writeFile(t, files.KeyFile, server.keyPEM, modified)
+	if clientCa != nil {
+		files.ClientCaFile = filepath.Join(dir, "client-ca.pem")
+		writeFile(t, files.ClientCaFile, clientCa.certPEM, modified)
+	}
+	return files
+}
+
+// serveTls runs the real handler behind a TLS listener on a random port
+func serveTls(t *testing.T, config *tls.Config, credentials *Credentials) net.Listener {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("Error opening listener: %v", err)
+	}
+
+	handler := NewHandler(inventory.NewInventory(), inventory.NewIdempotencyCache(time.Minute, 10), NewStats(), credentials, "", nil, nil)
+	go func() {
+		for connectionID := int64(1); ; connectionID++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				//Listener was closed, test is over
+				return
+			}
+			go handler(conn, connectionID, logging.NewLogger(false))
+		}
+	}()
+	return listener
+}
+
+// reserveOverTls connects, reserves a seat and returns the response and the certificate the server showed
+func reserveOverTls(listener net.Listener, config *tls.Config, seat inventory.Seat) (string, *x509.Certificate, error) {
+	conn, err := tls.Dial("tcp", listener.Addr().String(), config)
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "%s: %s\n", protocol.RESERVE, seat)
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSpace(response), conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func trusting(certificates ...*testCertificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, c := range certificates {
+		pool.AddCert(c.leaf)
+	}
+	return pool
+}
+
+func TestCertificateReloader(t *testing.T) {
+	startedAt := time.Now()
+
+	t.Run("Serves clients over TLS", func(t *testing.T) {
+		server := issueCertificate(t, "server", nil)
+		reloader, err := NewCertificateReloader(writeServerFiles(t, tempDir(t), server, nil, startedAt), logging.NewLogger(false))
+		if err != nil {
+			t.Fatalf("Unexpected error loading certificates: %v", err)
+		}
+		listener := serveTls(t, reloader.Config(), NewOpenCredentials())
+		defer listener.Close()
+
+		response, _, err := reserveOverTls(listener, &tls.Config{RootCAs: trusting(server)}, "A1")
+		if err != nil {
+			t.Fatalf("Unexpected error talking to server: %v", err)
+		}
+		if response != protocol.OK {
+			t.Errorf("Expected [OK], got [%s]", response)
+		}
+	})
+
+	t.Run("Only accepts clients with certificates signed by the client CA", func(t *testing.T) {
+		server := issueCertificate(t, "server", nil)
+		clientCa := issueCertificate(t, "client-ca", nil)
+		client := issueCertificate(t, "box-office", clientCa)
+		stranger := issueCertificate(t, "stranger", nil)
+
+		reloader, err := NewCertificateReloader(writeServerFiles(t, tempDir(t), server, clientCa, startedAt), logging.NewLogger(false))
+		if err != nil {
+			t.Fatalf("Unexpected error loading certificates: %v", err)
+		}
+		listener := serveTls(t, reloader.Config(), NewOpenCredentials())
+		defer listener.Close()
+
+		trusted := &tls.Config{RootCAs: trusting(server), Certificates: []tls.Certificate{client.keyPair(t)}}
+		if response, _, err := reserveOverTls(listener, trusted, "A1"); err != nil || response != protocol.OK {
+			t.Errorf("Expected trusted client to reserve, got [%s] and error [%v]", response, err)
+		}
+
+		anonymous := &tls.Config{RootCAs: trusting(server)}
+		if _, _, err := reserveOverTls(listener, anonymous, "A2"); err == nil {
+			t.Errorf("Expected clients without certificates to be refused")
+		}
+
+		untrusted := &tls.Config{RootCAs: trusting(server), Certificates: []tls.Certificate{stranger.keyPair(t)}}
+		if _, _, err := reserveOverTls(listener, untrusted, "A3"); err == nil {
+			t.Errorf("Expected clients with certificates from another CA to be refused")
+		}
+	})
+
+	t.Run("Picks up new certificates without restarting", func(t *testing.T) {
+		dir := tempDir(t)
+		first := issueCertificate(t, "first", nil)
+		second := issueCertificate(t, "second", nil)
+		clientConfig := &tls.Config{RootCAs: trusting(first, second)}
+
+		reloader, err := NewCertificateReloader(writeServerFiles(t, dir, first, nil, startedAt), logging.NewLogger(false))
+		if err != nil {
+			t.Fatalf("Unexpected error loading certificates: %v", err)
+		}
+		listener := serveTls(t, reloader.Config(), NewOpenCredentials())
+		defer listener.Close()
+
+		_, shown, err := reserveOverTls(listener, clientConfig, "A1")
+		if err != nil || shown.Subject.CommonName != "first" {
+			t.Fatalf("Expected first certificate, got [%v] and error [%v]", shown, err)
+		}
+
+		writeServerFiles(t, dir, second, nil, startedAt.Add(time.Minute))
+		if err := reloader.ReloadIfChanged(); err != nil {
+			t.Fatalf("Unexpected error reloading certificates: %v", err)
+		}
+
+		_, shown, err = reserveOverTls(listener, clientConfig, "A2")
+		if err != nil || shown.Subject.CommonName != "second" {
+			t.Errorf("Expected second certificate, got [%v] and error [%v]", shown, err)
+		}
+	})
+
+	t.Run("Keeps the previous certificate when the new files are broken", func(t *testing.T) {
+		dir := tempDir(t)
+		server := issueCertificate(t, "server", nil)
+		files := writeServerFiles(t, dir, server, nil, startedAt)
+
+		reloader, err := NewCertificateReloader(files, logging.NewLogger(false))
+		if err != nil {
+			t.Fatalf("Unexpected error loading certificates: %v", err)
+		}
+		listener := serveTls(t, reloader.Config(), NewOpenCredentials())
+		defer listener.Close()
+
+		writeFile(t, files.CertFile, []byte("half written"), startedAt.Add(time.Minute))
+		if err := reloader.ReloadIfChanged(); err == nil {
+			t.Errorf("Expected error reloading a broken certificate")
+		}
+
+		if response, _, err := reserveOverTls(listener, &tls.Config{RootCAs: trusting(server)}, "A1"); err != nil || response != protocol.OK {
+			t.Errorf("Expected previous certificate to still work, got [%s] and error [%v]", response, err)
+		}
+	})
+
+	t.Run("Refuses to start without valid files", func(t *testing.T) {
+		dir := tempDir(t)
+		_, err := NewCertificateReloader(TlsFiles{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: filepath.Join(dir, "missing.key")}, logging.NewLogger(false))
+		if err == nil {
+			t.Errorf("Expected error loading missing files")
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"sort"
+	"strings"
 	"sync"
 )
 
@@ -36,7 +37,12 @@ func (s *ActualStrategy) Execute(name string, c Client) (bool, error) {
 			return false, err
 		}
 
-		status := Status(response)
+		status, err := ParseResponseTo(message, s.verb, response)
+		if err != nil {
+			s.logger.Errorf("[%s] RECEIVED INVALID RESPONSE: %v", name, err)
+			return false, err
+		}
+
 		if status != OK {
 			s.logger.Infof("[%s] FAILED SENDING MESSAGE [%s], response: %s", name, message, status)
 		} else {
@@ -62,6 +68,10 @@ func (c *Consumer) Tick() (bool, error) {
 	return c.strategy.Execute(c.name, c.client)
 }
 
+func (c *Consumer) Close() error {
+	return c.client.Close()
+}
+
 func sortSet(leftToProcess map[string]bool) []string {
 	//Necessary to make this deterministic
 	var seats []string
@@ -134,9 +144,9 @@ func (b *BrokenStrategy) Execute(name string, c Client) (bool, error) {
 		return false, err
 	}
 
-	status := Status(response)
-	if status == OK {
-		err = fmt.Errorf("[%s] EXPECTED FAILED BUT SUCCEEDED SENDING MESSAGE [%s], response: %s", name, message, response)
+	_, err = ParseResponseTo(message, Verb(""), response)
+	if err != nil {
+		err = fmt.Errorf("[%s] EXPECTED FAILED SENDING BROKEN MESSAGE: %v", name, err)
 		b.exit(err)
 		return false, err
 	}
@@ -150,22 +160,42 @@ func NewBrokenConsumer(exit func(error), l *Logger) Strategy {
 	}
 }
 
+// MAX_SEATS_PER_QUERY is how many seats a bulk QUERY asks about at most, servers refuse more
+const MAX_SEATS_PER_QUERY = 1000
+
+// QueryAllSeats asks about the seats with as few bulk QUERYs as servers take
 func QueryAllSeats(seatsToQuery []string, c Client, l *Logger) (map[string]Status, error) {
 	queryResponse := map[string]Status{}
 	name := "q"
-	for _, seat := range seatsToQuery {
-		message := QuerySeat(seat).Serialize()
+	for len(seatsToQuery) > 0 {
+		batch := seatsToQuery
+		if len(batch) > MAX_SEATS_PER_QUERY {
+			batch = batch[:MAX_SEATS_PER_QUERY]
+		}
+		seatsToQuery = seatsToQuery[len(batch):]
+		message := QuerySeats(batch...).Serialize()
 
 		response, err := c.Send(message)
-		status := Status(response)
-
 		if err != nil {
 			l.Errorf("[%s] RECEIVED ERROR SENDING MESSAGE [%s], error: %v", name, message, err)
 			return nil, err
 		}
 
+		statuses := strings.Split(response, ",")
+		if len(statuses) != len(batch) {
+			err := fmt.Errorf("expected [%d] statuses in response to message [%s], got [%s]", len(batch), message, response)
+			l.Errorf("[%s] RECEIVED INVALID RESPONSE: %v", name, err)
+			return nil, err
+		}
+		for i, seat := range batch {
+			status, err := ParseResponseTo(message, QUERY, statuses[i])
+			if err != nil {
+				l.Errorf("[%s] RECEIVED INVALID RESPONSE: %v", name, err)
+				return nil, err
+			}
+			queryResponse[seat] = status
+		}
 		l.Debugf("[%s] RESPONSE FOR MESSAGE [%s] WAS [%s]", name, message, response)
-		queryResponse[seat] = status
 	}
 	return queryResponse, nil
 }
@@ -29,6 +29,10 @@ func (n *MockClient) Disconnect() error {
 	return n.ErrorToReturn
 }
 
+func (n *MockClient) Close() error {
+	return n.ErrorToReturn
+}
+
 func (n *MockClient) Send(message string) (string, error) {
 	n.ListOfMessageReceived = append(n.ListOfMessageReceived, message)
 	responseToReturn := ""
@@ -298,29 +302,72 @@ func TestNewBrokenConsumer(t *testing.T) {
 func TestQueryAllSeats(t *testing.T) {
 	t.Run("queries all seats and reports results", func(t *testing.T) {
 		expectedSeatStatuses := map[string]Status{
-			"A1": OK,
-			"B2": FAIL,
-			"C3": OK,
+			"A1": FREE,
+			"B2": RESERVED,
+			"C3": SOLD,
 		}
 
-		var seats []string
-		var responses []string
-		for seat, response := range expectedSeatStatuses {
-			seats = append(seats, seat)
-			responses = append(responses, string(response))
+		mockClient := &MockClient{
+			ListOfResponsesToReturn: []string{"FREE,RESERVED,SOLD"},
+		}
+
+		results, err := QueryAllSeats([]string{"A1", "B2", "C3"}, mockClient, logger)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
 		}
 
+		if !reflect.DeepEqual(results, expectedSeatStatuses) {
+			t.Fatalf("Expected resutls to be %v, got %v", expectedSeatStatuses, results)
+		}
+		if !reflect.DeepEqual(mockClient.ListOfMessageReceived, []string{"QUERY: A1,B2,C3"}) {
+			t.Fatalf("Expected a single bulk query, got %v", mockClient.ListOfMessageReceived)
+		}
+	})
+
+	t.Run("splits seats into queries the server takes", func(t *testing.T) {
+		var seats []string
+		for i := 0; i < 2*MAX_SEATS_PER_QUERY+1; i++ {
+			seats = append(seats, fmt.Sprintf("A%d", i))
+		}
 		mockClient := &MockClient{
-			ListOfResponsesToReturn: responses,
+			ListOfResponsesToReturn: []string{
+				strings.Repeat("FREE,", MAX_SEATS_PER_QUERY-1) + "FREE",
+				strings.Repeat("SOLD,", MAX_SEATS_PER_QUERY-1) + "SOLD",
+				"RESERVED",
+			},
 		}
 
 		results, err := QueryAllSeats(seats, mockClient, logger)
 		if err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
+		if len(mockClient.ListOfMessageReceived) != 3 {
+			t.Fatalf("Expected 3 queries, got %d", len(mockClient.ListOfMessageReceived))
+		}
+		if results["A0"] != FREE || results[seats[MAX_SEATS_PER_QUERY]] != SOLD || results[seats[len(seats)-1]] != RESERVED {
+			t.Errorf("Expected each batch's statuses for its seats, got %v", results)
+		}
+	})
 
-		if !reflect.DeepEqual(results, expectedSeatStatuses) {
-			t.Fatalf("Expected resutls to be %v, got %v", expectedSeatStatuses, results)
+	t.Run("rejects responses that arent a seat status", func(t *testing.T) {
+		mockClient := &MockClient{
+			ListOfResponsesToReturn: []string{"FREE,OK"},
+		}
+
+		_, err := QueryAllSeats([]string{"A1", "B2"}, mockClient, logger)
+		if err == nil {
+			t.Fatalf("Expected error when server answers QUERY with OK, got nothing")
+		}
+	})
+
+	t.Run("rejects responses with a status missing", func(t *testing.T) {
+		mockClient := &MockClient{
+			ListOfResponsesToReturn: []string{"FREE"},
+		}
+
+		_, err := QueryAllSeats([]string{"A1", "B2"}, mockClient, logger)
+		if err == nil {
+			t.Fatalf("Expected error when server answers fewer statuses than seats, got nothing")
 		}
 	})
 }
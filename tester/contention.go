@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var contendedVerbs = []Verb{RESERVE, BUY}
+
+type ContentionTally struct {
+	winners    map[string]map[Verb][]string
+	losers     map[string]map[Verb]int
+	unexpected []string
+	lock       sync.Mutex
+}
+
+func (t *ContentionTally) Record(seat string, verb Verb, racer string, response Status) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	switch response {
+	case OK:
+		if t.winners[seat] == nil {
+			t.winners[seat] = map[Verb][]string{}
+		}
+		t.winners[seat][verb] = append(t.winners[seat][verb], racer)
+	case FAIL:
+		if t.losers[seat] == nil {
+			t.losers[seat] = map[Verb]int{}
+		}
+		t.losers[seat][verb]++
+	default:
+		t.unexpected = append(t.unexpected, fmt.Sprintf("[%s] got [%s] for [%s: %s]", racer, response, verb, seat))
+	}
+}
+
+func (t *ContentionTally) Verify(seats []string, numRacers int) []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	problems := append([]string{}, t.unexpected...)
+	sorted := append([]string{}, seats...)
+	sort.Strings(sorted)
+
+	for _, seat := range sorted {
+		for _, verb := range contendedVerbs {
+			winners := t.winners[seat][verb]
+			losers := t.losers[seat][verb]
+			if len(winners) != 1 {
+				problems = append(problems, fmt.Sprintf("expected exactly one winner for [%s: %s], got %v", verb, seat, winners))
+			}
+			if len(winners)+losers != numRacers {
+				problems = append(problems, fmt.Sprintf("expected [%d] responses for [%s: %s], got [%d] OK and [%d] FAIL", numRacers, verb, seat, len(winners), losers))
+			}
+		}
+	}
+	return problems
+}
+
+type ContentionStrategy struct {
+	seats  []string
+	tally  *ContentionTally
+	logger *Logger
+}
+
+func (s *ContentionStrategy) Execute(name string, c Client) (bool, error) {
+	//Every racer goes through the seats in the same order, so they keep colliding with each other
+	for _, seat := range s.seats {
+		for _, verb := range contendedVerbs {
+			message := Command{verb, []string{seat}}.Serialize()
+			response, err := c.Send(message)
+			if err != nil {
+				s.logger.Errorf("[%s] RECEIVED ERROR SENDING MESSAGE [%s], error: %v", name, message, err)
+				return false, err
+			}
+
+			s.logger.Debugf("[%s] RESPONSE FOR MESSAGE [%s] WAS [%s]", name, message, response)
+			status, err := ParseResponseTo(message, verb, response)
+			if err != nil {
+				s.logger.Errorf("[%s] RECEIVED INVALID RESPONSE: %v", name, err)
+				return false, err
+			}
+			s.tally.Record(seat, verb, name, status)
+		}
+	}
+	return true, nil
+}
+
+func NewContentionStrategy(seats []string, tally *ContentionTally, logger *Logger) Strategy {
+	return &ContentionStrategy{
+		seats:  seats,
+		tally:  tally,
+		logger: logger,
+	}
+}
+
+func NewContentionTally() *ContentionTally {
+	return &ContentionTally{
+		winners: map[string]map[Verb][]string{},
+		losers:  map[string]map[Verb]int{},
+	}
+}
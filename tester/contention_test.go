@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContentionStrategy(t *testing.T) {
+	t.Run("racer reserves and then buys every seat once", func(t *testing.T) {
+		mockClient := &MockClient{ResponseToReturnAlways: "OK"}
+		tally := NewContentionTally()
+		racer := NewContentionStrategy([]string{"D1", "D2"}, tally, logger)
+
+		finished, err := racer.Execute("racer", mockClient)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !finished {
+			t.Fatalf("Expected racer to finish after one pass")
+		}
+
+		expectedMessagesReceived := []string{"RESERVE: D1", "BUY: D1", "RESERVE: D2", "BUY: D2"}
+		if !reflect.DeepEqual(mockClient.ListOfMessageReceived, expectedMessagesReceived) {
+			t.Fatalf("Expecting client to receive messages %v, got %v", expectedMessagesReceived, mockClient.ListOfMessageReceived)
+		}
+	})
+}
+
+func TestContentionTally(t *testing.T) {
+	t.Run("one winner and the rest losers is fine", func(t *testing.T) {
+		tally := NewContentionTally()
+		tally.Record("D1", RESERVE, "racer-1", OK)
+		tally.Record("D1", RESERVE, "racer-2", FAIL)
+		tally.Record("D1", BUY, "racer-2", OK)
+		tally.Record("D1", BUY, "racer-1", FAIL)
+
+		problems := tally.Verify([]string{"D1"}, 2)
+		if len(problems) != 0 {
+			t.Fatalf("Expected no problems, got %v", problems)
+		}
+	})
+
+	t.Run("reports seats with more than one or no winner", func(t *testing.T) {
+		tally := NewContentionTally()
+		tally.Record("D1", RESERVE, "racer-1", OK)
+		tally.Record("D1", RESERVE, "racer-2", OK)
+		tally.Record("D1", BUY, "racer-1", FAIL)
+		tally.Record("D1", BUY, "racer-2", FAIL)
+
+		problems := tally.Verify([]string{"D1"}, 2)
+		if len(problems) != 2 {
+			t.Fatalf("Expected a problem for each verb, got %v", problems)
+		}
+	})
+
+	t.Run("reports losers that didnt see FAIL", func(t *testing.T) {
+		tally := NewContentionTally()
+		tally.Record("D1", RESERVE, "racer-1", OK)
+		tally.Record("D1", RESERVE, "racer-2", RESERVED)
+		tally.Record("D1", BUY, "racer-1", OK)
+		tally.Record("D1", BUY, "racer-2", FAIL)
+
+		problems := tally.Verify([]string{"D1"}, 2)
+		if len(problems) != 2 {
+			t.Fatalf("Expected unexpected response and missing loser to be reported, got %v", problems)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Operation struct {
+	client   string
+	verb     Verb
+	seat     string
+	response Status
+	pending  bool
+	invoked  time.Time
+	returned time.Time
+}
+
+func (o Operation) String() string {
+	if o.pending {
+		return fmt.Sprintf("[%s] %s: %s -> ? (invoked %s, never returned)", o.client, o.verb, o.seat, o.invoked.Format(time.StampMicro))
+	}
+	return fmt.Sprintf("[%s] %s: %s -> %s (invoked %s, returned %s)", o.client, o.verb, o.seat, o.response, o.invoked.Format(time.StampMicro), o.returned.Format(time.StampMicro))
+}
+
+type History struct {
+	operations []Operation
+	lock       sync.Mutex
+}
+
+func (h *History) Record(op Operation) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.operations = append(h.operations, op)
+}
+
+func (h *History) BySeat() map[string][]Operation {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	bySeat := map[string][]Operation{}
+	for _, op := range h.operations {
+		bySeat[op.seat] = append(bySeat[op.seat], op)
+	}
+	return bySeat
+}
+
+func (h *History) Len() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.operations)
+}
+
+func sortedSeats(bySeat map[string][]Operation) []string {
+	seats := make([]string, 0, len(bySeat))
+	for seat := range bySeat {
+		seats = append(seats, seat)
+	}
+	sort.Strings(seats)
+	return seats
+}
+
+type RecordingClient struct {
+	name    string
+	client  Client
+	history *History
+}
+
+func (r *RecordingClient) Send(message string) (string, error) {
+	invoked := time.Now()
+	response, err := r.client.Send(message)
+	returned := time.Now()
+
+	verb, seat, ok := parseSingleSeatMessage(message)
+	if !ok {
+		//Broken messages never change the state of a seat, nothing to check
+		return response, err
+	}
+
+	r.history.Record(Operation{
+		client:   r.name,
+		verb:     verb,
+		seat:     seat,
+		response: Status(response),
+		pending:  err != nil || response == "",
+		invoked:  invoked,
+		returned: returned,
+	})
+
+	return response, err
+}
+
+func (r *RecordingClient) Close() error {
+	return r.client.Close()
+}
+
+func parseSingleSeatMessage(message string) (Verb, string, bool) {
+	verbAndSeat := strings.Split(message, ": ")
+	if len(verbAndSeat) != 2 {
+		return "", "", false
+	}
+
+	verb := Verb(verbAndSeat[0])
+	seat := verbAndSeat[1]
+	if verb != RESERVE && verb != BUY && verb != QUERY {
+		return "", "", false
+	}
+	if seat == "" || strings.ContainsAny(seat, ", ") {
+		return "", "", false
+	}
+	return verb, seat, true
+}
+
+func NewRecordingClient(name string, client Client, history *History) Client {
+	return &RecordingClient{
+		name:    name,
+		client:  client,
+		history: history,
+	}
+}
+
+func NewHistory() *History {
+	return &History{}
+}
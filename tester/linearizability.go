@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type LinearizabilityViolation struct {
+	seat    string
+	history []Operation
+}
+
+func (v LinearizabilityViolation) Error() string {
+	lines := make([]string, 0, len(v.history))
+	for _, op := range v.history {
+		lines = append(lines, "\t"+op.String())
+	}
+	return fmt.Sprintf("history for seat [%s] is not linearizable:\n%s", v.seat, strings.Join(lines, "\n"))
+}
+
+// applyToSeat is the sequential specification every seat must behave like: FREE -> RESERVED -> SOLD
+func applyToSeat(state Status, verb Verb) (Status, Status) {
+	switch verb {
+	case RESERVE:
+		if state == FREE {
+			return RESERVED, OK
+		}
+		return state, FAIL
+	case BUY:
+		if state == RESERVED {
+			return SOLD, OK
+		}
+		return state, FAIL
+	case QUERY:
+		return state, state
+	}
+	return state, FAIL
+}
+
+func CheckLinearizability(history *History) []LinearizabilityViolation {
+	var violations []LinearizabilityViolation
+	bySeat := history.BySeat()
+	for _, seat := range sortedSeats(bySeat) {
+		ops := bySeat[seat]
+		if isLinearizable(ops) {
+			continue
+		}
+		violations = append(violations, LinearizabilityViolation{
+			seat:    seat,
+			history: shortestViolatingPrefix(ops),
+		})
+	}
+	return violations
+}
+
+func isLinearizable(ops []Operation) bool {
+	search := &linearizationSearch{
+		ops:     ops,
+		visited: map[string]bool{},
+	}
+	return search.run(make([]bool, len(ops)), FREE)
+}
+
+// shortestViolatingPrefix cuts the history at the earliest response that cannot be explained.
+// Operations still in flight at that point are kept, but their response is treated as unknown.
+func shortestViolatingPrefix(ops []Operation) []Operation {
+	var cutPoints []time.Time
+	for _, op := range ops {
+		if !op.pending {
+			cutPoints = append(cutPoints, op.returned)
+		}
+	}
+	sort.Slice(cutPoints, func(i, j int) bool { return cutPoints[i].Before(cutPoints[j]) })
+
+	for _, cut := range cutPoints {
+		prefix := historyUntil(ops, cut)
+		if !isLinearizable(prefix) {
+			return prefix
+		}
+	}
+	return ops
+}
+
+func historyUntil(ops []Operation, cut time.Time) []Operation {
+	var prefix []Operation
+	for _, op := range ops {
+		if op.invoked.After(cut) {
+			continue
+		}
+		if !op.pending && op.returned.After(cut) {
+			op.pending = true
+			op.response = ""
+		}
+		prefix = append(prefix, op)
+	}
+	sort.SliceStable(prefix, func(i, j int) bool { return prefix[i].invoked.Before(prefix[j].invoked) })
+	return prefix
+}
+
+type linearizationSearch struct {
+	ops     []Operation
+	visited map[string]bool
+}
+
+func (s *linearizationSearch) run(linearized []bool, state Status) bool {
+	//Only operations invoked before the earliest outstanding response can be the next to take effect
+	var deadline time.Time
+	outstanding := false
+	for i, op := range s.ops {
+		if linearized[i] || op.pending {
+			continue
+		}
+		if !outstanding || op.returned.Before(deadline) {
+			deadline = op.returned
+			outstanding = true
+		}
+	}
+	if !outstanding {
+		//Whatever is left never returned, so it might never have taken effect
+		return true
+	}
+
+	key := searchKey(linearized, state)
+	if s.visited[key] {
+		return false
+	}
+	s.visited[key] = true
+
+	for i, op := range s.ops {
+		if linearized[i] || op.invoked.After(deadline) {
+			continue
+		}
+
+		nextState, response := applyToSeat(state, op.verb)
+		if !op.pending && response != op.response {
+			continue
+		}
+
+		linearized[i] = true
+		if s.run(linearized, nextState) {
+			return true
+		}
+		linearized[i] = false
+	}
+
+	return false
+}
+
+func searchKey(linearized []bool, state Status) string {
+	key := make([]byte, len(linearized)+1)
+	key[0] = state[0]
+	for i, done := range linearized {
+		if done {
+			key[i+1] = '1'
+		} else {
+			key[i+1] = '0'
+		}
+	}
+	return string(key)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+
+func op(client string, verb Verb, seat string, response Status, invoked int, returned int) Operation {
+	return Operation{
+		client:   client,
+		verb:     verb,
+		seat:     seat,
+		response: response,
+		invoked:  epoch.Add(time.Duration(invoked) * time.Millisecond),
+		returned: epoch.Add(time.Duration(returned) * time.Millisecond),
+	}
+}
+
+func historyWith(ops ...Operation) *History {
+	history := NewHistory()
+	for _, o := range ops {
+		history.Record(o)
+	}
+	return history
+}
+
+func TestCheckLinearizability(t *testing.T) {
+	t.Run("Sequential histories following the state machine are linearizable", func(t *testing.T) {
+		history := historyWith(
+			op("a", QUERY, "A1", FREE, 0, 1),
+			op("a", RESERVE, "A1", OK, 2, 3),
+			op("b", RESERVE, "A1", FAIL, 4, 5),
+			op("b", QUERY, "A1", RESERVED, 6, 7),
+			op("a", BUY, "A1", OK, 8, 9),
+			op("b", BUY, "A1", FAIL, 10, 11),
+			op("b", QUERY, "A1", SOLD, 12, 13),
+			op("c", BUY, "B1", FAIL, 0, 1),
+		)
+
+		violations := CheckLinearizability(history)
+		if len(violations) != 0 {
+			t.Fatalf("Expected no violations, got %v", violations)
+		}
+	})
+
+	t.Run("Overlapping operations can be linearized in any order", func(t *testing.T) {
+		history := historyWith(
+			op("a", RESERVE, "A1", OK, 0, 10),
+			op("b", QUERY, "A1", FREE, 1, 2),
+			op("c", BUY, "A1", OK, 3, 12),
+			op("d", QUERY, "A1", RESERVED, 4, 11),
+		)
+
+		violations := CheckLinearizability(history)
+		if len(violations) != 0 {
+			t.Fatalf("Expected no violations, got %v", violations)
+		}
+	})
+
+	t.Run("Two clients reserving the same seat cannot both succeed", func(t *testing.T) {
+		history := historyWith(
+			op("a", RESERVE, "A1", OK, 0, 5),
+			op("b", RESERVE, "A1", OK, 1, 6),
+			op("c", RESERVE, "B1", OK, 1, 6),
+		)
+
+		violations := CheckLinearizability(history)
+		if len(violations) != 1 || violations[0].seat != "A1" {
+			t.Fatalf("Expected exactly one violation for seat A1, got %v", violations)
+		}
+	})
+
+	t.Run("Operations that never returned may or may not have taken effect", func(t *testing.T) {
+		pending := op("a", RESERVE, "A1", "", 0, 0)
+		pending.pending = true
+
+		tookEffect := historyWith(pending, op("b", QUERY, "A1", RESERVED, 5, 6))
+		if violations := CheckLinearizability(tookEffect); len(violations) != 0 {
+			t.Errorf("Expected no violations when pending operation took effect, got %v", violations)
+		}
+
+		neverTookEffect := historyWith(pending, op("b", QUERY, "A1", FREE, 5, 6))
+		if violations := CheckLinearizability(neverTookEffect); len(violations) != 0 {
+			t.Errorf("Expected no violations when pending operation never took effect, got %v", violations)
+		}
+	})
+
+	t.Run("Reports the shortest prefix that is not linearizable", func(t *testing.T) {
+		history := historyWith(
+			op("a", RESERVE, "A1", OK, 0, 1),
+			op("b", QUERY, "A1", RESERVED, 2, 3),
+			op("c", QUERY, "A1", FREE, 4, 6),
+			op("d", BUY, "A1", OK, 5, 8),
+			op("e", QUERY, "A1", SOLD, 9, 10),
+		)
+
+		violations := CheckLinearizability(history)
+		if len(violations) != 1 {
+			t.Fatalf("Expected one violation, got %v", violations)
+		}
+
+		reported := violations[0].history
+		if len(reported) != 4 {
+			t.Fatalf("Expected violating history to stop at the stale query, got %v", reported)
+		}
+		if !reported[3].pending || reported[3].client != "d" {
+			t.Errorf("Expected in flight BUY to be reported with unknown response, got %v", reported[3])
+		}
+	})
+}
+
+func TestRecordingClient(t *testing.T) {
+	t.Run("Records valid single seat commands and ignores broken ones", func(t *testing.T) {
+		history := NewHistory()
+		mockClient := &MockClient{ListOfResponsesToReturn: []string{"OK", "FAIL", "FAIL", "RESERVED"}}
+		client := NewRecordingClient("recorder", mockClient, history)
+
+		for _, message := range []string{"RESERVE: A1", "🍌", "BUY: A1,A2", "QUERY: A1"} {
+			if _, err := client.Send(message); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+
+		recorded := history.BySeat()["A1"]
+		if len(recorded) != 2 || history.Len() != 2 {
+			t.Fatalf("Expected two operations to be recorded, got %v", recorded)
+		}
+		if recorded[0].verb != RESERVE || recorded[0].response != OK || recorded[1].response != RESERVED {
+			t.Errorf("Unexpected operations recorded: %v", recorded)
+		}
+	})
+}
@@ -1,26 +1,63 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"math/rand"
 	"os"
+	"time"
 )
 
 func main() {
+	consumerHost := flag.String("consumer-host", "localhost", "The host or IP address where your server can be reached by the CONSUMER")
 	consumerPort := flag.Int("consumer-port", 8099, "The port your server exposes to the CONSUMER")
+	dialTimeout := flag.Duration("dial-timeout", 5*time.Second, "How long to wait when opening each connection to the server")
+	useTls := flag.Bool("tls", false, "Connects to the server using TLS")
+	tlsCa := flag.String("tls-ca", "", "PEM file with the CA certificates used to verify the server, uses the system pool when empty")
+	tlsCert := flag.String("tls-cert", "", "PEM client certificate for servers that require one")
+	tlsKey := flag.String("tls-key", "", "PEM private key of the client certificate")
+	tlsInsecure := flag.Bool("tls-insecure", false, "Skips verification of the server certificate, only use it for testing")
 	numSeats := flag.Int("seats", 500000, "A positive value indicating how many concurrent clients to use")
 	concurrencyLevel := flag.Int("concurrency", 150, "A positive value indicating how many concurrent clients to use")
 	randomSeed := flag.Int64("seed", 42, "A positive value used to seed the random number generator")
 	debugMode := flag.Bool("debug", false, "Prints some extra information and opens a HTTP server on port 8081")
+	numRacers := flag.Int("racers", 10, "How many clients race to RESERVE and BUY the same contended seats, 0 disables contention")
+	numContendedSeats := flag.Int("contended-seats", 1000, "How many seats all racers fight over")
+	soakDuration := flag.Duration("soak", 0, "Keeps running fresh workloads for this long instead of a single pass, 0 disables soak mode")
+	soakSeats := flag.Int("soak-seats", 3000, "How many seats each soak round works on")
+	soakSampleInterval := flag.Duration("soak-sample-interval", 30*time.Second, "How often server stats are sampled during a soak")
+	statsSecret := flag.String("stats-secret", "", "Secret used to ask the server for its STATS, memory growth isn't reported when empty")
+	protocolVersion := flag.Int("protocol-version", 0, "Protocol version negotiated with HELLO on every connection, 0 skips the handshake unless there is an auth token")
+	authToken := flag.String("auth-token", "", "Token sent with AUTH on every connection, for servers that only let known principals buy")
 	unluckiness := flag.Int("unluckiness", 5, "A % showing the probability of something bad happenning, like broken messages being sent or random disconnects")
 
 	flag.Parse()
 	rand.Seed(*randomSeed)
 
 	logger := NewLogger(*debugMode)
-	test := NewTester(*consumerPort, *numSeats, *concurrencyLevel, *unluckiness, logger)
 
-	test.Start()
+	var tlsConfig *tls.Config
+	if *useTls {
+		var err error
+		tlsConfig, err = NewTlsConfig(*consumerHost, *tlsCa, *tlsInsecure, *tlsCert, *tlsKey)
+		if err != nil {
+			logger.Errorf("Invalid TLS configuration: %v", err)
+			os.Exit(2)
+		}
+	}
+
+	clientConfig := NewClientConfig(*consumerHost, *consumerPort, *dialTimeout, tlsConfig, *protocolVersion, *authToken)
+	test := NewTester(clientConfig, *numSeats, *concurrencyLevel, *unluckiness, *numRacers, *numContendedSeats, logger)
+
+	if *soakDuration > 0 {
+		test.Soak(NewSoak(*soakDuration, *soakSeats, *soakSampleInterval, *statsSecret))
+	}
+
+	err := test.Start()
+	if err != nil {
+		logger.Errorf("Could not start test: %v", err)
+		os.Exit(2)
+	}
 	test.Run()
 	test.Finish()
 	os.Exit(0)
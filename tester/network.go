@@ -2,80 +2,227 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"strconv"
 	"strings"
+	"time"
 )
 
+const (
+	HELLO = Verb("HELLO")
+	AUTH  = Verb("AUTH")
+)
+
+// AUTH_PROTOCOL_VERSION is the first protocol version with AUTH, clients with a token ask for it unless told
+// to ask for another
+const AUTH_PROTOCOL_VERSION = 4
+
 type Client interface {
 	Send(message string) (string, error)
+	Close() error
+}
+
+type ClientConfig struct {
+	host            string
+	port            int
+	dialTimeout     time.Duration
+	tlsConfig       *tls.Config
+	protocolVersion int
+	authToken       string
+}
+
+func (c ClientConfig) Address() string {
+	return net.JoinHostPort(c.host, strconv.Itoa(c.port))
+}
+
+type UnreachableServerError struct {
+	address string
+	err     error
+}
+
+func (e *UnreachableServerError) Error() string {
+	return fmt.Sprintf("could not reach server at [%s], make sure it is running and reachable from here: %v", e.address, e.err)
 }
 
 type TcpClient struct {
-	port   string
-	conn   net.Conn
-	logger *Logger
+	address         string
+	conn            net.Conn
+	protocolVersion int
+	extendedErrors  bool
+	logger          *Logger
 }
 
-func (c *TcpClient) connect() error {
-	c.logger.Debugf("Client connecting to port [%s]", c.port)
-	conn, err := net.Dial("tcp", c.port)
+// negotiate says HELLO asking for the version given, and remembers what the server agreed to
+func (c *TcpClient) negotiate(version int) error {
+	response, err := c.Send(fmt.Sprintf("%s: %d", HELLO, version))
 	if err != nil {
-		c.logger.Errorf("Client found error while connecting to port [%s]: %v", c.port, err)
 		return err
 	}
-	c.conn = conn
+
+	fields := strings.Fields(response)
+	if len(fields) < 2 || fields[0] != string(OK) {
+		return fmt.Errorf("server at [%s] refused protocol version [%d]: [%s]", c.address, version, response)
+	}
+	c.protocolVersion, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("server at [%s] answered HELLO with an invalid version: [%s]", c.address, response)
+	}
+	for _, feature := range fields[2:] {
+		if feature == "errors=extended" {
+			c.extendedErrors = true
+		}
+	}
+
+	c.logger.Debugf("Speaking protocol version [%d] with [%s]", c.protocolVersion, c.address)
 	return nil
 }
 
-func (c *TcpClient) disconnect() error {
+func (c *TcpClient) Close() error {
 	err := c.conn.Close()
 	if err != nil {
-		c.logger.Errorf("Client found error while disconnecting from port [%s]: %v", c.port, err)
+		c.logger.Errorf("Client found error while disconnecting from [%s]: %v", c.address, err)
 	}
 	return err
 }
 
+// authenticate says who the tester is, so servers that only let known principals buy let it run
+func (c *TcpClient) authenticate(token string) error {
+	response, err := c.Send(fmt.Sprintf("%s: %s", AUTH, token))
+	if err != nil {
+		return err
+	}
+	if response != string(OK) {
+		return fmt.Errorf("server at [%s] refused the auth token: [%s]", c.address, response)
+	}
+	return nil
+}
+
 func (c *TcpClient) Send(message string) (string, error) {
-	c.logger.Debugf("Sending message [%s] to client on port [%s]", message, c.port)
+	c.logger.Debugf("Sending message [%s] to server at [%s]", message, c.address)
 	_, err := fmt.Fprintln(c.conn, message)
 	if err == io.EOF {
-		c.logger.Debugf("client on port [%s] closed connection", c.port)
+		c.logger.Debugf("server at [%s] closed connection", c.address)
 		return "", nil
 	}
 	if err != nil {
-		return "", fmt.Errorf("client found error while writing to socket at [%s]: %v", c.port, err)
+		return "", fmt.Errorf("client found error while writing to socket at [%s]: %v", c.address, err)
 	}
 
 	reader := bufio.NewReader(c.conn)
-	c.logger.Debugf("Reading response from port [%s]", c.port)
+	c.logger.Debugf("Reading response from [%s]", c.address)
 	response, err := reader.ReadString('\n')
 	if err == io.EOF {
-		c.logger.Debugf("client on port [%s] closed connection", c.port)
+		c.logger.Debugf("server at [%s] closed connection", c.address)
 		return "", nil
 	}
 	if err != nil {
-		return "", fmt.Errorf("client found error while reading socket at [%s]: %v", c.port, err)
+		return "", fmt.Errorf("client found error while reading socket at [%s]: %v", c.address, err)
 	}
 	responseMsg := strings.TrimRight(response, "\n")
 
-	c.logger.Debugf("received message [%s] from client on port [%s]", responseMsg, c.port)
+	c.logger.Debugf("received message [%s] from server at [%s]", responseMsg, c.address)
+
+	//The code and message of extended failures are only worth logging, the rest of the tester expects FAIL
+	if c.extendedErrors && strings.HasPrefix(responseMsg, string(FAIL)+" ") {
+		c.logger.Debugf("server at [%s] failed with [%s]", c.address, responseMsg)
+		responseMsg = string(FAIL)
+	}
 
 	return responseMsg, err
 }
 
-func NewTcpClient(port int, logger *Logger) (Client, error) {
-	host := fmt.Sprintf("localhost:%d", port)
-	conn, err := net.Dial("tcp", host)
+func dial(config ClientConfig) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: config.dialTimeout}
+	if config.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", config.Address(), config.tlsConfig)
+	}
+	return dialer.Dial("tcp", config.Address())
+}
+
+// NewTlsConfig verifies the server against the CAs in caFile, or the system ones when empty. Servers that
+// require client certificates get the one in certFile and keyFile.
+func NewTlsConfig(host string, caFile string, insecureSkipVerify bool, certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA certificates from [%s]: %v", caFile, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid PEM certificates found in [%s]", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate [%s] and key [%s]: %v", certFile, keyFile, err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
 
+	return config, nil
+}
+
+// NewClientConfig describes how to reach the server, clients negotiate protocolVersion with HELLO when
+// connecting unless it is 0, and then send authToken with AUTH unless it is empty. Clients with a token and
+// no version ask for AUTH_PROTOCOL_VERSION.
+func NewClientConfig(host string, port int, dialTimeout time.Duration, tlsConfig *tls.Config, protocolVersion int, authToken string) ClientConfig {
+	return ClientConfig{
+		host:            host,
+		port:            port,
+		dialTimeout:     dialTimeout,
+		tlsConfig:       tlsConfig,
+		protocolVersion: protocolVersion,
+		authToken:       authToken,
+	}
+}
+
+func NewTcpClient(config ClientConfig, logger *Logger) (Client, error) {
+	address := config.Address()
+	logger.Debugf("Client connecting to [%s]", address)
+	conn, err := dial(config)
 	if err != nil {
-		return nil, err
+		return nil, &UnreachableServerError{address, err}
+	}
+
+	client := &TcpClient{
+		address: address,
+		conn:    conn,
+		logger:  logger,
+	}
+
+	protocolVersion := config.protocolVersion
+	if protocolVersion == 0 && config.authToken != "" {
+		protocolVersion = AUTH_PROTOCOL_VERSION
+	}
+	if protocolVersion > 0 {
+		err = client.negotiate(protocolVersion)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if config.authToken != "" {
+		err = client.authenticate(config.authToken)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
 
-	return &TcpClient{
-		fmt.Sprintf(":%d", port),
-		conn,
-		logger,
-	}, nil
+	return client, nil
 }
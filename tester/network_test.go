@@ -1,21 +1,106 @@
 package main
 
 import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math/big"
 	"net"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func respondWith(t *testing.T, server net.Listener, responseCode string) {
 	for {
 		conn, err := server.Accept()
 		if err != nil {
-			t.Fatalf("Error reading socket: %v", err)
+			//Listener was closed, test is over
+			return
 		}
 		fmt.Fprintln(conn, responseCode)
 	}
 }
 
+// converse answers each line a client sends with whatever answer returns for it
+func converse(server net.Listener, answer func(message string) string) {
+	for {
+		conn, err := server.Accept()
+		if err != nil {
+			//Listener was closed, test is over
+			return
+		}
+		go func(conn net.Conn) {
+			reader := bufio.NewReader(conn)
+			for {
+				message, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				fmt.Fprintln(conn, answer(strings.TrimSpace(message)))
+			}
+		}(conn)
+	}
+}
+
+func selfSignedCertificate(t *testing.T, host string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writePEM saves the certificate and its key where NewTlsConfig can read them, returning both paths
+func writePEM(t *testing.T, certificate tls.Certificate) (string, string) {
+	dir, err := ioutil.TempDir("", "tester-tls")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(certificate.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("Error encoding key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("Error writing certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("Error writing key: %v", err)
+	}
+	return certFile, keyFile
+}
+
 func TestTcpClient(t *testing.T) {
 	t.Run("Sends many messages to server socket", func(t *testing.T) {
 		goodPort := 8081
@@ -28,7 +113,7 @@ func TestTcpClient(t *testing.T) {
 
 		go respondWith(t, goodServer, expectedReturn)
 
-		client, err := NewTcpClient(goodPort, NewLogger(false))
+		client, err := NewTcpClient(NewClientConfig("localhost", goodPort, time.Second, nil, 0, ""), NewLogger(false))
 		if err != nil {
 			t.Fatalf("Error connecting to server: %v", err)
 		}
@@ -44,4 +129,220 @@ func TestTcpClient(t *testing.T) {
 		}
 
 	})
+
+	t.Run("Talks to servers over TLS", func(t *testing.T) {
+		certificate := selfSignedCertificate(t, "localhost")
+		tlsServer, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+		if err != nil {
+			t.Fatalf("Error opening test server: %v", err)
+		}
+		defer tlsServer.Close()
+
+		go respondWith(t, tlsServer, "FREE")
+
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			t.Fatalf("Error parsing certificate: %v", err)
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(leaf)
+
+		port := tlsServer.Addr().(*net.TCPAddr).Port
+		tlsConfig := &tls.Config{ServerName: "localhost", RootCAs: roots}
+		client, err := NewTcpClient(NewClientConfig("127.0.0.1", port, time.Second, tlsConfig, 0, ""), NewLogger(false))
+		if err != nil {
+			t.Fatalf("Error connecting to server: %v", err)
+		}
+
+		response, err := client.Send("QUERY: A1")
+		if err != nil {
+			t.Fatalf("Error sending message to server: %v", err)
+		}
+		if response != "FREE" {
+			t.Errorf("Expected response to be FREE, got %v", response)
+		}
+	})
+
+	t.Run("Presents a client certificate to servers that require one", func(t *testing.T) {
+		serverCertificate := selfSignedCertificate(t, "localhost")
+		clientCertificate := selfSignedCertificate(t, "box-office")
+		caFile, _ := writePEM(t, serverCertificate)
+		certFile, keyFile := writePEM(t, clientCertificate)
+
+		tlsServer, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{serverCertificate},
+			ClientAuth:   tls.RequireAnyClientCert,
+		})
+		if err != nil {
+			t.Fatalf("Error opening test server: %v", err)
+		}
+		defer tlsServer.Close()
+
+		clientNames := make(chan string, 1)
+		go func() {
+			for {
+				conn, err := tlsServer.Accept()
+				if err != nil {
+					return
+				}
+				tlsConn := conn.(*tls.Conn)
+				if err := tlsConn.Handshake(); err == nil {
+					clientNames <- tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+				}
+				fmt.Fprintln(conn, "OK")
+			}
+		}()
+
+		tlsConfig, err := NewTlsConfig("localhost", caFile, false, certFile, keyFile)
+		if err != nil {
+			t.Fatalf("Unexpected error building TLS configuration: %v", err)
+		}
+
+		port := tlsServer.Addr().(*net.TCPAddr).Port
+		client, err := NewTcpClient(NewClientConfig("127.0.0.1", port, time.Second, tlsConfig, 0, ""), NewLogger(false))
+		if err != nil {
+			t.Fatalf("Error connecting to server: %v", err)
+		}
+		defer client.Close()
+
+		if _, err := client.Send("QUERY: A1"); err != nil {
+			t.Fatalf("Error sending message to server: %v", err)
+		}
+		if name := <-clientNames; name != "box-office" {
+			t.Errorf("Expected server to see client certificate [box-office], got [%s]", name)
+		}
+	})
+
+	t.Run("Reports unreachable servers clearly", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error opening test server: %v", err)
+		}
+		closedPort := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+
+		_, err = NewTcpClient(NewClientConfig("127.0.0.1", closedPort, time.Second, nil, 0, ""), NewLogger(false))
+		if _, ok := err.(*UnreachableServerError); !ok {
+			t.Fatalf("Expected unreachable server error, got %v", err)
+		}
+	})
+
+	t.Run("Negotiates the protocol version when asked to", func(t *testing.T) {
+		server, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error opening test server: %v", err)
+		}
+		defer server.Close()
+
+		hellos := make(chan string, 1)
+		go converse(server, func(message string) string {
+			if strings.HasPrefix(message, "HELLO: ") {
+				hellos <- message
+				return "OK 3 commands=BUY,QUERY,RESERVE errors=extended"
+			}
+			return "FAIL SEAT_SOLD seat is sold"
+		})
+
+		port := server.Addr().(*net.TCPAddr).Port
+		client, err := NewTcpClient(NewClientConfig("127.0.0.1", port, time.Second, nil, 5, ""), NewLogger(false))
+		if err != nil {
+			t.Fatalf("Error connecting to server: %v", err)
+		}
+		defer client.Close()
+
+		if hello := <-hellos; hello != "HELLO: 5" {
+			t.Errorf("Expected client to ask for version 5, sent [%s]", hello)
+		}
+		if version := client.(*TcpClient).protocolVersion; version != 3 {
+			t.Errorf("Expected client to speak version 3, got [%d]", version)
+		}
+
+		response, err := client.Send("RESERVE: A1")
+		if err != nil {
+			t.Fatalf("Error sending message to server: %v", err)
+		}
+		if response != "FAIL" {
+			t.Errorf("Expected extended failures to read as FAIL, got [%s]", response)
+		}
+	})
+
+	t.Run("Authenticates after negotiating", func(t *testing.T) {
+		server, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error opening test server: %v", err)
+		}
+		defer server.Close()
+
+		handshake := make(chan string, 2)
+		go converse(server, func(message string) string {
+			handshake <- message
+			switch message {
+			case "HELLO: 2":
+				return "OK 2 commands=AUTH,BUY,QUERY,RESERVE errors=legacy"
+			case "AUTH: t0k3n":
+				return "OK"
+			}
+			return "FAIL"
+		})
+
+		port := server.Addr().(*net.TCPAddr).Port
+		client, err := NewTcpClient(NewClientConfig("127.0.0.1", port, time.Second, nil, 2, "t0k3n"), NewLogger(false))
+		if err != nil {
+			t.Fatalf("Error connecting to server: %v", err)
+		}
+		defer client.Close()
+
+		if first, second := <-handshake, <-handshake; first != "HELLO: 2" || second != "AUTH: t0k3n" {
+			t.Errorf("Expected HELLO then AUTH, got [%s] and [%s]", first, second)
+		}
+	})
+
+	t.Run("Asks for a version with AUTH when only given a token", func(t *testing.T) {
+		server, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error opening test server: %v", err)
+		}
+		defer server.Close()
+
+		handshake := make(chan string, 2)
+		go converse(server, func(message string) string {
+			handshake <- message
+			switch message {
+			case "HELLO: 4":
+				return "OK 4 commands=AUTH,BUY,QUERY,RESERVE errors=extended"
+			case "AUTH: t0k3n":
+				return "OK"
+			}
+			return "FAIL"
+		})
+
+		port := server.Addr().(*net.TCPAddr).Port
+		client, err := NewTcpClient(NewClientConfig("127.0.0.1", port, time.Second, nil, 0, "t0k3n"), NewLogger(false))
+		if err != nil {
+			t.Fatalf("Error connecting to server: %v", err)
+		}
+		defer client.Close()
+
+		if first, second := <-handshake, <-handshake; first != "HELLO: 4" || second != "AUTH: t0k3n" {
+			t.Errorf("Expected HELLO: 4 then AUTH, got [%s] and [%s]", first, second)
+		}
+	})
+
+	t.Run("Fails to connect when the server refuses the version", func(t *testing.T) {
+		server, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error opening test server: %v", err)
+		}
+		defer server.Close()
+
+		go converse(server, func(message string) string {
+			return "FAIL"
+		})
+
+		port := server.Addr().(*net.TCPAddr).Port
+		_, err = NewTcpClient(NewClientConfig("127.0.0.1", port, time.Second, nil, 1, ""), NewLogger(false))
+		if err == nil {
+			t.Fatalf("Expected error when server refuses HELLO")
+		}
+	})
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const STATS = Verb("STATS")
+
+type StatsSample struct {
+	at     time.Time
+	values map[string]int64
+}
+
+func (s StatsSample) String() string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", key, s.values[key]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// ParseStats reads the single line a server answers to STATS, made of space separated key=value pairs
+func ParseStats(response string) (map[string]int64, error) {
+	values := map[string]int64{}
+	for _, pair := range strings.Fields(response) {
+		keyAndValue := strings.Split(pair, "=")
+		if len(keyAndValue) != 2 || keyAndValue[0] == "" {
+			return nil, fmt.Errorf("expected stats [%s] to follow form [key=value key=value]", response)
+		}
+
+		value, err := strconv.ParseInt(keyAndValue[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected value for [%s] in stats [%s] to be an integer: %v", keyAndValue[0], response, err)
+		}
+		values[keyAndValue[0]] = value
+	}
+
+	if len(values) == 0 {
+		return nil, fmt.Errorf("server sent no stats, response was [%s]", response)
+	}
+	return values, nil
+}
+
+func QueryStats(c Client, secret string) (StatsSample, error) {
+	message := Command{STATS, []string{secret}}.Serialize()
+	response, err := c.Send(message)
+	if err != nil {
+		return StatsSample{}, err
+	}
+	if Status(response) == FAIL {
+		return StatsSample{}, fmt.Errorf("server refused to send stats, is the secret right?")
+	}
+
+	values, err := ParseStats(response)
+	if err != nil {
+		return StatsSample{}, err
+	}
+	return StatsSample{time.Now(), values}, nil
+}
+
+func ReportGrowth(first StatsSample, last StatsSample) string {
+	elapsed := last.at.Sub(first.at).Round(time.Second)
+	var growth []string
+	for _, key := range []string{"heap_bytes", "goroutines", "connections"} {
+		before, hadBefore := first.values[key]
+		after, hasAfter := last.values[key]
+		if !hadBefore || !hasAfter {
+			continue
+		}
+		growth = append(growth, fmt.Sprintf("%s %d -> %d (%+d)", key, before, after, after-before))
+	}
+
+	if len(growth) == 0 {
+		return fmt.Sprintf("no memory stats reported over %s", elapsed)
+	}
+	return fmt.Sprintf("over %s: %s", elapsed, strings.Join(growth, ", "))
+}
+
+type Soak struct {
+	duration       time.Duration
+	seatsPerRound  int
+	sampleInterval time.Duration
+	statsSecret    string
+	samples        []StatsSample
+}
+
+func (t *Tester) sampleStats(soak *Soak) {
+	if soak.statsSecret == "" {
+		return
+	}
+
+	client, err := NewTcpClient(t.clientConfig, t.logger)
+	if err != nil {
+		t.failE(err)
+	}
+	defer client.Close()
+
+	sample, err := QueryStats(client, soak.statsSecret)
+	if err != nil {
+		t.logger.Errorf("Could not sample server stats: %v", err)
+		return
+	}
+
+	soak.samples = append(soak.samples, sample)
+	t.logger.Infof("Server stats: %v", sample)
+	if len(soak.samples) > 1 {
+		t.logger.Infof("Growth %s", ReportGrowth(soak.samples[0], sample))
+	}
+}
+
+// Soak keeps running rounds on fresh seats until the duration is over, checking invariants after every round
+func (t *Tester) Soak(soak *Soak) {
+	t.logger.InfoBannerf("Starting soak test against [%s] for [%s]", t.clientConfig.Address(), soak.duration)
+
+	err := t.prepareBackgroundConsumers()
+	if err != nil {
+		t.failE(err)
+	}
+	t.startBackgroundConsumers()
+
+	if soak.statsSecret == "" {
+		t.logger.Infof("No stats secret given, memory growth won't be reported")
+	}
+	t.sampleStats(soak)
+	lastSample := time.Now()
+
+	deadline := time.Now().Add(soak.duration)
+	for round := 0; time.Now().Before(deadline); round++ {
+		prefix := fmt.Sprintf("S%06dX", round)
+		t.logger.Infof("Starting soak round [%d] on seats prefixed by [%s]", round, prefix)
+
+		//Histories are checked per round so they don't grow for as long as the soak lasts
+		t.history = NewHistory()
+		err := t.prepareRound(prefix, soak.seatsPerRound)
+		if err != nil {
+			t.failE(err)
+		}
+
+		t.ensureServerIsClear()
+		t.runBlockingConsumers()
+		t.closeBlockingConsumers()
+
+		t.ensureContendedSeatsHadOneWinner()
+		t.ensureHistoryIsLinearizable()
+		t.ensureServerHasExpectedState(t.expectedResults)
+
+		if time.Since(lastSample) >= soak.sampleInterval {
+			t.sampleStats(soak)
+			lastSample = time.Now()
+		}
+	}
+
+	t.sampleStats(soak)
+	if len(soak.samples) > 1 {
+		t.logger.InfoBannerf("Server growth %s", ReportGrowth(soak.samples[0], soak.samples[len(soak.samples)-1]))
+	}
+	t.finishTest(false, "")
+}
+
+func NewSoak(duration time.Duration, seatsPerRound int, sampleInterval time.Duration, statsSecret string) *Soak {
+	return &Soak{
+		duration:       duration,
+		seatsPerRound:  seatsPerRound,
+		sampleInterval: sampleInterval,
+		statsSecret:    statsSecret,
+	}
+}
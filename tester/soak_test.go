@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStats(t *testing.T) {
+	t.Run("parses key value pairs", func(t *testing.T) {
+		stats, err := ParseStats("reserved=10 sold=3 heap_bytes=123456 goroutines=7")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		expected := map[string]int64{"reserved": 10, "sold": 3, "heap_bytes": 123456, "goroutines": 7}
+		if !reflect.DeepEqual(stats, expected) {
+			t.Fatalf("Expected stats %v, got %v", expected, stats)
+		}
+	})
+
+	t.Run("rejects anything else", func(t *testing.T) {
+		for _, response := range []string{"", "FAIL", "sold=", "=3", "sold=three", "sold=1=2"} {
+			stats, err := ParseStats(response)
+			if err == nil {
+				t.Errorf("Expected error parsing stats [%s], got %v", response, stats)
+			}
+		}
+	})
+}
+
+func TestQueryStats(t *testing.T) {
+	t.Run("sends secret and parses response", func(t *testing.T) {
+		mockClient := &MockClient{ResponseToReturnAlways: "goroutines=4"}
+
+		sample, err := QueryStats(mockClient, "s3cr3t")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if mockClient.ListOfMessageReceived[0] != "STATS: s3cr3t" {
+			t.Errorf("Expected STATS command to be sent, got %v", mockClient.ListOfMessageReceived)
+		}
+		if sample.values["goroutines"] != 4 {
+			t.Errorf("Expected goroutines to be 4, got %v", sample)
+		}
+	})
+
+	t.Run("reports servers refusing to send stats", func(t *testing.T) {
+		mockClient := &MockClient{ResponseToReturnAlways: "FAIL"}
+
+		_, err := QueryStats(mockClient, "wrong")
+		if err == nil {
+			t.Fatalf("Expected error, got nothing")
+		}
+	})
+}
+
+func TestReportGrowth(t *testing.T) {
+	t.Run("reports growth of memory related stats", func(t *testing.T) {
+		first := StatsSample{epoch, map[string]int64{"heap_bytes": 1000, "goroutines": 10, "sold": 1}}
+		last := StatsSample{epoch.Add(time.Minute), map[string]int64{"heap_bytes": 1500, "goroutines": 8, "sold": 9}}
+
+		report := ReportGrowth(first, last)
+		for _, expected := range []string{"1m0s", "heap_bytes 1000 -> 1500 (+500)", "goroutines 10 -> 8 (-2)"} {
+			if !strings.Contains(report, expected) {
+				t.Errorf("Expected report [%s] to contain [%s]", report, expected)
+			}
+		}
+		if strings.Contains(report, "sold") {
+			t.Errorf("Expected report [%s] to leave out inventory stats", report)
+		}
+	})
+}
@@ -8,13 +8,18 @@ import (
 )
 
 type Tester struct {
-	consumerPort        int
+	clientConfig        ClientConfig
 	numSeats            int
 	concurrency         int
 	unluckiness         int
+	numRacers           int
+	numContendedSeats   int
+	contendedSeats      []string
+	contention          *ContentionTally
 	blockingConsumers   []*Consumer
 	backgroundConsumers []*Consumer
 	expectedResults     map[string]Status
+	history             *History
 	logger              *Logger
 }
 
@@ -49,7 +54,13 @@ func (t *Tester) allKnownSeats() []string {
 
 func (t *Tester) ensureServerHasExpectedState(expectedResults map[string]Status) {
 
-	actualResults, err := QueryAllSeats(t.allKnownSeats(), t.newClient(), t.logger)
+	client, err := t.newClient("q")
+	if err != nil {
+		t.failE(err)
+	}
+	defer client.Close()
+
+	actualResults, err := QueryAllSeats(t.allKnownSeats(), client, t.logger)
 	if err != nil {
 		t.failF("Error while querying state of all known seats: %v", err)
 	}
@@ -71,32 +82,81 @@ func (t *Tester) ensureServerHasExpectedState(expectedResults map[string]Status)
 	}
 }
 
-func (t *Tester) newClient() Client {
-	client, err := NewTcpClient(t.consumerPort, t.logger)
+func (t *Tester) ensureHistoryIsLinearizable() {
+	t.logger.Infof("Checking [%d] recorded operations for linearizability", t.history.Len())
+
+	violations := CheckLinearizability(t.history)
+	for _, v := range violations {
+		t.logger.Errorf("%s - %v", "❌", v)
+	}
+
+	if len(violations) > 0 {
+		t.failF("[%d] seats went through states that no sequential execution explains, first one: %v", len(violations), violations[0])
+	}
+}
+
+func (t *Tester) ensureContendedSeatsHadOneWinner() {
+	if len(t.contendedSeats) == 0 {
+		return
+	}
+
+	t.logger.Infof("Checking [%d] racers got exactly one winner for each of [%d] contended seats", t.numRacers, len(t.contendedSeats))
+	problems := t.contention.Verify(t.contendedSeats, t.numRacers)
+	for _, p := range problems {
+		t.logger.Errorf("%s - %s", "❌", p)
+	}
+
+	if len(problems) > 0 {
+		t.failF("[%d] problems found while racing for contended seats, first one: %s", len(problems), problems[0])
+	}
+}
+
+func (t *Tester) newClient(name string) (Client, error) {
+	client, err := NewTcpClient(t.clientConfig, t.logger)
 	if err != nil {
-		t.failF("Error while connecting to server on port [%v]: %v", t.consumerPort, err)
+		return nil, fmt.Errorf("[%s] could not connect: %v", name, err)
 	}
 
-	return client
+	return NewRecordingClient(name, client, t.history), nil
 }
 
-func (t *Tester) Run() {
+func (t *Tester) newConsumer(name string, strategy Strategy) (*Consumer, error) {
+	client, err := t.newClient(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConsumer(name, client, strategy, t.logger), nil
+}
+
+func (t *Tester) ensureServerIsClear() {
 	t.logger.Infof("Making sure server is clear")
 	expectedState := map[string]Status{}
 	for _, seat := range t.allKnownSeats() {
 		expectedState[seat] = FREE
 	}
 	t.ensureServerHasExpectedState(expectedState)
+}
 
+func (t *Tester) startBackgroundConsumers() {
 	for _, c := range t.backgroundConsumers {
 		t.logger.Infof("Starting consumer [%s]", c.name)
-		go func() {
+		go func(consumer *Consumer) {
 			for {
-				c.Tick()
+				consumer.Tick()
 			}
-		}()
+		}(c)
 	}
+}
+
+func (t *Tester) Run() {
+	t.ensureServerIsClear()
+	t.startBackgroundConsumers()
+	t.runBlockingConsumers()
+	t.ensureContendedSeatsHadOneWinner()
+}
 
+func (t *Tester) runBlockingConsumers() {
 	wg := &sync.WaitGroup{}
 	wg.Add(len(t.blockingConsumers))
 
@@ -123,37 +183,70 @@ func (t *Tester) Run() {
 	wg.Wait()
 }
 
+func (t *Tester) closeBlockingConsumers() {
+	for _, c := range t.blockingConsumers {
+		err := c.Close()
+		if err != nil {
+			t.logger.Errorf("[%s] could not close connection: %v", c.name, err)
+		}
+	}
+}
+
 func (t *Tester) Finish() {
 	t.logger.InfoBannerf("Finishing test")
 
+	t.ensureHistoryIsLinearizable()
 	t.ensureServerHasExpectedState(t.expectedResults)
 
 	t.finishTest(false, "")
 }
 
-func (t *Tester) Start() {
-	t.logger.InfoBannerf("Starting test")
-	numSeatsPerType := t.numSeats / 3
+func (t *Tester) Start() error {
+	t.logger.InfoBannerf("Starting test against [%s]", t.clientConfig.Address())
+
+	err := t.prepareRound("", t.numSeats)
+	if err != nil {
+		return err
+	}
+
+	return t.prepareBackgroundConsumers()
+}
+
+func (t *Tester) prepareBackgroundConsumers() error {
+	brokenConsumer, err := t.newConsumer("broken-consumer", NewBrokenConsumer(t.failE, t.logger))
+	if err != nil {
+		return err
+	}
+	t.backgroundConsumers = []*Consumer{brokenConsumer}
+	return nil
+}
+
+// prepareRound builds a fresh workload, seats are named after prefix so that rounds never share seats
+func (t *Tester) prepareRound(prefix string, numSeats int) error {
+	numSeatsPerType := numSeats / 3
 	numRepeatersPerType := t.concurrency / 3
 
 	t.expectedResults = map[string]Status{}
+	t.contendedSeats = nil
+	t.contention = NewContentionTally()
+
 	var seatsToRemain []string
 	for i := 0; i < numSeatsPerType; i++ {
-		seat := fmt.Sprintf("A%03d", i)
+		seat := fmt.Sprintf("%sA%03d", prefix, i)
 		t.expectedResults[seat] = FREE
 		seatsToRemain = append(seatsToRemain, seat)
 	}
 
 	var seatsToBuy []string
 	for i := 0; i < numSeatsPerType; i++ {
-		seat := fmt.Sprintf("B%03d", i)
+		seat := fmt.Sprintf("%sB%03d", prefix, i)
 		t.expectedResults[seat] = SOLD
 		seatsToBuy = append(seatsToBuy, seat)
 	}
 
 	var seatsToAllocateOnly []string
 	for i := 0; i < numSeatsPerType; i++ {
-		seat := fmt.Sprintf("C%03d", i)
+		seat := fmt.Sprintf("%sC%03d", prefix, i)
 		t.expectedResults[seat] = RESERVED
 		seatsToAllocateOnly = append(seatsToAllocateOnly, seat)
 	}
@@ -161,29 +254,55 @@ func (t *Tester) Start() {
 	allSeatsToAllocate := append(seatsToAllocateOnly, seatsToBuy...)
 
 	var allocators []*Consumer
-	for i, r := range NewManyRepeaters(numRepeatersPerType, allSeatsToAllocate, RESERVE, t.logger){
-		name := fmt.Sprintf("allocator-%03d", i)
-		allocators = append(allocators, NewConsumer(name, t.newClient(), r, t.logger))
+	for i, r := range NewManyRepeaters(numRepeatersPerType, allSeatsToAllocate, RESERVE, t.logger) {
+		allocator, err := t.newConsumer(fmt.Sprintf("allocator-%03d", i), r)
+		if err != nil {
+			return err
+		}
+		allocators = append(allocators, allocator)
 	}
 
 	var buyers []*Consumer
-	for i, r := range NewManyRepeaters(numRepeatersPerType, seatsToBuy, BUY, t.logger){
-		name := fmt.Sprintf("buyer-%03d", i)
-		buyers = append(buyers, NewConsumer(name, t.newClient(), r, t.logger))
+	for i, r := range NewManyRepeaters(numRepeatersPerType, seatsToBuy, BUY, t.logger) {
+		buyer, err := t.newConsumer(fmt.Sprintf("buyer-%03d", i), r)
+		if err != nil {
+			return err
+		}
+		buyers = append(buyers, buyer)
 	}
 
-	t.blockingConsumers = append(buyers, allocators...)
+	if t.numRacers > 0 {
+		for i := 0; i < t.numContendedSeats; i++ {
+			seat := fmt.Sprintf("%sD%03d", prefix, i)
+			t.expectedResults[seat] = SOLD
+			t.contendedSeats = append(t.contendedSeats, seat)
+		}
+	}
 
-	brokenConsumer := NewConsumer("broken-consumer", t.newClient(), NewBrokenConsumer(t.failE, t.logger), t.logger)
-	t.backgroundConsumers = []*Consumer{brokenConsumer}
+	var racers []*Consumer
+	for i := 0; i < t.numRacers && len(t.contendedSeats) > 0; i++ {
+		racer, err := t.newConsumer(fmt.Sprintf("racer-%03d", i), NewContentionStrategy(t.contendedSeats, t.contention, t.logger))
+		if err != nil {
+			return err
+		}
+		racers = append(racers, racer)
+	}
+
+	t.blockingConsumers = append(buyers, allocators...)
+	t.blockingConsumers = append(t.blockingConsumers, racers...)
+	return nil
 }
 
-func NewTester(consumerPort int, numSeats int, concurrencyLevel int, unluckiness int, logger *Logger) *Tester {
+func NewTester(clientConfig ClientConfig, numSeats int, concurrencyLevel int, unluckiness int, numRacers int, numContendedSeats int, logger *Logger) *Tester {
 
 	return &Tester{
-		consumerPort: consumerPort,
-		concurrency:  concurrencyLevel,
-		numSeats:     numSeats,
-		logger:       logger,
+		clientConfig:      clientConfig,
+		concurrency:       concurrencyLevel,
+		numSeats:          numSeats,
+		numRacers:         numRacers,
+		numContendedSeats: numContendedSeats,
+		contention:        NewContentionTally(),
+		history:           NewHistory(),
+		logger:            logger,
 	}
 }
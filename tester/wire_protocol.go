@@ -20,7 +20,37 @@ const (
 	RESERVED = Status("RESERVED")
 )
 
-var validResponses = []Status{OK, FAIL, FREE, SOLD}
+var validResponses = []Status{OK, FAIL, FREE, SOLD, RESERVED}
+
+var expectedResponses = map[Verb][]Status{
+	RESERVE: {OK, FAIL},
+	BUY:     {OK, FAIL},
+	QUERY:   {FREE, RESERVED, SOLD},
+}
+
+//Anything that isn't a known verb must be rejected
+var expectedResponsesForInvalidMessages = []Status{FAIL}
+
+type ViolationKind string
+
+const (
+	EMPTY_RESPONSE      = ViolationKind("empty response")
+	EXTRA_WHITESPACE    = ViolationKind("extra whitespace")
+	WRONG_CASE          = ViolationKind("wrong case")
+	UNEXPECTED_FOR_VERB = ViolationKind("unexpected response for verb")
+	UNKNOWN_RESPONSE    = ViolationKind("unknown response")
+)
+
+type ProtocolViolation struct {
+	kind     ViolationKind
+	message  string
+	response string
+	expected []Status
+}
+
+func (v *ProtocolViolation) Error() string {
+	return fmt.Sprintf("protocol violation (%s): got response %q to message [%s], should be one of %v", v.kind, v.response, v.message, v.expected)
+}
 
 type Command struct {
 	verb  Verb
@@ -43,6 +73,11 @@ func QuerySeat(seat string) Command {
 	return Command{QUERY, []string{seat}}
 }
 
+// QuerySeats asks about many seats in one bulk QUERY, servers answer with their statuses comma separated
+func QuerySeats(seats ...string) Command {
+	return Command{QUERY, seats}
+}
+
 var verbFunc = map[Verb]func(...string) Command{
 	BUY:     BuySeats,
 	RESERVE: AllocateSeats,
@@ -81,3 +116,48 @@ func ParseResponse(response string) (Status, error) {
 
 	return "", fmt.Errorf("unexpected response [%s], should be one of %v", response, validResponses)
 }
+
+func ExpectedResponses(verb Verb) []Status {
+	expected, ok := expectedResponses[verb]
+	if !ok {
+		return expectedResponsesForInvalidMessages
+	}
+	return expected
+}
+
+func ParseResponseTo(message string, verb Verb, response string) (Status, error) {
+	expected := ExpectedResponses(verb)
+	if containsStatus(expected, Status(response)) {
+		return Status(response), nil
+	}
+
+	violation := &ProtocolViolation{
+		kind:     UNKNOWN_RESPONSE,
+		message:  message,
+		response: response,
+		expected: expected,
+	}
+
+	trimmed := strings.TrimSpace(response)
+	switch {
+	case response == "":
+		violation.kind = EMPTY_RESPONSE
+	case trimmed != response:
+		violation.kind = EXTRA_WHITESPACE
+	case containsStatus(validResponses, Status(response)):
+		violation.kind = UNEXPECTED_FOR_VERB
+	case containsStatus(validResponses, Status(strings.ToUpper(response))):
+		violation.kind = WRONG_CASE
+	}
+
+	return "", violation
+}
+
+func containsStatus(statuses []Status, status Status) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -82,7 +82,7 @@ func TestParseCommands(t *testing.T) {
 
 func TestParseResponse(t *testing.T) {
 	t.Run("parse valid responses as expected", func(t *testing.T) {
-		expectations := map[string]Status{"OK": OK, "FAIL": FAIL, "FREE": FREE, "SOLD": SOLD}
+		expectations := map[string]Status{"OK": OK, "FAIL": FAIL, "FREE": FREE, "SOLD": SOLD, "RESERVED": RESERVED}
 
 		for response, expectedStatus := range expectations {
 			actualStatus, err := ParseResponse(response)
@@ -114,3 +114,59 @@ func TestParseResponse(t *testing.T) {
 		}
 	})
 }
+
+func TestParseResponseTo(t *testing.T) {
+	t.Run("accepts only the responses expected for each verb", func(t *testing.T) {
+		type expectation struct {
+			verb     Verb
+			response string
+		}
+		valid := []expectation{
+			{RESERVE, "OK"}, {RESERVE, "FAIL"},
+			{BUY, "OK"}, {BUY, "FAIL"},
+			{QUERY, "FREE"}, {QUERY, "RESERVED"}, {QUERY, "SOLD"},
+			{Verb("BOUGHT"), "FAIL"},
+		}
+
+		for _, e := range valid {
+			status, err := ParseResponseTo("message", e.verb, e.response)
+			if err != nil {
+				t.Errorf("Expected response [%s] to be valid for verb [%s], got [%v]", e.response, e.verb, err)
+			}
+			if status != Status(e.response) {
+				t.Errorf("Expected response [%s] to parse as itself, got [%s]", e.response, status)
+			}
+		}
+	})
+
+	t.Run("reports each kind of protocol violation", func(t *testing.T) {
+		type expectation struct {
+			verb     Verb
+			response string
+			kind     ViolationKind
+		}
+		invalid := []expectation{
+			{RESERVE, "", EMPTY_RESPONSE},
+			{RESERVE, "OK ", EXTRA_WHITESPACE},
+			{QUERY, "FREE\r", EXTRA_WHITESPACE},
+			{BUY, "ok", WRONG_CASE},
+			{QUERY, "Sold", WRONG_CASE},
+			{BUY, "RESERVED", UNEXPECTED_FOR_VERB},
+			{QUERY, "OK", UNEXPECTED_FOR_VERB},
+			{Verb("BOUGHT"), "OK", UNEXPECTED_FOR_VERB},
+			{RESERVE, "banana", UNKNOWN_RESPONSE},
+		}
+
+		for _, e := range invalid {
+			_, err := ParseResponseTo("message", e.verb, e.response)
+			violation, ok := err.(*ProtocolViolation)
+			if !ok {
+				t.Errorf("Expected protocol violation for response %q to verb [%s], got [%v]", e.response, e.verb, err)
+				continue
+			}
+			if violation.kind != e.kind {
+				t.Errorf("Expected response %q to verb [%s] to be [%s], got [%s]", e.response, e.verb, e.kind, violation.kind)
+			}
+		}
+	})
+}